@@ -33,14 +33,123 @@ type PodDiskInspectorSpec struct {
 	// +kubebuilder:validation:MinLength:=1
 	SidecarImage string `json:"sidecarImage"`
 
+	// SidecarMode is how the sidecar is injected into pods.
+	// "Container" appends it to the pod's containers.
+	// "InitContainer" injects it as a native sidecar, an init container with restartPolicy Always, so it does not
+	// block Jobs from completing. Its readiness probe still counts toward pod readiness.
+	// Requires Kubernetes 1.28+ with the SidecarContainers feature gate enabled.
+	// Pods can override the mode with the pvc-autoscaler-operator.kubernetes.io/sidecar-mode annotation.
+	// +kubebuilder:validation:Enum:=Container;InitContainer
+	// +kubebuilder:default:=Container
+	// +optional
+	SidecarMode SidecarMode `json:"sidecarMode,omitempty"`
+
+	// SidecarTemplate customizes the injected sidecar container, e.g. its resources and security context.
+	// +optional
+	SidecarTemplate *SidecarTemplate `json:"sidecarTemplate,omitempty"`
+
 	// Your cluster must support and use the ExpandInUsePersistentVolumes feature gate. This allows volumes to
 	// expand while a pod is attached to it, thus eliminating the need to restart pods.
 	// If you cluster does not support ExpandInUsePersistentVolumes, you will need to manually restart pods after
 	// resizing is complete.
 	// +optional
 	PVCScaling *PVCScalingSpec `json:"pvcScaling"`
+
+	// Collection configures how often disk usage is collected from the sidecars.
+	// If not set, disk usage is collected every 60 seconds.
+	// +optional
+	Collection *CollectionSpec `json:"collection,omitempty"`
+
+	// Selector selects the pods the sidecar is injected into by their labels.
+	// Pods annotated with pvc-autoscaler-operator.kubernetes.io/operator-name and operator-namespace are always
+	// matched by the named PodDiskInspector, regardless of selectors.
+	// If not set, only annotated pods are matched.
+	// +optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+
+	// NamespaceSelector selects the namespaces of the pods matched by Selector.
+	// If not set, only pods in the PodDiskInspector's namespace are matched. An empty selector matches all namespaces.
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// Volumes selects which PVCs of the matched pods are monitored and scaled.
+	// Pods can override it with the pvc-autoscaler-operator.kubernetes.io/include-volumes and exclude-volumes annotations.
+	// If not set, every PVC is monitored.
+	// +optional
+	Volumes *VolumeFilter `json:"volumes,omitempty"`
+
+	// AllowedNamespaces are the namespaces whose pods may reference the PodDiskInspector with the
+	// pvc-autoscaler-operator.kubernetes.io/operator-name and operator-namespace annotations.
+	// Namespaces matched by Selector and NamespaceSelector are always allowed.
+	// If not set, only pods in the PodDiskInspector's namespace may reference it.
+	// +optional
+	AllowedNamespaces *AllowedNamespaces `json:"allowedNamespaces,omitempty"`
+
+	// Rollout restarts the Deployments and StatefulSets owning pods which are missing the sidecar, e.g. because they
+	// were created while the operator was down, or which run a stale sidecar image.
+	// Each workload is restarted at most once per sidecar image. StatefulSets with the OnDelete update strategy are
+	// not restarted, as changing their pod template has no effect, and only reported with an event.
+	// If not set, drifted pods are only reported in the status.
+	// +optional
+	Rollout *RolloutSpec `json:"rollout,omitempty"`
+
+	// TargetRefs are workloads whose pod template is annotated to opt in to the PodDiskInspector, so their
+	// manifests do not need to be changed. Annotating a workload restarts its pods.
+	// Removing a target removes the annotations added by the operator.
+	// +optional
+	TargetRefs []TargetRef `json:"targetRefs,omitempty"`
+}
+
+// TargetRef is part of the PodDiskInspectorSpec.
+type TargetRef struct {
+	// Kind of the workload.
+	// +kubebuilder:validation:Enum:=Deployment;StatefulSet
+	Kind string `json:"kind"`
+
+	// Name of the workload.
+	// +kubebuilder:validation:MinLength:=1
+	Name string `json:"name"`
+
+	// Namespace of the workload. Defaults to the PodDiskInspector's namespace.
+	// Other namespaces must be allowed by AllowedNamespaces.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// RolloutSpec is part of the PodDiskInspectorSpec.
+type RolloutSpec struct {
+	// MaxRestarts is the maximum number of workloads restarted per Interval.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default:=1
+	// +optional
+	MaxRestarts int32 `json:"maxRestarts,omitempty"`
+
+	// Interval is the minimum time between restarts. Defaults to 10m.
+	// +optional
+	Interval metav1.Duration `json:"interval,omitempty"`
+}
+
+// AllowedNamespaces is part of the PodDiskInspectorSpec.
+// The PodDiskInspector's own namespace and the namespaces matched by its NamespaceSelector are always allowed.
+// Other namespaces are allowed if they are listed in Names or matched by Selector.
+type AllowedNamespaces struct {
+	// Names of the allowed namespaces.
+	// +optional
+	Names []string `json:"names,omitempty"`
+
+	// Selector selects the allowed namespaces by their labels. An empty selector allows all namespaces.
+	// +optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
 }
 
+// SidecarMode is how the sidecar is injected into pods.
+type SidecarMode string
+
+const (
+	SidecarModeContainer     SidecarMode = "Container"
+	SidecarModeInitContainer SidecarMode = "InitContainer"
+)
+
 // PodDiskInspectorStatus defines the observed state of PodDiskInspector
 type PodDiskInspectorStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
@@ -51,8 +160,82 @@ type PodDiskInspectorStatus struct {
 	// +optional
 	// +mapType:=granular
 	PVCScalingStatus map[string]ScalingStatus `json:"pvcScalingStatus,omitempty"`
+
+	// SidecarDrift reports the pods which are missing the sidecar or run a stale sidecar image.
+	// +optional
+	SidecarDrift *SidecarDriftStatus `json:"sidecarDrift,omitempty"`
+
+	// Targets are the workloads targeted by TargetRefs and their injection state.
+	// +optional
+	Targets []TargetStatus `json:"targets,omitempty"`
+}
+
+// TargetStatus is part of the PodDiskInspectorStatus.
+type TargetStatus struct {
+	Kind      string      `json:"kind"`
+	Namespace string      `json:"namespace"`
+	Name      string      `json:"name"`
+	State     TargetState `json:"state"`
+
+	// Pods is the number of running pods of the workload.
+	Pods int32 `json:"pods"`
+
+	// InjectedPods is the number of running pods of the workload with the sidecar.
+	InjectedPods int32 `json:"injectedPods"`
 }
 
+// TargetState is the injection state of a targeted workload.
+type TargetState string
+
+const (
+	// TargetStateInjected means every running pod of the workload has the sidecar.
+	TargetStateInjected TargetState = "Injected"
+	// TargetStatePending means the workload is annotated, but not every running pod has the sidecar yet.
+	TargetStatePending TargetState = "Pending"
+	// TargetStateNotFound means the workload does not exist.
+	TargetStateNotFound TargetState = "NotFound"
+	// TargetStateConflict means the workload is already annotated for another PodDiskInspector or opted out.
+	TargetStateConflict TargetState = "Conflict"
+	// TargetStateUnauthorized means the workload's namespace is not allowed by AllowedNamespaces.
+	TargetStateUnauthorized TargetState = "Unauthorized"
+)
+
+// SidecarDriftStatus is part of the PodDiskInspectorStatus.
+type SidecarDriftStatus struct {
+	// Count is the number of drifted pods.
+	Count int32 `json:"count"`
+
+	// Pods are the drifted pods, limited to the first 50.
+	// +optional
+	Pods []DriftedPod `json:"pods,omitempty"`
+
+	// ObservedAt is when the pods were last checked.
+	ObservedAt metav1.Time `json:"observedAt"`
+
+	// LastRolloutTime is when a workload was last restarted by Rollout.
+	// +optional
+	LastRolloutTime *metav1.Time `json:"lastRolloutTime,omitempty"`
+}
+
+// DriftedPod is part of the SidecarDriftStatus.
+type DriftedPod struct {
+	Namespace string      `json:"namespace"`
+	Name      string      `json:"name"`
+	Reason    DriftReason `json:"reason"`
+
+	// Owner is the Deployment or StatefulSet owning the pod as "Kind/namespace/name", if any.
+	// +optional
+	Owner string `json:"owner,omitempty"`
+}
+
+// DriftReason is why a pod drifted.
+type DriftReason string
+
+const (
+	DriftReasonMissingSidecar DriftReason = "MissingSidecar"
+	DriftReasonStaleImage     DriftReason = "StaleImage"
+)
+
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
 //+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
@@ -12,8 +12,10 @@ const PVCScalingController = "PVCScaling"
 type PVCScalingSpec struct {
 	// The percentage of used disk space required to trigger scaling.
 	// Example, if set to 80, autoscaling will not trigger until used space reaches >=80% of capacity.
+	// Defaults to 80.
 	// +kubebuilder:validation:Minimum=1
-	// +kubebuilder:validation:MaxSize=100
+	// +kubebuilder:validation:Maximum=100
+	// +optional
 	UsedSpacePercentage int32 `json:"usedSpacePercentage"`
 
 	// How much to increase the PVC's capacity.
@@ -23,6 +25,8 @@ type PVCScalingSpec struct {
 	// E.g. PVC of 100Gi capacity + IncreaseQuantity of 20% increases disk to 120Gi.
 	//
 	// If a storage quantity (e.g. 100Gi), increases by that amount.
+	// Defaults to 20%.
+	// +optional
 	IncreaseQuantity string `json:"increaseQuantity"`
 
 	// How long to wait before scaling again.
@@ -33,10 +37,51 @@ type PVCScalingSpec struct {
 	// A resource storage quantity (e.g. 2000Gi).
 	// When increasing PVC capacity reaches >= MaxSize, autoscaling ceases.
 	// Safeguards against storage quotas and costs.
+	// If IncreaseQuantity is a storage quantity, MaxSize must not be less than it.
 	// +optional
 	MaxSize resource.Quantity `json:"maxSize"`
 }
 
+// CollectionSpec is part of the PodDiskInspectorSpec.
+type CollectionSpec struct {
+	// How often to collect disk usage from the sidecars. Defaults to 60s.
+	// +optional
+	Interval metav1.Duration `json:"interval"`
+
+	// Adaptive collects more frequently while any PVC is near its scaling threshold and
+	// backs off while all PVCs are far from it. If set, Interval is only used when no disk usage could be collected.
+	// +optional
+	Adaptive *AdaptiveCollectionSpec `json:"adaptive,omitempty"`
+
+	// The percentage of the interval which is randomly added or subtracted, so that many
+	// PodDiskInspectors do not collect at the same time. Defaults to 10.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=50
+	// +optional
+	JitterPercentage *int32 `json:"jitterPercentage,omitempty"`
+}
+
+// AdaptiveCollectionSpec is part of the CollectionSpec.
+type AdaptiveCollectionSpec struct {
+	// The interval used when any PVC is within NearThresholdPercentage of its UsedSpacePercentage.
+	// Defaults to 15s.
+	// +optional
+	MinInterval metav1.Duration `json:"minInterval"`
+
+	// The interval used when all PVCs are as far as possible from their UsedSpacePercentage.
+	// Defaults to 5m.
+	// +optional
+	MaxInterval metav1.Duration `json:"maxInterval"`
+
+	// How many percentage points below UsedSpacePercentage a PVC is considered near its threshold.
+	// Between NearThresholdPercentage and empty, the interval grows linearly from MinInterval to MaxInterval.
+	// Defaults to 10.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	NearThresholdPercentage int32 `json:"nearThresholdPercentage"`
+}
+
 type ScalingStatus struct {
 	// The PVC size requested by the PVCScaling controller.
 	RequestedSize resource.Quantity `json:"requestedSize"`
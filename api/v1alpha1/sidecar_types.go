@@ -0,0 +1,78 @@
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
+// SidecarTemplate is part of the PodDiskInspectorSpec.
+// It is strategically merged onto the default sidecar container, so only the fields which differ from the
+// defaults need to be set.
+type SidecarTemplate struct {
+	// Resources of the sidecar container.
+	// Defaults to requests of 5m CPU and 16Mi memory and no limits.
+	// +optional
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// SecurityContext of the sidecar container.
+	// +optional
+	SecurityContext *corev1.SecurityContext `json:"securityContext,omitempty"`
+
+	// ImagePullPolicy of the sidecar image. Defaults to IfNotPresent.
+	// +kubebuilder:validation:Enum:=Always;Never;IfNotPresent
+	// +optional
+	ImagePullPolicy corev1.PullPolicy `json:"imagePullPolicy,omitempty"`
+
+	// ImagePullSecrets are added to the pod's image pull secrets, e.g. to pull the sidecar image from a private registry.
+	// +optional
+	ImagePullSecrets []corev1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+
+	// Env is merged by name with the sidecar's environment.
+	// Variables set by the operator, such as HEALTHCHECK_CONFIG, cannot be overridden.
+	// +optional
+	Env []corev1.EnvVar `json:"env,omitempty"`
+
+	// ReadinessProbe overrides the timings of the sidecar's readiness probe.
+	// +optional
+	ReadinessProbe *ProbeTemplate `json:"readinessProbe,omitempty"`
+
+	// LivenessProbe overrides the timings of the sidecar's liveness probe.
+	// +optional
+	LivenessProbe *ProbeTemplate `json:"livenessProbe,omitempty"`
+}
+
+// ProbeTemplate is part of the SidecarTemplate.
+// The probe's handler always targets the sidecar's health endpoints; unset fields keep their defaults.
+type ProbeTemplate struct {
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	InitialDelaySeconds int32 `json:"initialDelaySeconds,omitempty"`
+
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	TimeoutSeconds int32 `json:"timeoutSeconds,omitempty"`
+
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	PeriodSeconds int32 `json:"periodSeconds,omitempty"`
+
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	SuccessThreshold int32 `json:"successThreshold,omitempty"`
+
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	FailureThreshold int32 `json:"failureThreshold,omitempty"`
+}
+
+// VolumeFilter is part of the PodDiskInspectorSpec.
+// Patterns are shell file name patterns, e.g. "data-*", matched against both the name of the volume in the pod spec
+// and the name of its claim.
+type VolumeFilter struct {
+	// Include only monitors the volumes matching any of the patterns. If empty, every volume is included.
+	// +optional
+	Include []string `json:"include,omitempty"`
+
+	// Exclude never monitors the volumes matching any of the patterns, even if they are included.
+	// +optional
+	Exclude []string `json:"exclude,omitempty"`
+}
@@ -2,8 +2,12 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
+	"net"
 	"net/http"
+	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/healthcheck"
@@ -11,6 +15,8 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"golang.org/x/sync/errgroup"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 func healthcheckCmd() *cobra.Command {
@@ -22,8 +28,25 @@ func healthcheckCmd() *cobra.Command {
 	}
 
 	hc.Flags().String("log-format", "console", "'console' or 'json'")
-	hc.Flags().String("pvcs", "", "'pvc names delimited by comma'")
+	hc.Flags().String("config", "", "path to a JSON or YAML file listing the volumes to check, see also $"+healthcheck.ConfigEnv)
+	hc.Flags().String("pvcs", "", "'pvc names delimited by comma', each mounted on "+healthcheck.Mount+"/<pvc>; deprecated, use --config")
+	hc.Flags().Bool("discover", false, "check every volume mounted under "+healthcheck.Mount+" if no volumes are configured")
+	hc.Flags().String("namespace", os.Getenv("POD_NAMESPACE"), "namespace of the pvcs used to label metrics, defaults to $POD_NAMESPACE")
+	hc.Flags().String("pod-name", os.Getenv("POD_NAME"), "name of the pod, used to resolve the claims of generic ephemeral volumes, defaults to $POD_NAME")
+	hc.Flags().String("node-name", os.Getenv("NODE_NAME"), "node the sidecar runs on, reported with disk usage, defaults to $NODE_NAME")
 	hc.Flags().String("addr", fmt.Sprintf(":%d", healthcheck.Port), "listen address for server to bind")
+	hc.Flags().String("grpc-addr", fmt.Sprintf(":%d", healthcheck.GRPCPort), "listen address for gRPC server to bind, empty to disable")
+	hc.Flags().String("local-addr", fmt.Sprintf("127.0.0.1:%d", healthcheck.LocalPort), "loopback listen address for resize requests from containers in the pod, empty to disable")
+	hc.Flags().String("local-token-file", filepath.Join(healthcheck.LocalTokenDir, healthcheck.LocalTokenFile), "file the token required on --local-addr is written to, shared with the containers in the pod")
+	hc.Flags().Duration("resize-request-ttl", healthcheck.DefaultResizeRequestTTL, "how long a resize request is reported to the operator unless satisfied earlier")
+	hc.Flags().String("auth-token-file", "", "if set, require requests to carry the bearer token in this file, except scrapes of /metrics")
+	hc.Flags().String("metrics-token-file", os.Getenv("METRICS_TOKEN_FILE"), "if set, require scrapes of /metrics to carry the bearer token in this file, defaults to $METRICS_TOKEN_FILE")
+	hc.Flags().String("tls-cert-file", "", "if set with --tls-key-file, serve TLS with this certificate")
+	hc.Flags().String("tls-key-file", "", "if set with --tls-cert-file, serve TLS with this key")
+	hc.Flags().Duration("top-interval", 30*time.Second, "minimum interval between requests to /disk/top, which walks the volume")
+	hc.Flags().Duration("sample-interval", 15*time.Second, "how often to sample disk usage to compute growth rate and time to full, 0 to disable")
+	hc.Flags().Int("sample-history", healthcheck.DefaultSampleHistory, "number of samples per pvc used to compute growth rate")
+	hc.Flags().Duration("grpc-interval", 5*time.Second, "how often to check disk usage for changes to stream over gRPC")
 
 	if err := viper.BindPFlags(hc.Flags()); err != nil {
 		panic(err)
@@ -41,24 +64,124 @@ func startHealthCheckServer(cmd *cobra.Command, args []string) error {
 	)
 	defer func() { _ = zlog.Sync() }()
 
+	cfg, err := sidecarConfig()
+	if err != nil {
+		return err
+	}
+	if err = cfg.ResolveEphemeral(viper.GetString("pod-name")); err != nil {
+		return err
+	}
+	volumes := cfg.Volumes
+	logger.Info("Checking volumes", "volumes", volumes, "usageProbe", cfg.UsageProbe)
+
+	var (
+		nodeName = viper.GetString("node-name")
+		stat     = func() ([]healthcheck.DiskUsageResponse, error) { return healthcheck.DiskStats(volumes) }
+		sampler  *healthcheck.Sampler
+	)
+	if interval := viper.GetDuration("sample-interval"); interval > 0 {
+		sampler = healthcheck.NewSampler(volumes, interval, viper.GetInt("sample-history"))
+		stat = sampler.DiskStats
+	}
+	var prober *healthcheck.UsageProber
+	if cfg.UsageProbe != nil {
+		prober = healthcheck.NewUsageProber(*cfg.UsageProbe)
+		stat = prober.Stat(stat)
+	}
+	resizeRequests := healthcheck.NewResizeRequests(volumes, viper.GetDuration("resize-request-ttl"))
+	disk := healthcheck.DiskUsageHandler(volumes, nodeName, resizeRequests.Stat(stat))
+
 	var (
-		pvcs = viper.GetString("pvcs")
-		disk = healthcheck.DiskUsage(pvcs, healthcheck.Mount)
+		tokenFile = viper.GetString("auth-token-file")
+		protect   = func(h http.Handler) http.Handler { return h }
+		grpcOpts  []grpc.ServerOption
 	)
+	if tokenFile != "" {
+		protect = func(h http.Handler) http.Handler { return healthcheck.RequireToken(tokenFile, h) }
+		grpcOpts = append(grpcOpts, grpc.StreamInterceptor(healthcheck.RequireTokenStream(tokenFile)))
+	}
+
+	var (
+		certFile  = viper.GetString("tls-cert-file")
+		keyFile   = viper.GetString("tls-key-file")
+		tlsConfig *tls.Config
+	)
+	if certFile != "" && keyFile != "" {
+		tlsConfig = healthcheck.ServerTLSConfig(certFile, keyFile)
+		grpcOpts = append(grpcOpts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+
+	readyChecks := make(map[string]healthcheck.ReadyCheck)
+	if sampler != nil {
+		readyChecks["sampler"] = sampler.Ready
+	}
 
 	mux := http.NewServeMux()
-	mux.Handle("/disk", disk)
+	// Probes are not authenticated, because the kubelet cannot send the token.
+	mux.Handle("/healthz", healthcheck.Healthz())
+	mux.Handle("/readyz", healthcheck.Readyz(readyChecks))
+	mux.Handle("/disk", protect(disk))
+	mux.Handle("/disk/top", protect(healthcheck.Top(volumes, viper.GetDuration("top-interval"))))
+	// The operator's token is not shared with Prometheus, so metrics have their own token.
+	metrics := healthcheck.Metrics(volumes, viper.GetString("namespace"))
+	if metricsTokenFile := viper.GetString("metrics-token-file"); metricsTokenFile != "" {
+		metrics = healthcheck.RequireToken(metricsTokenFile, metrics)
+	}
+	mux.Handle("/metrics", metrics)
 
 	srv := &http.Server{
 		Addr:         listenAddr,
 		Handler:      mux,
 		ReadTimeout:  30 * time.Second,
 		WriteTimeout: 30 * time.Second,
+		TLSConfig:    tlsConfig,
 	}
 
 	var eg errgroup.Group
+	localAddr := viper.GetString("local-addr")
+	localTokenFile := viper.GetString("local-token-file")
+	if localAddr != "" {
+		if err := healthcheck.WriteToken(localTokenFile); err != nil {
+			// Resize requests are optional, so the sidecar keeps serving the operator.
+			logger.Error(err, "Disabling resize requests", "tokenFile", localTokenFile)
+			localAddr = ""
+		}
+	}
+	if localAddr != "" {
+		localMux := http.NewServeMux()
+		// Not authenticated with the operator's token, which must not be shared with application containers, but
+		// with a token only the containers in the pod can read. The loopback interface is shared by every pod on the
+		// host network.
+		localMux.Handle("/request-resize", healthcheck.RequireToken(localTokenFile, resizeRequests.Handler()))
+		localSrv := &http.Server{
+			Addr:         localAddr,
+			Handler:      localMux,
+			ReadTimeout:  30 * time.Second,
+			WriteTimeout: 30 * time.Second,
+		}
+		eg.Go(func() error {
+			logger.Info("Healthcheck local server listening", "addr", localAddr)
+			return localSrv.ListenAndServe()
+		})
+		eg.Go(func() error {
+			<-cmd.Context().Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			return localSrv.Shutdown(ctx)
+		})
+	}
+	if sampler != nil {
+		eg.Go(func() error {
+			sampler.Run(cmd.Context())
+			return nil
+		})
+	}
 	eg.Go(func() error {
-		logger.Info("Healthcheck server listening", "addr", listenAddr)
+		logger.Info("Healthcheck server listening", "addr", listenAddr, "tls", tlsConfig != nil)
+		if tlsConfig != nil {
+			// The certificate is loaded by TLSConfig.
+			return srv.ListenAndServeTLS("", "")
+		}
 		return srv.ListenAndServe()
 	})
 	eg.Go(func() error {
@@ -69,5 +192,56 @@ func startHealthCheckServer(cmd *cobra.Command, args []string) error {
 		return srv.Shutdown(ctx)
 	})
 
+	if grpcAddr := viper.GetString("grpc-addr"); grpcAddr != "" {
+		grpcSrv := grpc.NewServer(append(grpcOpts, healthcheck.ServerKeepalive()...)...)
+		streamSrv := healthcheck.NewStreamServer(volumes, nodeName, viper.GetDuration("grpc-interval"))
+		if sampler != nil {
+			streamSrv = streamSrv.WithSampler(sampler)
+		}
+		if prober != nil {
+			streamSrv = streamSrv.WithUsageProber(prober)
+		}
+		streamSrv = streamSrv.WithResizeRequests(resizeRequests)
+		streamSrv.Register(grpcSrv)
+
+		eg.Go(func() error {
+			lis, err := net.Listen("tcp", grpcAddr)
+			if err != nil {
+				return fmt.Errorf("grpc listen: %w", err)
+			}
+			logger.Info("Healthcheck gRPC server listening", "addr", grpcAddr)
+			return grpcSrv.Serve(lis)
+		})
+		eg.Go(func() error {
+			<-cmd.Context().Done()
+			logger.Info("Healthcheck gRPC server shutting down")
+			grpcSrv.GracefulStop()
+			return nil
+		})
+	}
+
 	return eg.Wait()
 }
+
+// sidecarConfig returns the volumes to check from, in order of precedence, --config, $HEALTHCHECK_CONFIG,
+// --pvcs or discovery. Only a config file or $HEALTHCHECK_CONFIG can configure a usage probe.
+func sidecarConfig() (healthcheck.Config, error) {
+	if path := viper.GetString("config"); path != "" {
+		return healthcheck.LoadConfig(path)
+	}
+	if data := os.Getenv(healthcheck.ConfigEnv); data != "" {
+		cfg, err := healthcheck.ParseConfig([]byte(data))
+		if err != nil {
+			return cfg, fmt.Errorf("%s: %w", healthcheck.ConfigEnv, err)
+		}
+		return cfg, nil
+	}
+	if pvcs := viper.GetString("pvcs"); pvcs != "" {
+		return healthcheck.Config{Volumes: healthcheck.PVCVolumes(pvcs, healthcheck.Mount)}, nil
+	}
+	if viper.GetBool("discover") {
+		volumes, err := healthcheck.Discover(healthcheck.Mount)
+		return healthcheck.Config{Volumes: volumes}, err
+	}
+	return healthcheck.Config{}, fmt.Errorf("no volumes to check, set --config, $%s, --pvcs or --discover", healthcheck.ConfigEnv)
+}
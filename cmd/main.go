@@ -20,6 +20,7 @@ import (
 	"crypto/x509"
 	"fmt"
 	"os"
+	"path/filepath"
 	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
@@ -30,11 +31,13 @@ import (
 	"net/http"
 	_ "net/http/pprof"
 
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/metrics/server"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
@@ -42,7 +45,10 @@ import (
 
 	"github.com/allthatjazzleo/pvc-autoscaler-operator/api/v1alpha1"
 	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/controllers"
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/healthcheck"
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/inject"
 	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/kube"
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/pvc"
 	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/version"
 	"github.com/go-logr/zapr"
 	"github.com/open-policy-agent/cert-controller/pkg/rotator"
@@ -60,6 +66,7 @@ const (
 	keyName         = "tls.key"
 	secretName      = "pvc-autoscaler-operator-webhook-server-cert"
 	mwhName         = "pvc-autoscaler-operator-mutating-webhook-configuration"
+	vwhName         = "pvc-autoscaler-operator-validating-webhook-configuration"
 )
 
 var (
@@ -93,6 +100,14 @@ var (
 	logLevel             string
 	logFormat            string
 	certDir              string
+
+	diskCollectWorkers  int
+	diskCollectTimeout  time.Duration
+	diskCollectRetries  int
+	diskCollectDeadline time.Duration
+	sidecarTransport    string
+	sidecarAuth         bool
+	sidecarTLS          bool
 )
 
 func rootCmd() *cobra.Command {
@@ -114,6 +129,15 @@ func rootCmd() *cobra.Command {
 	root.Flags().StringVar(&logFormat, "log-format", "console", "Logging format one of 'console' or 'json'")
 	root.Flags().StringVar(&certDir, "cert-dir", "/certs", "The directory where certs are stored, defaults to /certs")
 
+	defaultCollect := pvc.DefaultCollectorOptions()
+	root.Flags().IntVar(&diskCollectWorkers, "disk-collect-workers", defaultCollect.Workers, "Maximum number of healthcheck sidecars queried concurrently per PodDiskInspector.")
+	root.Flags().DurationVar(&diskCollectTimeout, "disk-collect-timeout", defaultCollect.RequestTimeout, "Timeout of a single disk usage request to a healthcheck sidecar.")
+	root.Flags().IntVar(&diskCollectRetries, "disk-collect-retries", defaultCollect.Retries, "Number of retries of a disk usage request failing with a transient error.")
+	root.Flags().StringVar(&sidecarTransport, "sidecar-transport", string(healthcheck.TransportHTTP), "Protocol used to collect disk usage from healthcheck sidecars, one of 'http' or 'grpc'. With 'grpc', sidecars not serving gRPC or whose stream stalled are queried over HTTP.")
+	root.Flags().BoolVar(&sidecarAuth, "sidecar-auth", false, "Require the operator to authenticate with injected healthcheck sidecars using a per-namespace token Secret.")
+	root.Flags().BoolVar(&sidecarTLS, "sidecar-tls", false, "Serve injected healthcheck sidecars over TLS with per-namespace certificates issued from the webhook CA.")
+	root.Flags().DurationVar(&diskCollectDeadline, "disk-collect-deadline", defaultCollect.Deadline, "Deadline of a whole disk usage collection cycle of a PodDiskInspector.")
+
 	if err := viper.BindPFlags(root.Flags()); err != nil {
 		panic(err)
 	}
@@ -150,6 +174,13 @@ func startManager(cmd *cobra.Command, args []string) error {
 
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme: scheme,
+		Client: client.Options{
+			Cache: &client.CacheOptions{
+				// Secrets are read directly, so the operator only needs access to its own Secrets instead of
+				// listing and watching every Secret in the cluster.
+				DisableFor: []client.Object{&corev1.Secret{}},
+			},
+		},
 		Metrics: server.Options{
 			BindAddress: metricsAddr,
 		},
@@ -186,6 +217,10 @@ func startManager(cmd *cobra.Command, args []string) error {
 			Name: mwhName,
 			Type: rotator.Mutating,
 		},
+		{
+			Name: vwhName,
+			Type: rotator.Validating,
+		},
 	}
 	keyUsages := []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
 	setupLog.Info("setting up cert rotation")
@@ -222,11 +257,47 @@ func startManager(cmd *cobra.Command, args []string) error {
 		}
 
 		// An ancillary controller that supports PodDiskInspector.
+		defaultCollect := pvc.DefaultCollectorOptions()
 		httpClient := &http.Client{Timeout: 30 * time.Second}
+		var diskClient *healthcheck.Client
+		switch healthcheck.Transport(sidecarTransport) {
+		case healthcheck.TransportHTTP:
+			diskClient = healthcheck.NewClient(httpClient)
+		case healthcheck.TransportGRPC:
+			diskClient = healthcheck.NewStreamingClient(httpClient)
+			go func() {
+				<-ctx.Done()
+				diskClient.Close()
+			}()
+		default:
+			setupLog.Error(fmt.Errorf("unknown sidecar transport %q", sidecarTransport), "unable to create controller", "controller", "PVCScalingController")
+			os.Exit(1)
+		}
+		// Always attach tokens if present, so sidecars injected with auth keep working if auth is disabled.
+		diskClient = diskClient.WithTokenSource(inject.NewSecretTokenSource(mgr.GetClient()))
+		if sidecarTLS {
+			// The cert rotator's Secret is mounted in certDir, including the CA bundle.
+			diskClient = diskClient.WithTLS(filepath.Join(certDir, "ca.crt"))
+		}
+
+		sidecarOpts := inject.Options{Auth: sidecarAuth, TLS: sidecarTLS}
+		secrets := inject.NewSecretManager(
+			mgr.GetClient(),
+			sidecarOpts,
+			inject.NewCertIssuer(mgr.GetAPIReader(), client.ObjectKey{Namespace: kube.GetNamespace(), Name: secretName}),
+		)
 		if err = controllers.NewPVCScaling(
 			mgr.GetClient(),
 			mgr.GetEventRecorderFor(v1alpha1.PVCScalingController),
-			httpClient,
+			diskClient,
+			pvc.CollectorOptions{
+				Workers:        diskCollectWorkers,
+				RequestTimeout: diskCollectTimeout,
+				Retries:        diskCollectRetries,
+				RetryBackoff:   defaultCollect.RetryBackoff,
+				Deadline:       diskCollectDeadline,
+			},
+			secrets,
 		).SetupWithManager(ctx, mgr); err != nil {
 			setupLog.Error(err, "unable to create controller", "controller", "PVCScalingController")
 			os.Exit(1)
@@ -240,8 +311,13 @@ func startManager(cmd *cobra.Command, args []string) error {
 				mgr.GetClient(),
 				decoder,
 				mgr.GetEventRecorderFor("pod-sidecar-injector"),
+				sidecarOpts,
+				secrets,
 			),
 		})
+		srv.Register(controllers.PodDiskInspectorDefaulterPath, controllers.NewPodDiskInspectorDefaulter(mgr.GetScheme()))
+		srv.Register(controllers.PodDiskInspectorValidatorPath, controllers.NewPodDiskInspectorValidator(mgr.GetScheme()))
+		srv.Register(controllers.AnnotationValidatorPath, &webhook.Admission{Handler: controllers.NewAnnotationValidatorWebhook()})
 	}()
 
 	//+kubebuilder:scaffold:builder
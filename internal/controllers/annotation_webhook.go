@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/api/v1alpha1"
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/healthcheck"
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/inject"
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/kube"
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/pvc"
+)
+
+// AnnotationValidatorPath is the path of the annotation webhook. You need to ensure the path matches the path in the marker.
+const AnnotationValidatorPath = "/validate-v1-autoscaler-annotations"
+
+// +kubebuilder:webhook:path=/validate-v1-autoscaler-annotations,mutating=false,failurePolicy=ignore,groups="core",resources=pods;persistentvolumeclaims,sideEffects=None,verbs=create;update,versions=v1,name=vannotations.autoscaler.allthatjazzleo,admissionReviewVersions=v1
+
+var _ webhook.AdmissionHandler = annotationValidator{}
+
+// NewAnnotationValidatorWebhook returns a webhook rejecting pods and PVCs with malformed autoscaler annotations,
+// which would otherwise be ignored at runtime.
+func NewAnnotationValidatorWebhook() webhook.AdmissionHandler {
+	return annotationValidator{}
+}
+
+type annotationValidator struct{}
+
+// Handle validates the autoscaler annotations of a pod or PVC. On update, only the annotations which changed are
+// validated, so objects created before the webhook are not blocked by unrelated updates such as PVC resizes.
+func (annotationValidator) Handle(_ context.Context, req admission.Request) admission.Response {
+	var obj, old metav1.PartialObjectMetadata
+	if err := json.Unmarshal(req.Object.Raw, &obj); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if req.Operation == admissionv1.Update && len(req.OldObject.Raw) > 0 {
+		if err := json.Unmarshal(req.OldObject.Raw, &old); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+	}
+
+	changed := make(map[string]string)
+	for key, val := range obj.Annotations {
+		if prev, ok := old.Annotations[key]; ok && prev == val {
+			continue
+		}
+		changed[key] = val
+	}
+
+	err := pvc.ValidateSpecAnnotations(changed)
+	if req.Kind.Kind == "Pod" {
+		err = errors.Join(err, validatePodAnnotations(changed))
+	}
+	if err != nil {
+		return admission.Denied(fmt.Sprintf("%s %s: %v", strings.ToLower(req.Kind.Kind), req.Name, err))
+	}
+	return admission.Allowed("")
+}
+
+// validatePodAnnotations returns joined *pvc.AnnotationErrors for the malformed annotations which only apply to pods.
+func validatePodAnnotations(annotations map[string]string) error {
+	var merr error
+	if val, ok := annotations[kube.OperatorMode]; ok {
+		if mode := v1alpha1.SidecarMode(val); mode != v1alpha1.SidecarModeContainer && mode != v1alpha1.SidecarModeInitContainer {
+			merr = errors.Join(merr, &pvc.AnnotationError{Key: kube.OperatorMode, Value: val,
+				Err: fmt.Errorf("must be %s or %s", v1alpha1.SidecarModeContainer, v1alpha1.SidecarModeInitContainer)})
+		}
+	}
+	if val := strings.TrimSpace(annotations[kube.UsageProbeURL]); val != "" {
+		if err := (healthcheck.UsageProbe{URL: val}).Validate(); err != nil {
+			merr = errors.Join(merr, &pvc.AnnotationError{Key: kube.UsageProbeURL, Value: val, Err: err})
+		}
+	}
+	for _, key := range []string{kube.IncludeVolumes, kube.ExcludeVolumes} {
+		val, ok := annotations[key]
+		if !ok {
+			continue
+		}
+		if err := inject.PodVolumeFilter(inject.VolumeFilter{}, map[string]string{key: val}).Validate(); err != nil {
+			merr = errors.Join(merr, &pvc.AnnotationError{Key: key, Value: val, Err: err})
+		}
+	}
+	return merr
+}
@@ -0,0 +1,154 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/kube"
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/pvc"
+)
+
+func annotationRequest(t *testing.T, kind string, op admissionv1.Operation, annotations, oldAnnotations map[string]string) admission.Request {
+	t.Helper()
+	raw := func(annotations map[string]string) []byte {
+		if annotations == nil {
+			return nil
+		}
+		b, err := json.Marshal(metav1.PartialObjectMetadata{
+			TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: kind},
+			ObjectMeta: metav1.ObjectMeta{Name: "obj", Namespace: "default", Annotations: annotations},
+		})
+		require.NoError(t, err)
+		return b
+	}
+	req := admissionv1.AdmissionRequest{
+		Name:      "obj",
+		Namespace: "default",
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: kind},
+		Operation: op,
+		Object:    runtime.RawExtension{Raw: raw(annotations)},
+	}
+	if req.Object.Raw == nil {
+		req.Object.Raw = raw(map[string]string{})
+	}
+	if op == admissionv1.Update {
+		req.OldObject = runtime.RawExtension{Raw: raw(oldAnnotations)}
+	}
+	return admission.Request{AdmissionRequest: req}
+}
+
+func TestAnnotationValidator_Handle(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	handler := NewAnnotationValidatorWebhook()
+
+	t.Run("allowed", func(t *testing.T) {
+		for _, tt := range []struct {
+			Name        string
+			Kind        string
+			Annotations map[string]string
+		}{
+			{"missing annotations", "Pod", nil},
+			{"unrelated annotations", "PersistentVolumeClaim", map[string]string{"other": "value"}},
+			{"valid pvc", "PersistentVolumeClaim", map[string]string{
+				pvc.UsedSpacePercentage: "90",
+				pvc.IncreaseQuantity:    "10Gi",
+				pvc.Cooldown:            "30m",
+				pvc.MaxSize:             "2Ti",
+			}},
+			{"valid pod", "Pod", map[string]string{
+				pvc.UsedSpacePercentage: "90",
+				kube.OperatorMode:       "InitContainer",
+				kube.UsageProbeURL:      "http://127.0.0.1:8080/usage",
+				kube.IncludeVolumes:     "data-*, logs",
+				kube.ExcludeVolumes:     "",
+			}},
+			// Pod only annotations are not validated on PVCs.
+			{"pod annotations on pvc", "PersistentVolumeClaim", map[string]string{kube.OperatorMode: "sidecar"}},
+		} {
+			resp := handler.Handle(ctx, annotationRequest(t, tt.Kind, admissionv1.Create, tt.Annotations, nil))
+
+			require.True(t, resp.Allowed, tt.Name)
+		}
+	})
+
+	t.Run("denied", func(t *testing.T) {
+		for _, tt := range []struct {
+			Name        string
+			Kind        string
+			Annotations map[string]string
+			Err         string
+		}{
+			{"threshold", "PersistentVolumeClaim", map[string]string{pvc.UsedSpacePercentage: "101"}, pvc.UsedSpacePercentage},
+			{"increase", "Pod", map[string]string{pvc.IncreaseQuantity: "twenty"}, pvc.IncreaseQuantity},
+			{"cooldown", "PersistentVolumeClaim", map[string]string{pvc.Cooldown: "forever"}, pvc.Cooldown},
+			{"max size", "PersistentVolumeClaim", map[string]string{pvc.MaxSize: "lots"}, pvc.MaxSize},
+			{"mode", "Pod", map[string]string{kube.OperatorMode: "sidecar"}, kube.OperatorMode},
+			{"usage probe", "Pod", map[string]string{kube.UsageProbeURL: "ftp://example.com"}, kube.UsageProbeURL},
+			{"volume pattern", "Pod", map[string]string{kube.IncludeVolumes: "data-["}, kube.IncludeVolumes},
+		} {
+			resp := handler.Handle(ctx, annotationRequest(t, tt.Kind, admissionv1.Create, tt.Annotations, nil))
+
+			require.False(t, resp.Allowed, tt.Name)
+			require.EqualValues(t, http.StatusForbidden, resp.Result.Code, tt.Name)
+			require.Contains(t, resp.Result.Message, tt.Err, tt.Name)
+		}
+
+		resp := handler.Handle(ctx, annotationRequest(t, "Pod", admissionv1.Create, map[string]string{
+			pvc.Cooldown:      "forever",
+			kube.OperatorMode: "sidecar",
+		}, nil))
+
+		require.False(t, resp.Allowed)
+		require.Contains(t, resp.Result.Message, "pod obj: ")
+		require.Contains(t, resp.Result.Message, pvc.Cooldown)
+		require.Contains(t, resp.Result.Message, kube.OperatorMode)
+	})
+
+	t.Run("update", func(t *testing.T) {
+		invalid := map[string]string{pvc.MaxSize: "lots"}
+
+		// Unchanged invalid annotations do not block unrelated updates.
+		resp := handler.Handle(ctx, annotationRequest(t, "PersistentVolumeClaim", admissionv1.Update,
+			map[string]string{pvc.MaxSize: "lots", "other": "value"}, invalid))
+		require.True(t, resp.Allowed)
+
+		resp = handler.Handle(ctx, annotationRequest(t, "PersistentVolumeClaim", admissionv1.Update,
+			map[string]string{pvc.MaxSize: "more"}, invalid))
+		require.False(t, resp.Allowed)
+
+		resp = handler.Handle(ctx, annotationRequest(t, "PersistentVolumeClaim", admissionv1.Update,
+			map[string]string{pvc.MaxSize: "10Gi"}, invalid))
+		require.True(t, resp.Allowed)
+
+		resp = handler.Handle(ctx, annotationRequest(t, "PersistentVolumeClaim", admissionv1.Update, invalid, nil))
+		require.False(t, resp.Allowed, "missing old object")
+	})
+
+	t.Run("malformed object", func(t *testing.T) {
+		req := annotationRequest(t, "Pod", admissionv1.Create, nil, nil)
+		req.Object.Raw = []byte("{")
+
+		resp := handler.Handle(ctx, req)
+
+		require.False(t, resp.Allowed)
+		require.EqualValues(t, http.StatusBadRequest, resp.Result.Code)
+
+		req = annotationRequest(t, "Pod", admissionv1.Update, nil, nil)
+		req.OldObject.Raw = []byte("{")
+
+		resp = handler.Handle(ctx, req)
+
+		require.False(t, resp.Allowed)
+		require.EqualValues(t, http.StatusBadRequest, resp.Result.Code)
+	})
+}
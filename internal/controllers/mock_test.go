@@ -0,0 +1,37 @@
+package controllers
+
+import (
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/api/v1alpha1"
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/kube"
+)
+
+// newFakeClient returns a fake client with objs, indexing pods by the PodDiskInspector they are annotated with.
+func newFakeClient(objs ...client.Object) client.WithWatch {
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		panic(err)
+	}
+	if err := v1alpha1.AddToScheme(scheme); err != nil {
+		panic(err)
+	}
+	return fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(objs...).
+		WithStatusSubresource(&v1alpha1.PodDiskInspector{}).
+		WithIndex(&corev1.Pod{}, kube.ControllerField, indexPodInspector).
+		Build()
+}
+
+// newTestReporter returns a reporter discarding logs and recording events to a buffered fake recorder.
+func newTestReporter(obj runtime.Object) (kube.EventReporter, *record.FakeRecorder) {
+	recorder := record.NewFakeRecorder(100)
+	return kube.NewEventReporter(logr.Discard(), recorder, obj), recorder
+}
@@ -0,0 +1,253 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/samber/lo"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/api/v1alpha1"
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/kube"
+)
+
+// inspectorSelectors matches pods against the selectors of all PodDiskInspectors. The inspectors are listed once
+// and the labels of each namespace are fetched at most once, so matching many pods costs no extra requests.
+type inspectorSelectors struct {
+	ctx      context.Context
+	c        client.Reader
+	reporter kube.EventReporter
+	// inspectors are sorted oldest first.
+	inspectors []v1alpha1.PodDiskInspector
+	namespaces map[string]func() (labels.Set, error)
+	invalid    map[client.ObjectKey]bool
+}
+
+// newInspectorSelectors lists the PodDiskInspectors, oldest first so conflicting inspectors are always resolved
+// the same way.
+func newInspectorSelectors(ctx context.Context, c client.Reader, reporter kube.EventReporter) (*inspectorSelectors, error) {
+	var list v1alpha1.PodDiskInspectorList
+	if err := c.List(ctx, &list); err != nil {
+		return nil, fmt.Errorf("list PodDiskInspectors: %w", err)
+	}
+	sort.Slice(list.Items, func(i, j int) bool {
+		a, b := list.Items[i], list.Items[j]
+		if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+			return a.CreationTimestamp.Before(&b.CreationTimestamp)
+		}
+		return client.ObjectKeyFromObject(&a).String() < client.ObjectKeyFromObject(&b).String()
+	})
+	return &inspectorSelectors{
+		ctx:        ctx,
+		c:          c,
+		reporter:   reporter,
+		inspectors: list.Items,
+		namespaces: make(map[string]func() (labels.Set, error)),
+		invalid:    make(map[client.ObjectKey]bool),
+	}, nil
+}
+
+// selecting returns the PodDiskInspectors whose Selector and NamespaceSelector match pod in namespace, oldest first.
+// Inspectors with an invalid selector are reported once and skipped.
+func (s *inspectorSelectors) selecting(pod *corev1.Pod, namespace string) []v1alpha1.PodDiskInspector {
+	// The namespace is only fetched if an inspector selects namespaces by label.
+	namespaceLabels, ok := s.namespaces[namespace]
+	if !ok {
+		namespaceLabels = lazyNamespaceLabels(s.ctx, s.c, namespace)
+		s.namespaces[namespace] = namespaceLabels
+	}
+
+	var matches []v1alpha1.PodDiskInspector
+	for i := range s.inspectors {
+		crd := s.inspectors[i]
+		ok, err := selectsPod(crd, labels.Set(pod.Labels), namespace, namespaceLabels)
+		if err != nil {
+			if key := client.ObjectKeyFromObject(&crd); !s.invalid[key] {
+				s.invalid[key] = true
+				s.reporter.UpdateResource(&crd).RecordError("PodSelector", err)
+			}
+			continue
+		}
+		if ok {
+			matches = append(matches, crd)
+		}
+	}
+	return matches
+}
+
+// inspectedPods returns the pods annotated with crd and the pods selected by it, the same way the webhook matches them.
+// Selectors are matched against the current pods, so pods created before crd or while the webhook was unavailable
+// are included.
+func inspectedPods(ctx context.Context, c client.Reader, reporter kube.EventReporter, crd *v1alpha1.PodDiskInspector) ([]*corev1.Pod, error) {
+	key := client.ObjectKeyFromObject(crd)
+	var pods []*corev1.Pod
+
+	var annotated corev1.PodList
+	if err := c.List(ctx, &annotated, client.MatchingFields{kube.ControllerField: key.String()}); err != nil {
+		return nil, fmt.Errorf("list annotated pods: %w", err)
+	}
+	// Pods referencing crd from a namespace it does not allow are skipped, so their PVCs are never scaled.
+	authorized := make(map[string]bool)
+	for i := range annotated.Items {
+		pod := &annotated.Items[i]
+		if !podAnnotated(pod) {
+			continue
+		}
+		ok, checked := authorized[pod.Namespace]
+		if !checked {
+			err := authorizeReference(ctx, c, *crd, pod.Namespace)
+			if err != nil {
+				reporter.RecordError("UnauthorizedReference", fmt.Errorf("skipping pods: %w", err))
+			}
+			ok = err == nil
+			authorized[pod.Namespace] = ok
+		}
+		if ok {
+			pods = append(pods, pod)
+		}
+	}
+
+	if crd.Spec.Selector == nil {
+		return pods, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(crd.Spec.Selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector: %w", err)
+	}
+	// The inspectors and namespace labels are resolved once for all selected pods.
+	selectors, err := newInspectorSelectors(ctx, c, reporter)
+	if err != nil {
+		return nil, err
+	}
+	opts := []client.ListOption{client.MatchingLabelsSelector{Selector: selector}}
+	if crd.Spec.NamespaceSelector == nil {
+		opts = append(opts, client.InNamespace(crd.Namespace))
+	}
+	var selected corev1.PodList
+	if err := c.List(ctx, &selected, opts...); err != nil {
+		return nil, fmt.Errorf("list selected pods: %w", err)
+	}
+	for i := range selected.Items {
+		pod := &selected.Items[i]
+		if podAnnotated(pod) || strings.ToLower(strings.TrimSpace(pod.Annotations[kube.OperatorEnabled])) == "false" {
+			continue
+		}
+		// Only the oldest inspector selecting a pod injects the sidecar.
+		selecting := selectors.selecting(pod, pod.Namespace)
+		if len(selecting) > 0 && client.ObjectKeyFromObject(&selecting[0]) == key {
+			pods = append(pods, pod)
+		}
+	}
+	return pods, nil
+}
+
+// selectsPod returns true if the selectors of crd match a pod with podLabels in namespace.
+// A nil Selector matches no pods; a nil NamespaceSelector matches only the inspector's namespace.
+func selectsPod(crd v1alpha1.PodDiskInspector, podLabels labels.Set, namespace string, namespaceLabels func() (labels.Set, error)) (bool, error) {
+	if crd.Spec.Selector == nil {
+		return false, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(crd.Spec.Selector)
+	if err != nil {
+		return false, fmt.Errorf("invalid selector: %w", err)
+	}
+	if !selector.Matches(podLabels) {
+		return false, nil
+	}
+
+	if crd.Spec.NamespaceSelector == nil {
+		return crd.Namespace == namespace, nil
+	}
+	return matchesNamespace(crd.Spec.NamespaceSelector, "namespaceSelector", namespaceLabels)
+}
+
+// allowsNamespace returns true if pods in namespace may reference crd with annotations.
+// The inspector's own namespace and the namespaces its selectors match pods in are always allowed, as the webhook
+// stamps the annotations onto the pods it selects. A nil AllowedNamespaces allows no other namespace.
+func allowsNamespace(crd v1alpha1.PodDiskInspector, namespace string, namespaceLabels func() (labels.Set, error)) (bool, error) {
+	allowed := crd.Spec.AllowedNamespaces
+	if crd.Namespace == namespace {
+		return true, nil
+	}
+	if crd.Spec.Selector != nil && crd.Spec.NamespaceSelector != nil {
+		ok, err := matchesNamespace(crd.Spec.NamespaceSelector, "namespaceSelector", namespaceLabels)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	if allowed == nil {
+		return false, nil
+	}
+	if lo.Contains(allowed.Names, namespace) {
+		return true, nil
+	}
+	if allowed.Selector == nil {
+		return false, nil
+	}
+	return matchesNamespace(allowed.Selector, "allowedNamespaces selector", namespaceLabels)
+}
+
+// matchesNamespace returns true if labelSelector, described by name in errors, matches the namespace labels.
+// An empty selector matches all namespaces without fetching the labels.
+func matchesNamespace(labelSelector *metav1.LabelSelector, name string, namespaceLabels func() (labels.Set, error)) (bool, error) {
+	nsSelector, err := metav1.LabelSelectorAsSelector(labelSelector)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s: %w", name, err)
+	}
+	if nsSelector.Empty() {
+		return true, nil
+	}
+	nsLabels, err := namespaceLabels()
+	if err != nil {
+		return false, err
+	}
+	return nsSelector.Matches(nsLabels), nil
+}
+
+// authorizeReference returns an error if pods in namespace may not reference crd with annotations.
+func authorizeReference(ctx context.Context, c client.Reader, crd v1alpha1.PodDiskInspector, namespace string) error {
+	ok, err := allowsNamespace(crd, namespace, lazyNamespaceLabels(ctx, c, namespace))
+	if err != nil {
+		return fmt.Errorf("authorize namespace %s: %w", namespace, err)
+	}
+	if !ok {
+		return fmt.Errorf("namespace %s is not allowed to reference %s", namespace, client.ObjectKeyFromObject(&crd))
+	}
+	return nil
+}
+
+// lazyNamespaceLabels returns a func fetching the labels of namespace on its first call.
+func lazyNamespaceLabels(ctx context.Context, c client.Reader, namespace string) func() (labels.Set, error) {
+	var (
+		nsLabels labels.Set
+		fetched  bool
+	)
+	return func() (labels.Set, error) {
+		if fetched {
+			return nsLabels, nil
+		}
+		ns := new(corev1.Namespace)
+		if err := c.Get(ctx, client.ObjectKey{Name: namespace}, ns); err != nil {
+			return nil, fmt.Errorf("get namespace %s: %w", namespace, err)
+		}
+		nsLabels, fetched = labels.Set(ns.Labels), true
+		return nsLabels, nil
+	}
+}
+
+// inspectorNames returns the namespace/name of each crd as a comma delimited list.
+func inspectorNames(crds []v1alpha1.PodDiskInspector) string {
+	names := make([]string, len(crds))
+	for i := range crds {
+		names[i] = client.ObjectKeyFromObject(&crds[i]).String()
+	}
+	return strings.Join(names, ", ")
+}
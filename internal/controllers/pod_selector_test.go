@@ -0,0 +1,258 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/api/v1alpha1"
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/kube"
+)
+
+func newTestInspector(name, namespace string, created time.Time) *v1alpha1.PodDiskInspector {
+	return &v1alpha1.PodDiskInspector{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, CreationTimestamp: metav1.NewTime(created)},
+		Spec: v1alpha1.PodDiskInspectorSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}},
+		},
+	}
+}
+
+func newTestPod(name, namespace string, labels, annotations map[string]string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels, Annotations: annotations},
+		Status:     corev1.PodStatus{Phase: corev1.PodRunning},
+	}
+}
+
+func inspectorAnnotations(crd *v1alpha1.PodDiskInspector) map[string]string {
+	return map[string]string{
+		kube.OperatorEnabled:   "true",
+		kube.OperatorName:      crd.Name,
+		kube.OperatorNamespace: crd.Namespace,
+	}
+}
+
+func podNames(pods []*corev1.Pod) []string {
+	return lo.Map(pods, func(pod *corev1.Pod, _ int) string { return client.ObjectKeyFromObject(pod).String() })
+}
+
+// countingClient counts the requests per object type.
+type countingClient struct {
+	client.Client
+	requests map[string]int
+}
+
+func (c *countingClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	c.requests[fmt.Sprintf("get %T", obj)]++
+	return c.Client.Get(ctx, key, obj, opts...)
+}
+
+func (c *countingClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	c.requests[fmt.Sprintf("list %T", list)]++
+	return c.Client.List(ctx, list, opts...)
+}
+
+func TestInspectedPods(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	now := time.Now()
+	db := map[string]string{"app": "db"}
+
+	t.Run("annotated and selected", func(t *testing.T) {
+		crd := newTestInspector("inspector", "default", now)
+		c := newFakeClient(crd,
+			newTestPod("annotated", "default", nil, inspectorAnnotations(crd)),
+			// Created before the inspector or while the webhook was unavailable.
+			newTestPod("selected", "default", db, nil),
+			newTestPod("opted-out", "default", db, map[string]string{kube.OperatorEnabled: "false"}),
+			newTestPod("other-namespace", "other", db, nil),
+			newTestPod("other-labels", "default", map[string]string{"app": "web"}, nil),
+		)
+		reporter, _ := newTestReporter(crd)
+
+		got, err := inspectedPods(ctx, c, reporter, crd)
+
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{"default/annotated", "default/selected"}, podNames(got))
+	})
+
+	t.Run("namespace selector", func(t *testing.T) {
+		crd := newTestInspector("inspector", "default", now)
+		crd.Spec.NamespaceSelector = &metav1.LabelSelector{}
+		c := newFakeClient(crd, newTestPod("selected", "other", db, nil))
+		reporter, _ := newTestReporter(crd)
+
+		got, err := inspectedPods(ctx, c, reporter, crd)
+
+		require.NoError(t, err)
+		require.Equal(t, []string{"other/selected"}, podNames(got))
+	})
+
+	t.Run("oldest inspector wins", func(t *testing.T) {
+		older := newTestInspector("older", "default", now.Add(-time.Hour))
+		crd := newTestInspector("inspector", "default", now)
+		c := newFakeClient(older, crd, newTestPod("selected", "default", db, nil))
+		reporter, _ := newTestReporter(crd)
+
+		got, err := inspectedPods(ctx, c, reporter, crd)
+		require.NoError(t, err)
+		require.Empty(t, got)
+
+		got, err = inspectedPods(ctx, c, reporter, older)
+		require.NoError(t, err)
+		require.Equal(t, []string{"default/selected"}, podNames(got))
+	})
+
+	t.Run("unauthorized references", func(t *testing.T) {
+		crd := newTestInspector("inspector", "default", now)
+		crd.Spec.Selector = nil
+		crd.Spec.AllowedNamespaces = &v1alpha1.AllowedNamespaces{Names: []string{"allowed"}}
+		c := newFakeClient(crd,
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "allowed"}},
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "other"}},
+			newTestPod("own", "default", nil, inspectorAnnotations(crd)),
+			newTestPod("allowed", "allowed", nil, inspectorAnnotations(crd)),
+			newTestPod("unauthorized-0", "other", nil, inspectorAnnotations(crd)),
+			newTestPod("unauthorized-1", "other", nil, inspectorAnnotations(crd)),
+		)
+		reporter, recorder := newTestReporter(crd)
+
+		got, err := inspectedPods(ctx, c, reporter, crd)
+
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{"default/own", "allowed/allowed"}, podNames(got))
+		require.Len(t, recorder.Events, 1, "recorded once per namespace")
+		require.Contains(t, <-recorder.Events, "UnauthorizedReference skipping pods: namespace other is not allowed to reference default/inspector")
+	})
+
+	t.Run("selected namespaces are authorized", func(t *testing.T) {
+		crd := newTestInspector("inspector", "default", now)
+		crd.Spec.NamespaceSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}}
+		c := newFakeClient(crd,
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Labels: map[string]string{"team": "a"}}},
+			// Injected by the webhook, which stamped the annotations.
+			newTestPod("injected", "team-a", db, inspectorAnnotations(crd)),
+		)
+		reporter, recorder := newTestReporter(crd)
+
+		got, err := inspectedPods(ctx, c, reporter, crd)
+
+		require.NoError(t, err)
+		require.Equal(t, []string{"team-a/injected"}, podNames(got))
+		require.Empty(t, recorder.Events)
+	})
+
+	t.Run("inspectors and namespaces are resolved once", func(t *testing.T) {
+		crd := newTestInspector("inspector", "default", now)
+		crd.Spec.NamespaceSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}}
+		c := &countingClient{
+			Client: newFakeClient(crd,
+				&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Labels: map[string]string{"team": "a"}}},
+				&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-b"}},
+				newTestPod("db-0", "team-a", db, nil),
+				newTestPod("db-1", "team-a", db, nil),
+				newTestPod("db-0", "team-b", db, nil),
+				newTestPod("db-1", "team-b", db, nil),
+			),
+			requests: make(map[string]int),
+		}
+		reporter, _ := newTestReporter(crd)
+
+		got, err := inspectedPods(ctx, c, reporter, crd)
+
+		require.NoError(t, err)
+		require.Equal(t, []string{"team-a/db-0", "team-a/db-1"}, podNames(got))
+		require.Equal(t, map[string]int{
+			"list *v1.PodList":                    2,
+			"list *v1alpha1.PodDiskInspectorList": 1,
+			"get *v1.Namespace":                   2,
+		}, c.requests)
+	})
+
+	t.Run("no selector", func(t *testing.T) {
+		crd := newTestInspector("inspector", "default", now)
+		crd.Spec.Selector = nil
+		c := newFakeClient(crd, newTestPod("selected", "default", db, nil))
+		reporter, _ := newTestReporter(crd)
+
+		got, err := inspectedPods(ctx, c, reporter, crd)
+
+		require.NoError(t, err)
+		require.Empty(t, got)
+	})
+}
+
+func TestAllowsNamespace(t *testing.T) {
+	t.Parallel()
+
+	nsLabels := func(set labels.Set) func() (labels.Set, error) {
+		return func() (labels.Set, error) { return set, nil }
+	}
+	team := labels.Set{"team": "a"}
+	selector := &metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}}
+
+	for _, tt := range []struct {
+		Name      string
+		Spec      v1alpha1.PodDiskInspectorSpec
+		Namespace string
+		Labels    labels.Set
+		Want      bool
+	}{
+		{"own namespace", v1alpha1.PodDiskInspectorSpec{}, "default", nil, true},
+		{"not allowed by default", v1alpha1.PodDiskInspectorSpec{}, "other", team, false},
+		{"names", v1alpha1.PodDiskInspectorSpec{AllowedNamespaces: &v1alpha1.AllowedNamespaces{Names: []string{"other"}}}, "other", nil, true},
+		{"not in names", v1alpha1.PodDiskInspectorSpec{AllowedNamespaces: &v1alpha1.AllowedNamespaces{Names: []string{"team-a"}}}, "other", nil, false},
+		{"selector", v1alpha1.PodDiskInspectorSpec{AllowedNamespaces: &v1alpha1.AllowedNamespaces{Selector: selector}}, "other", team, true},
+		{"selector mismatch", v1alpha1.PodDiskInspectorSpec{AllowedNamespaces: &v1alpha1.AllowedNamespaces{Selector: selector}}, "other", nil, false},
+		{"empty selector", v1alpha1.PodDiskInspectorSpec{AllowedNamespaces: &v1alpha1.AllowedNamespaces{Selector: &metav1.LabelSelector{}}}, "other", nil, true},
+		{
+			"namespace selector",
+			v1alpha1.PodDiskInspectorSpec{Selector: &metav1.LabelSelector{}, NamespaceSelector: selector},
+			"other", team, true,
+		},
+		{
+			"namespace selector mismatch",
+			v1alpha1.PodDiskInspectorSpec{Selector: &metav1.LabelSelector{}, NamespaceSelector: selector},
+			"other", nil, false,
+		},
+		{
+			"namespace selector without selector",
+			v1alpha1.PodDiskInspectorSpec{NamespaceSelector: &metav1.LabelSelector{}},
+			"other", nil, false,
+		},
+	} {
+		crd := v1alpha1.PodDiskInspector{ObjectMeta: metav1.ObjectMeta{Name: "inspector", Namespace: "default"}, Spec: tt.Spec}
+
+		got, err := allowsNamespace(crd, tt.Namespace, nsLabels(tt.Labels))
+
+		require.NoError(t, err, tt.Name)
+		require.Equal(t, tt.Want, got, tt.Name)
+	}
+
+	crd := v1alpha1.PodDiskInspector{Spec: v1alpha1.PodDiskInspectorSpec{
+		AllowedNamespaces: &v1alpha1.AllowedNamespaces{Selector: &metav1.LabelSelector{
+			MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "team", Operator: "Unknown"}},
+		}},
+	}}
+	_, err := allowsNamespace(crd, "other", nsLabels(nil))
+	require.ErrorContains(t, err, "invalid allowedNamespaces selector")
+}
+
+func TestIndexPodInspector(t *testing.T) {
+	t.Parallel()
+
+	crd := newTestInspector("inspector", "default", time.Now())
+	require.Equal(t, []string{"default/inspector"}, indexPodInspector(newTestPod("pod", "other", nil, inspectorAnnotations(crd))))
+	require.Nil(t, indexPodInspector(newTestPod("pod", "other", nil, nil)))
+	require.Nil(t, indexPodInspector(newTestPod("pod", "other", nil, map[string]string{kube.OperatorName: "inspector"})))
+}
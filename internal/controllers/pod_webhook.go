@@ -3,10 +3,12 @@ package controllers
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strings"
 
+	"github.com/samber/lo"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -15,38 +17,53 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 
 	"github.com/allthatjazzleo/pvc-autoscaler-operator/api/v1alpha1"
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/healthcheck"
 	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/inject"
 	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/kube"
 )
 
 var _ webhook.AdmissionHandler = (*podInterceptor)(nil)
 
-// NewPodInterceptorWebhook creates a new pod mutating webhook to be registered
-func NewPodInterceptorWebhook(c client.Client, decoder *admission.Decoder, recorder record.EventRecorder) webhook.AdmissionHandler {
+// NewPodInterceptorWebhook creates a new pod mutating webhook to be registered.
+// SidecarOpts are the defaults for every injected sidecar; the image is set per pod.
+// Secrets creates the sidecar Secret mounted by sidecars injected with sidecarOpts.
+func NewPodInterceptorWebhook(
+	c client.Client,
+	decoder *admission.Decoder,
+	recorder record.EventRecorder,
+	sidecarOpts inject.Options,
+	secrets *inject.SecretManager,
+) webhook.AdmissionHandler {
 	return &podInterceptor{
-		client:   c,
-		decoder:  decoder,
-		recorder: recorder,
+		client:      c,
+		decoder:     decoder,
+		recorder:    recorder,
+		sidecarOpts: sidecarOpts,
+		secrets:     secrets,
 	}
 }
 
 // You need to ensure the path here match the path in the marker.
-// +kubebuilder:webhook:path=/mutate-v1-pod-sidecar-injector,mutating=true,failurePolicy=ignore,groups="core",resources=pods,sideEffects=None,verbs=create;update,versions=v1,name=mpod.sidecar-injector.kb.io,admissionReviewVersions=v1
+// +kubebuilder:webhook:path=/mutate-v1-pod-sidecar-injector,mutating=true,failurePolicy=ignore,groups="core",resources=pods,sideEffects=NoneOnDryRun,verbs=create;update,versions=v1,name=mpod.sidecar-injector.kb.io,admissionReviewVersions=v1
 
-// +kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list
+// +kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch
 // +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch;update;patch
 // +kubebuilder:rbac:groups=core,resources=events,verbs=create;update;patch
 // +kubebuilder:rbac:groups=core,resources=secrets,resourceNames=pvc-autoscaler-operator-webhook-server-cert,verbs=get;list;watch;update;patch;create
+// +kubebuilder:rbac:groups=core,resources=secrets,resourceNames=pvc-autoscaler-operator-sidecar,verbs=get;update
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=create
 // +kubebuilder:rbac:groups=admissionregistration.k8s.io,resources=mutatingwebhookconfigurations,resourceNames=pvc-autoscaler-operator-mutating-webhook-configuration,verbs=get;list;watch;update
 
 // podInterceptor label pods if Sidecar is specified in pod
 type podInterceptor struct {
-	client   client.Client
-	decoder  *admission.Decoder
-	recorder record.EventRecorder
+	client      client.Client
+	decoder     *admission.Decoder
+	recorder    record.EventRecorder
+	sidecarOpts inject.Options
+	secrets     *inject.SecretManager
 }
 
-// Handle adds a label to a generated pod if pod or namespace provide annotaion
+// Handle injects the sidecar into a pod annotated with a PodDiskInspector or matched by its selectors.
 func (d *podInterceptor) Handle(ctx context.Context, req admission.Request) admission.Response {
 	// Get the CRD
 	crd := new(v1alpha1.PodDiskInspector)
@@ -66,34 +83,80 @@ func (d *podInterceptor) Handle(ctx context.Context, req admission.Request) admi
 	enabled := strings.ToLower(strings.TrimSpace(pod.Annotations[kube.OperatorEnabled]))
 	name := strings.TrimSpace(pod.Annotations[kube.OperatorName])
 	namespace := strings.TrimSpace(pod.Annotations[kube.OperatorNamespace])
-	image := strings.TrimSpace(pod.Annotations[kube.OperatorImage])
 
-	if enabled == "true" && name != "" && namespace != "" {
-		key := client.ObjectKey{Name: name, Namespace: namespace}
-		if err := d.client.Get(ctx, key, crd); err != nil {
-			msg := "no CRD found for the operator, don't do anything"
-			return admission.Allowed(msg)
+	// Pods can opt out of selectors with the enabled annotation.
+	if enabled == "false" {
+		return admission.Allowed("no action needed")
+	}
+
+	// The pod's namespace is empty if the pod is created in the request's namespace.
+	podNamespace := lo.Ternary(pod.Namespace != "", pod.Namespace, req.Namespace)
+	var selecting []v1alpha1.PodDiskInspector
+	selectors, err := newInspectorSelectors(ctx, d.client, reporter)
+	if err != nil {
+		reporter.Error(err, "failed to match pod selectors")
+	} else {
+		selecting = selectors.selecting(pod, podNamespace)
+	}
+
+	// The annotations take precedence over selectors.
+	annotated := enabled == "true" && name != "" && namespace != ""
+	if annotated || len(selecting) > 0 {
+		if annotated {
+			key := client.ObjectKey{Name: name, Namespace: namespace}
+			if err := d.client.Get(ctx, key, crd); err != nil {
+				msg := "no CRD found for the operator, don't do anything"
+				return admission.Allowed(msg)
+			}
+			if err := authorizeReference(ctx, d.client, *crd, podNamespace); err != nil {
+				err = fmt.Errorf("pod %s/%s: %w", podNamespace, podName(pod), err)
+				reporter.UpdateResource(crd).RecordError("UnauthorizedReference", err)
+				return admission.Allowed("sidecar not injected").WithWarnings(err.Error())
+			}
+		} else {
+			crd = &selecting[0]
 		}
 		reporter = reporter.UpdateResource(crd)
 
-		if image == "" {
-			image = crd.Spec.SidecarImage
+		key := client.ObjectKeyFromObject(crd)
+		if others := lo.Filter(selecting, func(other v1alpha1.PodDiskInspector, _ int) bool {
+			return client.ObjectKeyFromObject(&other) != key
+		}); len(others) > 0 {
+			reporter.RecordError("PodSelectorConflict", fmt.Errorf("pod %s/%s is also selected by %s, using %s",
+				podNamespace, podName(pod), inspectorNames(others), key))
 		}
 
 		// Add healtcheck sidecar if pod doesn't have one named "diskhealthcheck"
-		for _, container := range pod.Spec.Containers {
-			if container.Name == "diskhealthcheck" {
-				return admission.Allowed("no action needed")
-			}
+		if inject.HasSidecar(pod) {
+			return admission.Allowed("no action needed")
 		}
 
 		// Inject healthcheck sidecar
-		sidecar, err := inject.Sidecar(pod, image)
-		if err != nil {
+		sidecar, opts, err := sidecarFor(ctx, d.client, reporter, crd, pod, podNamespace, d.sidecarOpts)
+		switch {
+		case errors.Is(err, errPodSecurity):
+			reporter.RecordError("PodSecurity", err)
+			return admission.Allowed("sidecar not injected").WithWarnings(err.Error())
+		case err != nil:
 			reporter.RecordError("InjectHealthcheckSidecar", err)
 			return admission.Allowed("no pvc to monitor, no action")
 		}
-		pod.Spec.Containers = append(pod.Spec.Containers, sidecar)
+		if d.secrets.Enabled() && !lo.FromPtr(req.DryRun) {
+			if err = d.secrets.Ensure(ctx, podNamespace); err != nil {
+				// The sidecar rejects requests until the secret exists; the PVCScaling controller retries creating it.
+				reporter.RecordError("InjectHealthcheckSidecar", fmt.Errorf("sidecar secret: %w", err))
+			}
+		}
+		inject.Inject(pod, sidecar, opts)
+		if !annotated {
+			// The PVCScaling controller finds the pods of an inspector by these annotations.
+			if pod.Annotations == nil {
+				pod.Annotations = make(map[string]string)
+			}
+			pod.Annotations[kube.OperatorEnabled] = "true"
+			pod.Annotations[kube.OperatorName] = crd.Name
+			pod.Annotations[kube.OperatorNamespace] = crd.Namespace
+		}
 
 		marshaledPod, err := json.Marshal(pod)
 		if err != nil {
@@ -106,6 +169,113 @@ func (d *podInterceptor) Handle(ctx context.Context, req admission.Request) admi
 	return admission.Allowed("no action needed")
 }
 
+// errPodSecurity is returned by sidecarFor if pod security admission would reject the pod with the sidecar.
+var errPodSecurity = errors.New("sidecar does not comply with the pod security standard")
+
+// sidecarFor returns the sidecar the webhook injects into pod, created in podNamespace, for crd and the options
+// completed from crd and the pod's annotations. Malformed annotations are reported and ignored.
+// It returns an error if the sidecar is not injected: because the pod has no PVCs to monitor, or wrapping
+// errPodSecurity if the sidecar does not comply with the pod security standard enforced in podNamespace.
+func sidecarFor(ctx context.Context, c client.Reader, reporter kube.Reporter, crd *v1alpha1.PodDiskInspector, pod *corev1.Pod, podNamespace string, opts inject.Options) (corev1.Container, inject.Options, error) {
+	image := strings.TrimSpace(pod.Annotations[kube.OperatorImage])
+	if image == "" {
+		image = crd.Spec.SidecarImage
+	}
+
+	mode := v1alpha1.SidecarMode(strings.TrimSpace(pod.Annotations[kube.OperatorMode]))
+	if mode == "" {
+		mode = crd.Spec.SidecarMode
+	}
+	if mode != "" && mode != v1alpha1.SidecarModeContainer && mode != v1alpha1.SidecarModeInitContainer {
+		reporter.RecordError("InjectHealthcheckSidecar", fmt.Errorf("pod %s: unknown sidecar mode %q, injecting as a container", podName(pod), mode))
+		mode = v1alpha1.SidecarModeContainer
+	}
+
+	opts.Image = image
+	opts.Native = mode == v1alpha1.SidecarModeInitContainer
+	if filter := crd.Spec.Volumes; filter != nil {
+		opts.Volumes = inject.VolumeFilter{Include: filter.Include, Exclude: filter.Exclude}
+	}
+	filter := inject.PodVolumeFilter(opts.Volumes, pod.Annotations)
+	if err := filter.Validate(); err != nil {
+		reporter.RecordError("InjectHealthcheckSidecar", fmt.Errorf("pod %s: %w, ignoring volume annotations", podName(pod), err))
+	} else {
+		opts.Volumes = filter
+	}
+	if tmpl := crd.Spec.SidecarTemplate; tmpl != nil {
+		opts.Template = sidecarTemplate(*tmpl)
+		opts.ImagePullSecrets = tmpl.ImagePullSecrets
+	}
+	if probeURL := strings.TrimSpace(pod.Annotations[kube.UsageProbeURL]); probeURL != "" {
+		probe := &healthcheck.UsageProbe{URL: probeURL}
+		if err := probe.Validate(); err != nil {
+			reporter.RecordError("InjectHealthcheckSidecar", fmt.Errorf("pod %s: %w, ignoring usage probe", podName(pod), err))
+		} else {
+			opts.UsageProbe = probe
+		}
+	}
+
+	sidecar, err := inject.Sidecar(pod, opts)
+	if err != nil {
+		return corev1.Container{}, opts, err
+	}
+	// Pod security admission would reject the pod with a sidecar which does not comply, so it is not injected.
+	if level, violations := podSecurityViolations(ctx, c, podNamespace, pod, sidecar); len(violations) > 0 {
+		return corev1.Container{}, opts, fmt.Errorf("pod %s: %w %q enforced in namespace %s: %s",
+			podName(pod), errPodSecurity, level, podNamespace, strings.Join(violations, ", "))
+	}
+	return sidecar, opts, nil
+}
+
+// podSecurityViolations returns the Pod Security Standards level enforced in namespace and why sidecar does not
+// comply with it.
+func podSecurityViolations(ctx context.Context, c client.Reader, namespace string, pod *corev1.Pod, sidecar corev1.Container) (string, []string) {
+	ns := new(corev1.Namespace)
+	if err := c.Get(ctx, client.ObjectKey{Name: namespace}, ns); err != nil {
+		// Inject anyway; the sidecar complies with the restricted level unless its template says otherwise.
+		log.FromContext(ctx).Error(err, "failed to get namespace", "namespace", namespace)
+		return "", nil
+	}
+	level := ns.Labels[kube.PodSecurityEnforce]
+	return level, inject.PodSecurityViolations(level, pod, sidecar)
+}
+
+// sidecarTemplate returns the partial container merged onto the default sidecar.
+func sidecarTemplate(tmpl v1alpha1.SidecarTemplate) *corev1.Container {
+	container := &corev1.Container{
+		ImagePullPolicy: tmpl.ImagePullPolicy,
+		SecurityContext: tmpl.SecurityContext,
+		Env:             tmpl.Env,
+		ReadinessProbe:  probeTemplate(tmpl.ReadinessProbe),
+		LivenessProbe:   probeTemplate(tmpl.LivenessProbe),
+	}
+	if tmpl.Resources != nil {
+		container.Resources = *tmpl.Resources
+	}
+	return container
+}
+
+func probeTemplate(tmpl *v1alpha1.ProbeTemplate) *corev1.Probe {
+	if tmpl == nil {
+		return nil
+	}
+	return &corev1.Probe{
+		InitialDelaySeconds: tmpl.InitialDelaySeconds,
+		TimeoutSeconds:      tmpl.TimeoutSeconds,
+		PeriodSeconds:       tmpl.PeriodSeconds,
+		SuccessThreshold:    tmpl.SuccessThreshold,
+		FailureThreshold:    tmpl.FailureThreshold,
+	}
+}
+
+// podName returns the name of pod, or its generateName if the name is not set yet.
+func podName(pod *corev1.Pod) string {
+	if pod.Name != "" {
+		return pod.Name
+	}
+	return pod.GenerateName
+}
+
 // podInterceptor implements admission.DecoderInjector.
 // A decoder will be automatically injected.
 
@@ -19,11 +19,16 @@ package controllers
 import (
 	"context"
 
+	"github.com/samber/lo"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 
 	autoscalerv1alpha1 "github.com/allthatjazzleo/pvc-autoscaler-operator/api/v1alpha1"
 	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/kube"
@@ -46,10 +51,8 @@ var (
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
-// TODO(user): Modify the Reconcile function to compare the state specified by
-// the PodDiskInspector object against the actual cluster state, and then
-// perform operations to make the cluster state reflect the state specified by
-// the user.
+// It annotates the workloads in TargetRefs, reports the pods which are missing the sidecar or run a stale sidecar
+// image in the status and, if Rollout is set, restarts their workloads.
 //
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.14.1/pkg/reconcile
@@ -69,13 +72,85 @@ func (r *PodDiskInspectorReconciler) Reconcile(ctx context.Context, req ctrl.Req
 		log.Error(err, "unable to fetch PodDiskInspector")
 		return ctrl.Result{}, err
 	}
+	reporter := kube.NewEventReporter(log, r.Recorder, crd)
 
-	return ctrl.Result{}, nil
+	// Targeted workloads keep the annotations added by the operator unless they are removed before deletion.
+	if !crd.DeletionTimestamp.IsZero() {
+		if !controllerutil.ContainsFinalizer(crd, targetRefsFinalizer) {
+			return ctrl.Result{}, nil
+		}
+		if err := r.untargetAll(ctx, reporter, crd); err != nil {
+			reporter.Error(err, "Failed to remove annotations from targets")
+			return ctrl.Result{}, err
+		}
+		controllerutil.RemoveFinalizer(crd, targetRefsFinalizer)
+		return ctrl.Result{}, r.Update(ctx, crd)
+	}
+	hasTargets := len(crd.Spec.TargetRefs) > 0 || len(crd.Status.Targets) > 0
+	if hasTargets != controllerutil.ContainsFinalizer(crd, targetRefsFinalizer) {
+		if hasTargets {
+			controllerutil.AddFinalizer(crd, targetRefsFinalizer)
+		} else {
+			controllerutil.RemoveFinalizer(crd, targetRefsFinalizer)
+		}
+		if err := r.Update(ctx, crd); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	patch := client.MergeFrom(crd.DeepCopy())
+	targets, err := r.reconcileTargets(ctx, reporter, crd)
+	if err != nil {
+		reporter.Error(err, "Failed to reconcile targets")
+		// Record the targets processed so far, so the annotations added to them are removed once untargeted.
+		crd.Status.Targets = targets
+		if err := r.Status().Patch(ctx, crd, patch); err != nil {
+			reporter.Error(err, "Failed to update status")
+		}
+		return ctrl.Result{}, err
+	}
+
+	// The webhook ignores failures, so pods created while the operator was down miss the sidecar.
+	drifted, err := r.driftedPods(ctx, reporter, crd)
+	if err != nil {
+		reporter.Error(err, "Failed to check pods for sidecar drift")
+		return ctrl.Result{}, err
+	}
+
+	status := &autoscalerv1alpha1.SidecarDriftStatus{Count: int32(len(drifted)), ObservedAt: metav1.Now()}
+	if prev := crd.Status.SidecarDrift; prev != nil {
+		status.LastRolloutTime = prev.LastRolloutTime
+	}
+	for _, item := range lo.Slice(drifted, 0, maxDriftedPods) {
+		status.Pods = append(status.Pods, autoscalerv1alpha1.DriftedPod{
+			Namespace: item.pod.Namespace,
+			Name:      item.pod.Name,
+			Reason:    item.reason,
+			Owner:     item.owner(),
+		})
+	}
+
+	requeue := driftCheckInterval
+	if crd.Spec.Rollout != nil {
+		if len(drifted) > 0 && r.rollout(ctx, reporter, crd, drifted) {
+			status.LastRolloutTime = lo.ToPtr(metav1.Now())
+		}
+		requeue = lo.Ternary(crd.Spec.Rollout.Interval.Duration > 0, crd.Spec.Rollout.Interval.Duration, defaultRolloutInterval)
+	}
+
+	crd.Status.SidecarDrift = status
+	crd.Status.Targets = targets
+	if err := r.Status().Patch(ctx, crd, patch); err != nil {
+		reporter.Error(err, "Failed to update status")
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{RequeueAfter: requeue}, nil
 }
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *PodDiskInspectorReconciler) SetupWithManager(_ context.Context, mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&autoscalerv1alpha1.PodDiskInspector{}).
+		// Status updates are ignored, pods are checked periodically instead.
+		For(&autoscalerv1alpha1.PodDiskInspector{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
 		Complete(r)
 }
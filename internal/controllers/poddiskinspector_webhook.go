@@ -0,0 +1,258 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/samber/lo"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/api/v1alpha1"
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/inject"
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/pvc"
+)
+
+// Paths of the PodDiskInspector webhooks. You need to ensure the paths match the paths in the markers.
+const (
+	PodDiskInspectorDefaulterPath = "/mutate-autoscaler-allthatjazzleo-v1alpha1-poddiskinspector"
+	PodDiskInspectorValidatorPath = "/validate-autoscaler-allthatjazzleo-v1alpha1-poddiskinspector"
+)
+
+// +kubebuilder:webhook:path=/mutate-autoscaler-allthatjazzleo-v1alpha1-poddiskinspector,mutating=true,failurePolicy=fail,groups=autoscaler.allthatjazzleo,resources=poddiskinspectors,sideEffects=None,verbs=create;update,versions=v1alpha1,name=mpoddiskinspector.autoscaler.allthatjazzleo,admissionReviewVersions=v1
+// +kubebuilder:webhook:path=/validate-autoscaler-allthatjazzleo-v1alpha1-poddiskinspector,mutating=false,failurePolicy=fail,groups=autoscaler.allthatjazzleo,resources=poddiskinspectors,sideEffects=None,verbs=create;update,versions=v1alpha1,name=vpoddiskinspector.autoscaler.allthatjazzleo,admissionReviewVersions=v1
+
+// +kubebuilder:rbac:groups=admissionregistration.k8s.io,resources=validatingwebhookconfigurations,resourceNames=pvc-autoscaler-operator-validating-webhook-configuration,verbs=get;list;watch;update
+
+// NewPodDiskInspectorDefaulter returns a webhook filling the defaults of PodDiskInspectors, so they are visible
+// in the resource instead of only applied at runtime.
+func NewPodDiskInspectorDefaulter(scheme *runtime.Scheme) *admission.Webhook {
+	return admission.WithCustomDefaulter(scheme, &v1alpha1.PodDiskInspector{}, podDiskInspectorDefaulter{})
+}
+
+// NewPodDiskInspectorValidator returns a webhook rejecting PodDiskInspectors which would only fail at runtime,
+// e.g. because of a malformed IncreaseQuantity.
+func NewPodDiskInspectorValidator(scheme *runtime.Scheme) *admission.Webhook {
+	return admission.WithCustomValidator(scheme, &v1alpha1.PodDiskInspector{}, podDiskInspectorValidator{})
+}
+
+var _ webhook.CustomDefaulter = podDiskInspectorDefaulter{}
+
+type podDiskInspectorDefaulter struct{}
+
+// Default implements webhook.CustomDefaulter.
+func (podDiskInspectorDefaulter) Default(_ context.Context, obj runtime.Object) error {
+	crd, ok := obj.(*v1alpha1.PodDiskInspector)
+	if !ok {
+		return fmt.Errorf("expected a PodDiskInspector but got %T", obj)
+	}
+	spec := &crd.Spec
+	if spec.SidecarMode == "" {
+		spec.SidecarMode = v1alpha1.SidecarModeContainer
+	}
+
+	if scaling := spec.PVCScaling; scaling != nil {
+		if scaling.UsedSpacePercentage == 0 {
+			scaling.UsedSpacePercentage = pvc.DefaultUsedSpacePercentage
+		}
+		if strings.TrimSpace(scaling.IncreaseQuantity) == "" {
+			scaling.IncreaseQuantity = pvc.DefaultIncreaseQuantity
+		}
+	}
+
+	if rollout := spec.Rollout; rollout != nil {
+		if rollout.MaxRestarts == 0 {
+			rollout.MaxRestarts = 1
+		}
+		if rollout.Interval.Duration == 0 {
+			rollout.Interval = metav1.Duration{Duration: defaultRolloutInterval}
+		}
+	}
+
+	collection := spec.Collection
+	if collection == nil {
+		return nil
+	}
+	if collection.Interval.Duration == 0 {
+		collection.Interval = metav1.Duration{Duration: pvc.DefaultCollectionInterval}
+	}
+	if collection.JitterPercentage == nil {
+		collection.JitterPercentage = lo.ToPtr(int32(pvc.DefaultJitterPercentage))
+	}
+	if adaptive := collection.Adaptive; adaptive != nil {
+		if adaptive.MinInterval.Duration == 0 {
+			adaptive.MinInterval = metav1.Duration{Duration: pvc.DefaultMinCollectionInterval}
+		}
+		if adaptive.MaxInterval.Duration == 0 {
+			adaptive.MaxInterval = metav1.Duration{Duration: pvc.DefaultMaxCollectionInterval}
+		}
+		if adaptive.NearThresholdPercentage == 0 {
+			adaptive.NearThresholdPercentage = pvc.DefaultNearThresholdPercentage
+		}
+	}
+	return nil
+}
+
+var _ webhook.CustomValidator = podDiskInspectorValidator{}
+
+type podDiskInspectorValidator struct{}
+
+// ValidateCreate implements webhook.CustomValidator.
+func (v podDiskInspectorValidator) ValidateCreate(_ context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return v.validate(obj)
+}
+
+// ValidateUpdate implements webhook.CustomValidator.
+func (v podDiskInspectorValidator) ValidateUpdate(_ context.Context, _, newObj runtime.Object) (admission.Warnings, error) {
+	return v.validate(newObj)
+}
+
+// ValidateDelete implements webhook.CustomValidator.
+func (podDiskInspectorValidator) ValidateDelete(context.Context, runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
+
+func (podDiskInspectorValidator) validate(obj runtime.Object) (admission.Warnings, error) {
+	crd, ok := obj.(*v1alpha1.PodDiskInspector)
+	if !ok {
+		return nil, fmt.Errorf("expected a PodDiskInspector but got %T", obj)
+	}
+	var (
+		spec     = crd.Spec
+		path     = field.NewPath("spec")
+		errs     field.ErrorList
+		warnings admission.Warnings
+	)
+
+	if !imageReference.MatchString(spec.SidecarImage) {
+		errs = append(errs, field.Invalid(path.Child("sidecarImage"), spec.SidecarImage, "must be an image reference, e.g. repository:tag"))
+	}
+
+	if scaling := spec.PVCScaling; scaling != nil {
+		path := path.Child("pvcScaling")
+		if err := pvc.ValidateIncreaseQuantity(scaling.IncreaseQuantity); err != nil {
+			errs = append(errs, field.Invalid(path.Child("increaseQuantity"), scaling.IncreaseQuantity, err.Error()))
+		}
+		if scaling.UsedSpacePercentage < 1 || scaling.UsedSpacePercentage > 100 {
+			errs = append(errs, field.Invalid(path.Child("usedSpacePercentage"), scaling.UsedSpacePercentage, "must be between 1 and 100"))
+		}
+		if scaling.Cooldown.Duration < 0 {
+			errs = append(errs, field.Invalid(path.Child("cooldown"), scaling.Cooldown.Duration.String(), "must not be negative"))
+		}
+		// A zero MaxSize disables the limit.
+		switch {
+		case scaling.MaxSize.Sign() < 0:
+			errs = append(errs, field.Invalid(path.Child("maxSize"), scaling.MaxSize.String(), "must not be negative"))
+		case scaling.MaxSize.Sign() > 0:
+			errs = append(errs, validateMaxSize(path, scaling)...)
+			warnings = append(warnings, maxSizeWarnings(crd.Status.PVCScalingStatus, scaling.MaxSize)...)
+		}
+		if scaling.UsedSpacePercentage == 100 {
+			warnings = append(warnings, "spec.pvcScaling.usedSpacePercentage: 100 only scales PVCs which are already full")
+		}
+	}
+
+	if collection := spec.Collection; collection != nil {
+		path := path.Child("collection")
+		if collection.Interval.Duration < 0 {
+			errs = append(errs, field.Invalid(path.Child("interval"), collection.Interval.Duration.String(), "must not be negative"))
+		}
+		if adaptive := collection.Adaptive; adaptive != nil && adaptive.MaxInterval.Duration > 0 &&
+			adaptive.MinInterval.Duration > adaptive.MaxInterval.Duration {
+			errs = append(errs, field.Invalid(path.Child("adaptive", "minInterval"), adaptive.MinInterval.Duration.String(), "must not be greater than maxInterval"))
+		}
+	}
+
+	if _, err := metav1.LabelSelectorAsSelector(spec.Selector); err != nil {
+		errs = append(errs, field.Invalid(path.Child("selector"), spec.Selector, err.Error()))
+	}
+	if _, err := metav1.LabelSelectorAsSelector(spec.NamespaceSelector); err != nil {
+		errs = append(errs, field.Invalid(path.Child("namespaceSelector"), spec.NamespaceSelector, err.Error()))
+	}
+
+	if allowed := spec.AllowedNamespaces; allowed != nil {
+		if _, err := metav1.LabelSelectorAsSelector(allowed.Selector); err != nil {
+			errs = append(errs, field.Invalid(path.Child("allowedNamespaces", "selector"), allowed.Selector, err.Error()))
+		}
+	}
+
+	if rollout := spec.Rollout; rollout != nil && rollout.Interval.Duration < 0 {
+		errs = append(errs, field.Invalid(path.Child("rollout", "interval"), rollout.Interval.Duration.String(), "must not be negative"))
+	}
+
+	for i, ref := range spec.TargetRefs {
+		ref.Namespace = lo.Ternary(ref.Namespace != "", ref.Namespace, crd.Namespace)
+		if lo.ContainsBy(spec.TargetRefs[:i], func(other v1alpha1.TargetRef) bool {
+			other.Namespace = lo.Ternary(other.Namespace != "", other.Namespace, crd.Namespace)
+			return other == ref
+		}) {
+			errs = append(errs, field.Duplicate(path.Child("targetRefs").Index(i), ref))
+		}
+	}
+
+	if filter := spec.Volumes; filter != nil {
+		if err := (inject.VolumeFilter{Include: filter.Include, Exclude: filter.Exclude}).Validate(); err != nil {
+			errs = append(errs, field.Invalid(path.Child("volumes"), filter, err.Error()))
+		}
+	}
+
+	if tmpl := spec.SidecarTemplate; tmpl != nil {
+		for i, env := range tmpl.Env {
+			if lo.Contains(inject.OperatorEnv, env.Name) {
+				warnings = append(warnings, fmt.Sprintf("spec.sidecarTemplate.env[%d]: %s is set by the operator and is ignored", i, env.Name))
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return warnings, apierrors.NewInvalid(v1alpha1.GroupVersion.WithKind("PodDiskInspector").GroupKind(), crd.Name, errs)
+	}
+	return warnings, nil
+}
+
+// validateMaxSize returns an error if MaxSize is less than a single resize step, e.g. because the units were mixed up,
+// which would resize every PVC straight to MaxSize.
+func validateMaxSize(path *field.Path, scaling *v1alpha1.PVCScalingSpec) field.ErrorList {
+	step, err := resource.ParseQuantity(strings.TrimSpace(scaling.IncreaseQuantity))
+	if err != nil || scaling.MaxSize.Cmp(step) >= 0 {
+		// Percentages are relative to the current size of each PVC.
+		return nil
+	}
+	return field.ErrorList{field.Invalid(path.Child("maxSize"), scaling.MaxSize.String(),
+		fmt.Sprintf("must not be less than increaseQuantity %s", step.String()))}
+}
+
+// maxSizeWarnings warns about the PVCs already resized beyond maxSize, which are no longer scaled.
+func maxSizeWarnings(status map[string]v1alpha1.ScalingStatus, maxSize resource.Quantity) admission.Warnings {
+	keys := lo.Keys(status)
+	sort.Strings(keys)
+	var warnings admission.Warnings
+	for _, key := range keys {
+		if requested := status[key].RequestedSize; requested.Cmp(maxSize) > 0 {
+			warnings = append(warnings, fmt.Sprintf("spec.pvcScaling.maxSize: pvc %s was already resized to %s and is no longer scaled", key, requested.String()))
+		}
+	}
+	return warnings
+}
+
+// imageReference matches a docker image reference such as "ghcr.io/org/image:v1.0.0" or "image@sha256:<digest>".
+// Adapted from github.com/distribution/reference.
+var imageReference = func() *regexp.Regexp {
+	const (
+		domainComponent = `(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9])`
+		domain          = domainComponent + `(?:\.` + domainComponent + `)*(?::[0-9]+)?`
+		pathComponent   = `[a-z0-9]+(?:(?:[._]|__|[-]*)[a-z0-9]+)*`
+		name            = `(?:` + domain + `/)?` + pathComponent + `(?:/` + pathComponent + `)*`
+		tag             = `[\w][\w.-]{0,127}`
+		digest          = `[A-Za-z][A-Za-z0-9]*(?:[-_+.][A-Za-z][A-Za-z0-9]*)*:[0-9A-Fa-f]{32,}`
+	)
+	return regexp.MustCompile(`^` + name + `(?::` + tag + `)?(?:@` + digest + `)?$`)
+}()
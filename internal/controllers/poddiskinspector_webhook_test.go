@@ -0,0 +1,302 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/api/v1alpha1"
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/pvc"
+)
+
+func validInspector() *v1alpha1.PodDiskInspector {
+	return &v1alpha1.PodDiskInspector{
+		ObjectMeta: metav1.ObjectMeta{Name: "inspector", Namespace: "default"},
+		Spec: v1alpha1.PodDiskInspectorSpec{
+			SidecarImage: "ghcr.io/allthatjazzleo/pvc-autoscaler-operator:v1.0.0",
+			PVCScaling: &v1alpha1.PVCScalingSpec{
+				UsedSpacePercentage: 80,
+				IncreaseQuantity:    "20%",
+				Cooldown:            metav1.Duration{Duration: 6 * time.Hour},
+				MaxSize:             resource.MustParse("16Ti"),
+			},
+		},
+	}
+}
+
+func TestImageReference(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		Image string
+		Valid bool
+	}{
+		{"busybox", true},
+		{"busybox:latest", true},
+		{"library/busybox:1.36", true},
+		{"ghcr.io/allthatjazzleo/pvc-autoscaler-operator:v1.0.0", true},
+		{"localhost:5000/image:dev", true},
+		{"registry.example.com:443/org/sub/image_name.v2:tag-1.0", true},
+		{"image@sha256:" + "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", true},
+		{"ghcr.io/org/image:v1@sha256:" + "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", true},
+
+		{"", false},
+		{" busybox", false},
+		{"busybox:", false},
+		{"BusyBox:latest", false},
+		{"busybox:lat est", false},
+		{"busybox::latest", false},
+		{"-busybox", false},
+		{"ghcr.io/org/image:" + strings.Repeat("a", 128), true},
+		{"ghcr.io/org/image:" + strings.Repeat("a", 129), false},
+		{"image@sha256:abc", false},
+		{"image@:0123456789abcdef0123456789abcdef", false},
+		{"https://ghcr.io/org/image:v1", false},
+	} {
+		require.Equal(t, tt.Valid, imageReference.MatchString(tt.Image), tt.Image)
+	}
+}
+
+func TestPodDiskInspectorValidator(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	validator := podDiskInspectorValidator{}
+
+	t.Run("valid", func(t *testing.T) {
+		warnings, err := validator.ValidateCreate(ctx, validInspector())
+
+		require.NoError(t, err)
+		require.Empty(t, warnings)
+	})
+
+	t.Run("invalid image", func(t *testing.T) {
+		crd := validInspector()
+		crd.Spec.SidecarImage = "ghcr.io/org/image:"
+
+		_, err := validator.ValidateUpdate(ctx, validInspector(), crd)
+
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "spec.sidecarImage")
+	})
+
+	t.Run("pvc scaling", func(t *testing.T) {
+		for _, tt := range []struct {
+			Name   string
+			Modify func(spec *v1alpha1.PVCScalingSpec)
+			Err    string
+		}{
+			{"threshold too low", func(spec *v1alpha1.PVCScalingSpec) { spec.UsedSpacePercentage = 0 }, "spec.pvcScaling.usedSpacePercentage"},
+			{"threshold too high", func(spec *v1alpha1.PVCScalingSpec) { spec.UsedSpacePercentage = 101 }, "spec.pvcScaling.usedSpacePercentage"},
+			{"malformed increase", func(spec *v1alpha1.PVCScalingSpec) { spec.IncreaseQuantity = "20 %" }, "spec.pvcScaling.increaseQuantity"},
+			{"zero increase", func(spec *v1alpha1.PVCScalingSpec) { spec.IncreaseQuantity = "0Gi" }, "must increase the capacity"},
+			{"negative cooldown", func(spec *v1alpha1.PVCScalingSpec) { spec.Cooldown.Duration = -time.Hour }, "spec.pvcScaling.cooldown"},
+			{"negative max size", func(spec *v1alpha1.PVCScalingSpec) { spec.MaxSize = resource.MustParse("-1Gi") }, "spec.pvcScaling.maxSize"},
+			{
+				"max size less than increase",
+				func(spec *v1alpha1.PVCScalingSpec) {
+					spec.IncreaseQuantity = "100Gi"
+					spec.MaxSize = resource.MustParse("10Gi")
+				},
+				"spec.pvcScaling.maxSize: Invalid value: \"10Gi\": must not be less than increaseQuantity 100Gi",
+			},
+		} {
+			crd := validInspector()
+			tt.Modify(crd.Spec.PVCScaling)
+
+			_, err := validator.ValidateCreate(ctx, crd)
+
+			require.Error(t, err, tt.Name)
+			require.Contains(t, err.Error(), tt.Err, tt.Name)
+		}
+
+		for _, tt := range []struct {
+			Name   string
+			Modify func(spec *v1alpha1.PVCScalingSpec)
+		}{
+			{"threshold bounds", func(spec *v1alpha1.PVCScalingSpec) { spec.UsedSpacePercentage = 1 }},
+			{"no cooldown", func(spec *v1alpha1.PVCScalingSpec) { spec.Cooldown.Duration = 0 }},
+			{"no max size", func(spec *v1alpha1.PVCScalingSpec) { spec.MaxSize = resource.Quantity{} }},
+			{"max size equals increase", func(spec *v1alpha1.PVCScalingSpec) {
+				spec.IncreaseQuantity = "100Gi"
+				spec.MaxSize = resource.MustParse("100Gi")
+			}},
+			{"max size with percentage", func(spec *v1alpha1.PVCScalingSpec) {
+				spec.IncreaseQuantity = "500%"
+				spec.MaxSize = resource.MustParse("1Gi")
+			}},
+			{"no max size with increase", func(spec *v1alpha1.PVCScalingSpec) {
+				spec.IncreaseQuantity = "100Gi"
+				spec.MaxSize = resource.Quantity{}
+			}},
+		} {
+			crd := validInspector()
+			tt.Modify(crd.Spec.PVCScaling)
+
+			_, err := validator.ValidateCreate(ctx, crd)
+
+			require.NoError(t, err, tt.Name)
+		}
+
+		crd := validInspector()
+		crd.Spec.PVCScaling.UsedSpacePercentage = 100
+		warnings, err := validator.ValidateCreate(ctx, crd)
+
+		require.NoError(t, err)
+		require.Len(t, warnings, 1)
+		require.Contains(t, warnings[0], "spec.pvcScaling.usedSpacePercentage")
+	})
+
+	t.Run("max size below current size", func(t *testing.T) {
+		crd := validInspector()
+		crd.Spec.PVCScaling.MaxSize = resource.MustParse("100Gi")
+		crd.Status.PVCScalingStatus = map[string]v1alpha1.ScalingStatus{
+			"default/data-1": {RequestedSize: resource.MustParse("200Gi")},
+			"default/data-0": {RequestedSize: resource.MustParse("150Gi")},
+			"default/data-2": {RequestedSize: resource.MustParse("100Gi")},
+		}
+
+		warnings, err := validator.ValidateUpdate(ctx, validInspector(), crd)
+
+		require.NoError(t, err)
+		require.Equal(t, []string{
+			"spec.pvcScaling.maxSize: pvc default/data-0 was already resized to 150Gi and is no longer scaled",
+			"spec.pvcScaling.maxSize: pvc default/data-1 was already resized to 200Gi and is no longer scaled",
+		}, []string(warnings))
+	})
+
+	t.Run("target refs", func(t *testing.T) {
+		for _, tt := range []struct {
+			Name string
+			Refs []v1alpha1.TargetRef
+			Err  string
+		}{
+			{
+				"duplicate",
+				[]v1alpha1.TargetRef{{Kind: "Deployment", Name: "app"}, {Kind: "Deployment", Name: "app"}},
+				"spec.targetRefs[1]: Duplicate value",
+			},
+			{
+				"duplicate with default namespace",
+				[]v1alpha1.TargetRef{{Kind: "StatefulSet", Name: "db"}, {Kind: "Deployment", Name: "app"}, {Kind: "StatefulSet", Name: "db", Namespace: "default"}},
+				"spec.targetRefs[2]: Duplicate value",
+			},
+		} {
+			crd := validInspector()
+			crd.Spec.TargetRefs = tt.Refs
+
+			_, err := validator.ValidateCreate(ctx, crd)
+
+			require.Error(t, err, tt.Name)
+			require.Contains(t, err.Error(), tt.Err, tt.Name)
+		}
+
+		for _, tt := range []struct {
+			Name string
+			Refs []v1alpha1.TargetRef
+		}{
+			{"different kinds", []v1alpha1.TargetRef{{Kind: "Deployment", Name: "app"}, {Kind: "StatefulSet", Name: "app"}}},
+			{"different namespaces", []v1alpha1.TargetRef{{Kind: "Deployment", Name: "app"}, {Kind: "Deployment", Name: "app", Namespace: "other"}}},
+			{"different names", []v1alpha1.TargetRef{{Kind: "Deployment", Name: "app"}, {Kind: "Deployment", Name: "web"}}},
+		} {
+			crd := validInspector()
+			crd.Spec.TargetRefs = tt.Refs
+
+			_, err := validator.ValidateCreate(ctx, crd)
+
+			require.NoError(t, err, tt.Name)
+		}
+	})
+
+	t.Run("selectors", func(t *testing.T) {
+		invalid := &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "app", Operator: "Unknown"}}}
+		crd := validInspector()
+		crd.Spec.Selector = invalid
+		crd.Spec.NamespaceSelector = invalid
+		crd.Spec.AllowedNamespaces = &v1alpha1.AllowedNamespaces{Selector: invalid}
+
+		_, err := validator.ValidateCreate(ctx, crd)
+
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "spec.selector")
+		require.Contains(t, err.Error(), "spec.namespaceSelector")
+		require.Contains(t, err.Error(), "spec.allowedNamespaces.selector")
+	})
+
+	t.Run("wrong type", func(t *testing.T) {
+		_, err := validator.ValidateCreate(ctx, &v1alpha1.PodDiskInspectorList{})
+
+		require.Error(t, err)
+	})
+}
+
+func TestPodDiskInspectorDefaulter(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	defaulter := podDiskInspectorDefaulter{}
+
+	t.Run("empty", func(t *testing.T) {
+		crd := &v1alpha1.PodDiskInspector{}
+
+		require.NoError(t, defaulter.Default(ctx, crd))
+
+		require.Equal(t, v1alpha1.SidecarModeContainer, crd.Spec.SidecarMode)
+		require.Nil(t, crd.Spec.PVCScaling)
+		require.Nil(t, crd.Spec.Collection)
+		require.Nil(t, crd.Spec.Rollout)
+	})
+
+	t.Run("pvc scaling", func(t *testing.T) {
+		crd := &v1alpha1.PodDiskInspector{Spec: v1alpha1.PodDiskInspectorSpec{PVCScaling: &v1alpha1.PVCScalingSpec{}}}
+
+		require.NoError(t, defaulter.Default(ctx, crd))
+
+		require.Equal(t, &v1alpha1.PVCScalingSpec{
+			UsedSpacePercentage: pvc.DefaultUsedSpacePercentage,
+			IncreaseQuantity:    pvc.DefaultIncreaseQuantity,
+		}, crd.Spec.PVCScaling)
+
+		_, err := podDiskInspectorValidator{}.ValidateCreate(ctx, &v1alpha1.PodDiskInspector{Spec: v1alpha1.PodDiskInspectorSpec{
+			SidecarImage: "busybox",
+			PVCScaling:   crd.Spec.PVCScaling,
+		}})
+		require.NoError(t, err)
+	})
+
+	t.Run("keeps values", func(t *testing.T) {
+		crd := validInspector()
+		crd.Spec.SidecarMode = v1alpha1.SidecarModeInitContainer
+		crd.Spec.PVCScaling.UsedSpacePercentage = 90
+		crd.Spec.PVCScaling.IncreaseQuantity = "10Gi"
+		want := crd.DeepCopy()
+
+		require.NoError(t, defaulter.Default(ctx, crd))
+
+		require.Equal(t, want, crd)
+	})
+
+	t.Run("collection and rollout", func(t *testing.T) {
+		crd := &v1alpha1.PodDiskInspector{Spec: v1alpha1.PodDiskInspectorSpec{
+			Collection: &v1alpha1.CollectionSpec{Adaptive: &v1alpha1.AdaptiveCollectionSpec{}},
+			Rollout:    &v1alpha1.RolloutSpec{},
+		}}
+
+		require.NoError(t, defaulter.Default(ctx, crd))
+
+		collection := crd.Spec.Collection
+		require.Equal(t, pvc.DefaultCollectionInterval, collection.Interval.Duration)
+		require.Equal(t, lo.ToPtr(int32(pvc.DefaultJitterPercentage)), collection.JitterPercentage)
+		require.Equal(t, pvc.DefaultMinCollectionInterval, collection.Adaptive.MinInterval.Duration)
+		require.Equal(t, pvc.DefaultMaxCollectionInterval, collection.Adaptive.MaxInterval.Duration)
+		require.EqualValues(t, pvc.DefaultNearThresholdPercentage, collection.Adaptive.NearThresholdPercentage)
+		require.EqualValues(t, 1, crd.Spec.Rollout.MaxRestarts)
+		require.Equal(t, defaultRolloutInterval, crd.Spec.Rollout.Interval.Duration)
+	})
+}
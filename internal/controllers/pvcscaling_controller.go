@@ -20,14 +20,16 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"net/http"
+	"math/rand"
 	"strings"
-	"time"
+	"sync"
 
 	"github.com/allthatjazzleo/pvc-autoscaler-operator/api/v1alpha1"
 	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/healthcheck"
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/inject"
 	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/kube"
 	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/pvc"
+	"github.com/samber/lo"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/record"
@@ -47,22 +49,33 @@ type PVCScalingReconciler struct {
 	diskClient    *pvc.DiskUsageCollector
 	pvcAutoScaler *pvc.PVCAutoScaler
 	recorder      record.EventRecorder
+	secrets       *inject.SecretManager
+	// divergent holds the keys of the PVCs whose samples diverged in their last collection.
+	divergent sync.Map
 }
 
+// NewPVCScaling returns a PVCScalingReconciler. If secrets is enabled, it ensures the sidecar Secret is up to date
+// in every namespace with pods of a PodDiskInspector.
 func NewPVCScaling(
 	client client.Client,
 	recorder record.EventRecorder,
-	httpClient *http.Client,
+	diskClient *healthcheck.Client,
+	collectorOpts pvc.CollectorOptions,
+	secrets *inject.SecretManager,
 ) *PVCScalingReconciler {
-	return &PVCScalingReconciler{
+	r := &PVCScalingReconciler{
 		Client:        client,
-		diskClient:    pvc.NewDiskUsageCollector(healthcheck.NewClient(httpClient), client),
-		pvcAutoScaler: pvc.NewPVCAutoScaler(client),
+		pvcAutoScaler: pvc.NewPVCAutoScaler(client).WithResizeHook(pvc.NewResizeSnapshotter(client, diskClient, recorder).Snapshot),
 		recorder:      recorder,
+		secrets:       secrets,
 	}
+	r.diskClient = pvc.NewDiskUsageCollector(diskClient, client, collectorOpts).WithRecorder(recorder)
+	return r
 }
 
 // +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=secrets,resourceNames=pvc-autoscaler-operator-sidecar,verbs=get;update
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=create
 // +kubebuilder:rbac:groups=core,resources=persistentvolumeclaims,verbs=get;list;watch;update;patch
 
 // Reconcile reconciles only the pvcScaling spec in PodDiskInspector.
@@ -81,18 +94,32 @@ func (r *PVCScalingReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 	}
 	reporter = reporter.UpdateResource(crd)
 
-	r.pvcAutoScale(ctx, reporter, crd)
+	// The pods are listed once per cycle, for both the secrets and the disk usage.
+	pods, err := r.collectedPods(ctx, crd)
+	if r.secrets.Enabled() {
+		if err != nil {
+			reporter.Error(err, "Failed to list pods")
+		} else {
+			r.ensureSecrets(ctx, reporter, pods)
+		}
+	}
 
-	return ctrl.Result{RequeueAfter: 60 * time.Second}, nil
+	usage := r.pvcAutoScale(ctx, reporter, crd, func(context.Context, *v1alpha1.PodDiskInspector) ([]corev1.Pod, error) {
+		return pods, err
+	})
+
+	return ctrl.Result{RequeueAfter: pvc.CollectionInterval(crd.Spec.Collection, usage, rand.Float64)}, nil
 }
 
-func (r *PVCScalingReconciler) pvcAutoScale(ctx context.Context, reporter kube.Reporter, crd *v1alpha1.PodDiskInspector) {
+// pvcAutoScale collects disk usage of the pods returned by listPods and resizes PVCs.
+// It returns the collected usage, if any.
+func (r *PVCScalingReconciler) pvcAutoScale(ctx context.Context, reporter kube.Reporter, crd *v1alpha1.PodDiskInspector, listPods pvc.PodLister) []pvc.PVCDiskUsage {
 	if crd.Spec.PVCScaling == nil {
 		reporter.Error(errors.New("no default PVCScalingSpec found in PodDiskInspectorSpec"), "Failed to process pvc resize")
 		reporter.RecordError("PVCAutoScaleCollectUsage", errors.New("no default PVCScalingSpec found in PodDiskInspectorSpec"))
-		return
+		return nil
 	}
-	usage, err := r.diskClient.CollectDiskUsage(ctx, crd)
+	usage, err := r.diskClient.WithPodLister(listPods).CollectDiskUsage(ctx, crd)
 	if err != nil {
 		reporter.Error(err, "Failed to collect pvc disk usage")
 		// This error can be noisy so we record a generic error. Check logs for error details.
@@ -103,13 +130,75 @@ func (r *PVCScalingReconciler) pvcAutoScale(ctx context.Context, reporter kube.R
 		default:
 			reporter.RecordError("PVCAutoScaleCollectUsage", errors.New("failed to collect pvc disk usage"))
 		}
-		return
+		return nil
 	}
+	for _, u := range usage {
+		if u.TimeToFull > 0 {
+			reporter.Debug("PVC disk usage trend", "pvc", u.Name, "namespace", u.Namespace, "percentUsed", u.PercentUsed, "growthBytesPerSecond", u.GrowthBytesPerSecond, "timeToFull", u.TimeToFull.String())
+		}
+	}
+	r.reportDivergence(reporter, usage)
 	err = r.pvcAutoScaler.ProcessPVCResize(ctx, crd, usage, reporter)
 	if err != nil {
 		reporter.Error(err, "Failed to process pvc resize")
 		reporter.RecordError("PVCAutoScaleResize", err)
 	}
+	return usage
+}
+
+// reportDivergence records an event when the samples of a PVC start or stop diverging. Divergent samples are
+// logged on every collection.
+func (r *PVCScalingReconciler) reportDivergence(reporter kube.Reporter, usage []pvc.PVCDiskUsage) {
+	for _, u := range usage {
+		key := client.ObjectKey{Namespace: u.Namespace, Name: u.Name}
+		if !u.Divergent {
+			if _, ok := r.divergent.LoadAndDelete(key); ok {
+				reporter.RecordInfo("PVCAutoScaleDivergentUsage", fmt.Sprintf("pvc %s: disk usage samples agree again", key))
+			}
+			continue
+		}
+		reporter.Info("PVC disk usage samples diverge", "pvc", u.Name, "namespace", u.Namespace, "samples", u.Samples)
+		if _, loaded := r.divergent.LoadOrStore(key, true); !loaded {
+			reporter.RecordError("PVCAutoScaleDivergentUsage", fmt.Errorf("pvc %s: disk usage samples diverge by more than %d%%, using highest of %d%%", key, pvc.DivergenceThreshold, u.PercentUsed))
+		}
+	}
+}
+
+// collectedPods returns the pods of crd whose disk usage is collected: the annotated and selected pods running the
+// sidecar. Selected pods without the sidecar, e.g. created before crd, are skipped until they are restarted.
+func (r *PVCScalingReconciler) collectedPods(ctx context.Context, crd *v1alpha1.PodDiskInspector) ([]corev1.Pod, error) {
+	reporter := kube.NewEventReporter(log.FromContext(ctx).WithName(v1alpha1.PVCScalingController), r.recorder, crd)
+	pods, err := inspectedPods(ctx, r, reporter, crd)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		collected []corev1.Pod
+		skipped   []string
+	)
+	for _, pod := range pods {
+		if _, ok := findSidecar(pod); !ok && !podAnnotated(pod) {
+			skipped = append(skipped, client.ObjectKeyFromObject(pod).String())
+			continue
+		}
+		collected = append(collected, *pod)
+	}
+	if len(skipped) > 0 {
+		reporter.Debug("Skipping selected pods without the sidecar", "pods", skipped)
+	}
+	return collected, nil
+}
+
+// ensureSecrets creates missing sidecar Secrets in the namespaces of pods, e.g. if creating one failed during
+// injection, and renews expiring serving certificates.
+func (r *PVCScalingReconciler) ensureSecrets(ctx context.Context, reporter kube.Reporter, pods []corev1.Pod) {
+	namespaces := lo.Uniq(lo.Map(pods, func(pod corev1.Pod, _ int) string { return pod.Namespace }))
+	for _, namespace := range namespaces {
+		if err := r.secrets.Ensure(ctx, namespace); err != nil {
+			reporter.Error(err, "Failed to ensure sidecar secret", "namespace", namespace)
+			reporter.RecordError("EnsureSidecarSecret", fmt.Errorf("namespace %s: %w", namespace, err))
+		}
+	}
 }
 
 func (r *PVCScalingReconciler) findObjectForPod(_ context.Context, pod client.Object) []reconcile.Request {
@@ -131,24 +220,21 @@ func (r *PVCScalingReconciler) findObjectForPod(_ context.Context, pod client.Ob
 	}
 }
 
+// indexPodInspector returns the namespace/name of the PodDiskInspector pod is annotated with, if any.
+// Whether the pod may reference it is checked when its pods are listed, see inspectedPods.
+func indexPodInspector(pod client.Object) []string {
+	name := pod.GetAnnotations()[kube.OperatorName]
+	namespace := pod.GetAnnotations()[kube.OperatorNamespace]
+	if name == "" || namespace == "" {
+		return nil
+	}
+	return []string{types.NamespacedName{Name: name, Namespace: namespace}.String()}
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *PVCScalingReconciler) SetupWithManager(ctx context.Context, mgr ctrl.Manager) error {
 	// Index pods.
-	if err := mgr.GetFieldIndexer().IndexField(
-		ctx,
-		&corev1.Pod{},
-		kube.ControllerField,
-		func(rawObj client.Object) []string {
-			// Extract the PodDiskInspector from the pod annotation, if one is provided
-			pod := rawObj.(*corev1.Pod)
-			name := pod.GetAnnotations()[kube.OperatorName]
-			namespace := pod.GetAnnotations()[kube.OperatorNamespace]
-			if name == "" || namespace == "" {
-				return nil
-			}
-			value := types.NamespacedName{Name: name, Namespace: namespace}
-			return []string{value.String()}
-		}); err != nil {
+	if err := mgr.GetFieldIndexer().IndexField(ctx, &corev1.Pod{}, kube.ControllerField, indexPodInspector); err != nil {
 		return fmt.Errorf("pod index field %s: %w", kube.ControllerField, err)
 	}
 
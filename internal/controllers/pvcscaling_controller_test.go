@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/api/v1alpha1"
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/healthcheck"
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/inject"
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/pvc"
+)
+
+func TestPVCScalingReconciler_Reconcile(t *testing.T) {
+	t.Parallel()
+
+	crd := newTestInspector("inspector", "default", time.Now())
+	crd.Spec.PVCScaling = &v1alpha1.PVCScalingSpec{}
+	c := &countingClient{
+		Client:   newFakeClient(crd, newTestPod("db-0", "default", nil, inspectorAnnotations(crd))),
+		requests: make(map[string]int),
+	}
+	secrets := inject.NewSecretManager(c, inject.Options{Auth: true}, nil)
+	r := NewPVCScaling(c, record.NewFakeRecorder(100), healthcheck.NewClient(http.DefaultClient), pvc.CollectorOptions{}, secrets)
+
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(crd)})
+
+	require.NoError(t, err)
+	// The annotated and the selected pods, listed once for both the secrets and the disk usage.
+	require.Equal(t, 2, c.requests["list *v1.PodList"])
+	require.NoError(t, c.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: inject.SecretName}, new(corev1.Secret)))
+}
+
+func TestPVCScalingReconciler_reportDivergence(t *testing.T) {
+	t.Parallel()
+
+	var r PVCScalingReconciler
+	reporter, recorder := newTestReporter(newTestInspector("inspector", "default", time.Now()))
+	usage := func(divergent bool) []pvc.PVCDiskUsage {
+		return []pvc.PVCDiskUsage{
+			{Namespace: "a", Name: "data", PercentUsed: 70, Divergent: divergent},
+			// Same name in another namespace.
+			{Namespace: "b", Name: "data", PercentUsed: 50},
+		}
+	}
+
+	r.reportDivergence(reporter, usage(true))
+
+	require.Len(t, recorder.Events, 1)
+	require.Contains(t, <-recorder.Events, "Warning PVCAutoScaleDivergentUsage pvc a/data: disk usage samples diverge by more than 5%, using highest of 70%")
+
+	r.reportDivergence(reporter, usage(true))
+
+	require.Empty(t, recorder.Events, "recorded once while diverging")
+
+	r.reportDivergence(reporter, usage(false))
+	r.reportDivergence(reporter, usage(false))
+
+	require.Len(t, recorder.Events, 1)
+	require.Contains(t, <-recorder.Events, "Normal PVCAutoScaleDivergentUsage pvc a/data: disk usage samples agree again")
+
+	r.reportDivergence(reporter, usage(true))
+
+	require.Len(t, recorder.Events, 1, "recorded again once diverging again")
+}
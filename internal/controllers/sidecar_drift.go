@@ -0,0 +1,208 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/samber/lo"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/api/v1alpha1"
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/inject"
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/kube"
+)
+
+const (
+	// driftCheckInterval is how often pods are checked for drift if rollout is disabled.
+	driftCheckInterval     = 5 * time.Minute
+	defaultRolloutInterval = 10 * time.Minute
+	// maxDriftedPods bounds the pods listed in the status.
+	maxDriftedPods = 50
+)
+
+// driftedPod is a pod missing the sidecar or running a stale sidecar image.
+type driftedPod struct {
+	pod      *corev1.Pod
+	reason   v1alpha1.DriftReason
+	image    string
+	workload client.Object
+}
+
+// owner returns the workload as "Kind/namespace/name" or an empty string if the pod is not owned by a Deployment or
+// StatefulSet.
+func (d driftedPod) owner() string {
+	switch w := d.workload.(type) {
+	case *appsv1.Deployment:
+		return "Deployment/" + client.ObjectKeyFromObject(w).String()
+	case *appsv1.StatefulSet:
+		return "StatefulSet/" + client.ObjectKeyFromObject(w).String()
+	}
+	return ""
+}
+
+// +kubebuilder:rbac:groups=apps,resources=deployments;statefulsets,verbs=get;list;watch;patch
+// +kubebuilder:rbac:groups=apps,resources=replicasets,verbs=get;list;watch
+
+// driftedPods returns the running pods of crd, annotated or selected, which are missing the sidecar or run a stale
+// sidecar image. Pods the webhook would not inject the sidecar into, e.g. because they have no PVCs, are skipped.
+func (r *PodDiskInspectorReconciler) driftedPods(ctx context.Context, reporter kube.EventReporter, crd *v1alpha1.PodDiskInspector) ([]driftedPod, error) {
+	pods, err := inspectedPods(ctx, r, reporter, crd)
+	if err != nil {
+		return nil, err
+	}
+
+	var drifted []driftedPod
+	for _, pod := range pods {
+		if pod.DeletionTimestamp != nil || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		image := lo.Ternary(strings.TrimSpace(pod.Annotations[kube.OperatorImage]) != "",
+			strings.TrimSpace(pod.Annotations[kube.OperatorImage]), crd.Spec.SidecarImage)
+
+		item := driftedPod{pod: pod, image: image}
+		if sidecar, ok := findSidecar(pod); ok {
+			if sidecar.Image == image {
+				continue
+			}
+			item.reason = v1alpha1.DriftReasonStaleImage
+		} else {
+			if !r.injectable(ctx, reporter, crd, pod) {
+				continue
+			}
+			item.reason = v1alpha1.DriftReasonMissingSidecar
+		}
+
+		item.workload, err = r.workload(ctx, pod)
+		if err != nil {
+			reporter.Error(err, "Failed to get pod owner", "pod", client.ObjectKeyFromObject(pod))
+		}
+		drifted = append(drifted, item)
+	}
+
+	sort.Slice(drifted, func(i, j int) bool {
+		return client.ObjectKeyFromObject(drifted[i].pod).String() < client.ObjectKeyFromObject(drifted[j].pod).String()
+	})
+	return drifted, nil
+}
+
+// findSidecar returns the sidecar container of pod, either a container or a native sidecar.
+func findSidecar(pod *corev1.Pod) (corev1.Container, bool) {
+	isSidecar := func(c corev1.Container) bool { return c.Name == inject.ContainerName }
+	if sidecar, ok := lo.Find(pod.Spec.Containers, isSidecar); ok {
+		return sidecar, true
+	}
+	return lo.Find(pod.Spec.InitContainers, isSidecar)
+}
+
+// podAnnotated returns true if pod references a PodDiskInspector with annotations.
+func podAnnotated(pod *corev1.Pod) bool {
+	return strings.ToLower(strings.TrimSpace(pod.Annotations[kube.OperatorEnabled])) == "true" &&
+		strings.TrimSpace(pod.Annotations[kube.OperatorName]) != "" &&
+		strings.TrimSpace(pod.Annotations[kube.OperatorNamespace]) != ""
+}
+
+// injectable returns true if the webhook would inject the sidecar into pod, i.e. the pod has PVCs to monitor and the
+// sidecar complies with the pod security standard enforced in its namespace. Pods referencing crd from a namespace it
+// does not allow are already skipped by inspectedPods.
+func (r *PodDiskInspectorReconciler) injectable(ctx context.Context, reporter kube.Reporter, crd *v1alpha1.PodDiskInspector, pod *corev1.Pod) bool {
+	_, _, err := sidecarFor(ctx, r, reporter, crd, pod, pod.Namespace, inject.Options{})
+	return err == nil
+}
+
+// workload returns the Deployment or StatefulSet controlling pod, or nil if there is none.
+func (r *PodDiskInspectorReconciler) workload(ctx context.Context, pod *corev1.Pod) (client.Object, error) {
+	ref := metav1.GetControllerOf(pod)
+	if ref == nil {
+		return nil, nil
+	}
+	switch ref.Kind {
+	case "StatefulSet":
+		sts := new(appsv1.StatefulSet)
+		if err := r.Get(ctx, client.ObjectKey{Namespace: pod.Namespace, Name: ref.Name}, sts); err != nil {
+			return nil, client.IgnoreNotFound(err)
+		}
+		return sts, nil
+	case "ReplicaSet":
+		rs := new(appsv1.ReplicaSet)
+		if err := r.Get(ctx, client.ObjectKey{Namespace: pod.Namespace, Name: ref.Name}, rs); err != nil {
+			return nil, client.IgnoreNotFound(err)
+		}
+		ref = metav1.GetControllerOf(rs)
+		if ref == nil || ref.Kind != "Deployment" {
+			return nil, nil
+		}
+		deploy := new(appsv1.Deployment)
+		if err := r.Get(ctx, client.ObjectKey{Namespace: pod.Namespace, Name: ref.Name}, deploy); err != nil {
+			return nil, client.IgnoreNotFound(err)
+		}
+		return deploy, nil
+	}
+	return nil, nil
+}
+
+// rollout restarts up to MaxRestarts workloads owning drifted pods, if Interval passed since the last rollout.
+// It returns true if any workload was restarted.
+// Workloads are restarted by setting the kube.RolloutImage annotation of their pod template, so each workload is
+// restarted at most once per sidecar image, even if its new pods still miss the sidecar.
+// StatefulSets with the OnDelete update strategy do not restart when their template changes, so they are reported
+// instead.
+func (r *PodDiskInspectorReconciler) rollout(ctx context.Context, reporter kube.EventReporter, crd *v1alpha1.PodDiskInspector, drifted []driftedPod) bool {
+	spec := crd.Spec.Rollout
+	interval := lo.Ternary(spec.Interval.Duration > 0, spec.Interval.Duration, defaultRolloutInterval)
+	if status := crd.Status.SidecarDrift; status != nil && status.LastRolloutTime != nil &&
+		time.Since(status.LastRolloutTime.Time) < interval {
+		return false
+	}
+	maxRestarts := int(lo.Ternary(spec.MaxRestarts > 0, spec.MaxRestarts, 1))
+
+	var restarted, skipped []string
+	for _, item := range drifted {
+		if len(restarted) >= maxRestarts {
+			break
+		}
+		owner := item.owner()
+		if owner == "" || lo.Contains(restarted, owner) || lo.Contains(skipped, owner) {
+			continue
+		}
+		template := podTemplate(item.workload)
+		if template.Annotations[kube.RolloutImage] == item.image {
+			continue
+		}
+		if sts, ok := item.workload.(*appsv1.StatefulSet); ok && sts.Spec.UpdateStrategy.Type == appsv1.OnDeleteStatefulSetStrategyType {
+			skipped = append(skipped, owner)
+			reporter.RecordError("SidecarRollout", fmt.Errorf("%s uses the OnDelete update strategy and is not restarted, delete its pods to roll out sidecar image %s",
+				owner, item.image))
+			continue
+		}
+		patch := client.MergeFrom(item.workload.DeepCopyObject().(client.Object))
+		if template.Annotations == nil {
+			template.Annotations = make(map[string]string)
+		}
+		template.Annotations[kube.RolloutImage] = item.image
+		if err := r.Patch(ctx, item.workload, patch); err != nil {
+			reporter.Error(err, "Failed to restart workload", "workload", owner)
+			reporter.RecordError("SidecarRollout", fmt.Errorf("restart %s: %w", owner, err))
+			continue
+		}
+		restarted = append(restarted, owner)
+		reporter.RecordInfo("SidecarRollout", fmt.Sprintf("Restarted %s to roll out sidecar image %s", owner, item.image))
+	}
+	return len(restarted) > 0
+}
+
+// podTemplate returns the pod template of a Deployment or StatefulSet.
+func podTemplate(workload client.Object) *corev1.PodTemplateSpec {
+	switch w := workload.(type) {
+	case *appsv1.Deployment:
+		return &w.Spec.Template
+	case *appsv1.StatefulSet:
+		return &w.Spec.Template
+	}
+	return nil
+}
@@ -0,0 +1,265 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/api/v1alpha1"
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/inject"
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/kube"
+)
+
+const testSidecarImage = "ghcr.io/allthatjazzleo/pvc-autoscaler-operator:v2"
+
+// newDriftPod returns a running pod annotated with crd, mounting a PVC, with a sidecar running image if not empty.
+func newDriftPod(crd *v1alpha1.PodDiskInspector, name, namespace, image string) *corev1.Pod {
+	pod := newTestPod(name, namespace, nil, inspectorAnnotations(crd))
+	pod.Spec.Volumes = []corev1.Volume{{
+		Name:         "data",
+		VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data-" + name}},
+	}}
+	pod.Spec.Containers = []corev1.Container{{Name: "app", Image: "app"}}
+	if image != "" {
+		pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: inject.ContainerName, Image: image})
+	}
+	return pod
+}
+
+func ownedBy(obj client.Object, owner client.Object, kind string) {
+	obj.SetOwnerReferences([]metav1.OwnerReference{{
+		APIVersion: "apps/v1",
+		Kind:       kind,
+		Name:       owner.GetName(),
+		UID:        owner.GetUID(),
+		Controller: lo.ToPtr(true),
+	}})
+}
+
+func TestPodDiskInspectorReconciler_driftedPods(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	crd := newTestInspector("inspector", "default", time.Now())
+	crd.Spec.Selector = nil
+	crd.Spec.SidecarImage = testSidecarImage
+
+	deploy := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "default", UID: "deploy"}}
+	rs := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: "api-123", Namespace: "default", UID: "rs"}}
+	ownedBy(rs, deploy, "Deployment")
+	sts := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default", UID: "sts"}}
+
+	current := newDriftPod(crd, "current", "default", testSidecarImage)
+	stale := newDriftPod(crd, "stale", "default", "ghcr.io/allthatjazzleo/pvc-autoscaler-operator:v1")
+	ownedBy(stale, sts, "StatefulSet")
+	missing := newDriftPod(crd, "missing", "default", "")
+	ownedBy(missing, rs, "ReplicaSet")
+	pinned := newDriftPod(crd, "pinned", "default", "ghcr.io/allthatjazzleo/pvc-autoscaler-operator:v1")
+	pinned.Annotations[kube.OperatorImage] = "ghcr.io/allthatjazzleo/pvc-autoscaler-operator:v1"
+	noPVCs := newDriftPod(crd, "no-pvcs", "default", "")
+	noPVCs.Spec.Volumes = nil
+	excluded := newDriftPod(crd, "excluded", "default", "")
+	excluded.Annotations[kube.ExcludeVolumes] = "data"
+	completed := newDriftPod(crd, "completed", "default", "")
+	completed.Status.Phase = corev1.PodSucceeded
+
+	c := newFakeClient(crd, deploy, rs, sts, current, stale, missing, pinned, noPVCs, excluded, completed,
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+	)
+	r := &PodDiskInspectorReconciler{Client: c}
+	reporter, _ := newTestReporter(crd)
+
+	got, err := r.driftedPods(ctx, reporter, crd)
+
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	require.Equal(t, "missing", got[0].pod.Name)
+	require.Equal(t, v1alpha1.DriftReasonMissingSidecar, got[0].reason)
+	require.Equal(t, testSidecarImage, got[0].image)
+	require.Equal(t, "Deployment/default/api", got[0].owner())
+	require.Equal(t, "stale", got[1].pod.Name)
+	require.Equal(t, v1alpha1.DriftReasonStaleImage, got[1].reason)
+	require.Equal(t, "StatefulSet/default/db", got[1].owner())
+
+	t.Run("pod security", func(t *testing.T) {
+		crd := crd.DeepCopy()
+		crd.Spec.SidecarTemplate = &v1alpha1.SidecarTemplate{
+			SecurityContext: &corev1.SecurityContext{Privileged: lo.ToPtr(true)},
+		}
+		restricted := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:   "restricted",
+			Labels: map[string]string{kube.PodSecurityEnforce: inject.PodSecurityRestricted},
+		}}
+		crd.Spec.AllowedNamespaces = &v1alpha1.AllowedNamespaces{Names: []string{"restricted"}}
+		c := newFakeClient(crd, restricted,
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+			newDriftPod(crd, "rejected", "restricted", ""),
+			newDriftPod(crd, "missing", "default", ""),
+		)
+		r := &PodDiskInspectorReconciler{Client: c}
+
+		got, err := r.driftedPods(ctx, reporter, crd)
+
+		require.NoError(t, err)
+		require.Equal(t, []string{"missing"}, lo.Map(got, func(item driftedPod, _ int) string { return item.pod.Name }))
+	})
+
+	t.Run("unauthorized namespace", func(t *testing.T) {
+		c := newFakeClient(crd,
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "other"}},
+			newDriftPod(crd, "unauthorized", "other", ""),
+		)
+		r := &PodDiskInspectorReconciler{Client: c}
+
+		got, err := r.driftedPods(ctx, reporter, crd)
+
+		require.NoError(t, err)
+		require.Empty(t, got)
+	})
+}
+
+func TestPodDiskInspectorReconciler_rollout(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	setup := func(rollout v1alpha1.RolloutSpec, workloads ...client.Object) (*PodDiskInspectorReconciler, *v1alpha1.PodDiskInspector, []driftedPod) {
+		crd := newTestInspector("inspector", "default", time.Now())
+		crd.Spec.SidecarImage = testSidecarImage
+		crd.Spec.Rollout = &rollout
+		r := &PodDiskInspectorReconciler{Client: newFakeClient(append([]client.Object{crd}, workloads...)...)}
+		var drifted []driftedPod
+		for _, workload := range workloads {
+			// Two pods per workload.
+			for i := 0; i < 2; i++ {
+				drifted = append(drifted, driftedPod{
+					pod:      newTestPod(workload.GetName()+"-pod", workload.GetNamespace(), nil, nil),
+					reason:   v1alpha1.DriftReasonMissingSidecar,
+					image:    testSidecarImage,
+					workload: workload,
+				})
+			}
+		}
+		drifted = append(drifted, driftedPod{pod: newTestPod("unowned", "default", nil, nil), image: testSidecarImage})
+		return r, crd, drifted
+	}
+	rolloutImage := func(t *testing.T, r *PodDiskInspectorReconciler, workload client.Object) string {
+		require.NoError(t, r.Get(ctx, client.ObjectKeyFromObject(workload), workload))
+		return podTemplate(workload).Annotations[kube.RolloutImage]
+	}
+
+	t.Run("restarts up to max restarts", func(t *testing.T) {
+		api := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "default"}}
+		web := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"}}
+		db := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default"}}
+		r, crd, drifted := setup(v1alpha1.RolloutSpec{MaxRestarts: 2}, api, web, db)
+		reporter, recorder := newTestReporter(crd)
+
+		require.True(t, r.rollout(ctx, reporter, crd, drifted))
+
+		require.Equal(t, testSidecarImage, rolloutImage(t, r, api))
+		require.Equal(t, testSidecarImage, rolloutImage(t, r, web))
+		require.Empty(t, rolloutImage(t, r, db))
+		require.Len(t, recorder.Events, 2)
+		require.Contains(t, <-recorder.Events, "Restarted Deployment/default/api to roll out sidecar image "+testSidecarImage)
+	})
+
+	t.Run("restarts once per image", func(t *testing.T) {
+		api := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "default"}}
+		api.Spec.Template.Annotations = map[string]string{kube.RolloutImage: testSidecarImage}
+		db := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default"}}
+		r, crd, drifted := setup(v1alpha1.RolloutSpec{}, api, db)
+		reporter, _ := newTestReporter(crd)
+
+		require.True(t, r.rollout(ctx, reporter, crd, drifted))
+		require.Equal(t, testSidecarImage, rolloutImage(t, r, db))
+
+		// Both are restarted, so new pods still missing the sidecar do not restart them again.
+		require.False(t, r.rollout(ctx, reporter, crd, drifted))
+	})
+
+	t.Run("same name in several namespaces", func(t *testing.T) {
+		a := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "a"}}
+		b := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "b"}}
+		r, crd, drifted := setup(v1alpha1.RolloutSpec{MaxRestarts: 2}, a, b)
+		reporter, recorder := newTestReporter(crd)
+
+		require.True(t, r.rollout(ctx, reporter, crd, drifted))
+
+		require.Equal(t, testSidecarImage, rolloutImage(t, r, a))
+		require.Equal(t, testSidecarImage, rolloutImage(t, r, b))
+		require.Len(t, recorder.Events, 2)
+		require.Contains(t, <-recorder.Events, "Restarted Deployment/a/api")
+		require.Contains(t, <-recorder.Events, "Restarted Deployment/b/api")
+	})
+
+	t.Run("on delete statefulsets", func(t *testing.T) {
+		db := &appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default"}}
+		db.Spec.UpdateStrategy.Type = appsv1.OnDeleteStatefulSetStrategyType
+		api := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "default"}}
+		r, crd, drifted := setup(v1alpha1.RolloutSpec{MaxRestarts: 1}, db, api)
+		reporter, recorder := newTestReporter(crd)
+
+		require.True(t, r.rollout(ctx, reporter, crd, drifted))
+
+		require.Empty(t, rolloutImage(t, r, db))
+		require.Equal(t, testSidecarImage, rolloutImage(t, r, api), "does not count toward max restarts")
+		require.Len(t, recorder.Events, 2, "reported once")
+		require.Contains(t, <-recorder.Events, "StatefulSet/default/db uses the OnDelete update strategy and is not restarted")
+
+		r, crd, drifted = setup(v1alpha1.RolloutSpec{}, db)
+		require.False(t, r.rollout(ctx, reporter, crd, drifted))
+	})
+
+	t.Run("interval", func(t *testing.T) {
+		api := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "default"}}
+		r, crd, drifted := setup(v1alpha1.RolloutSpec{Interval: metav1.Duration{Duration: time.Hour}}, api)
+		crd.Status.SidecarDrift = &v1alpha1.SidecarDriftStatus{LastRolloutTime: lo.ToPtr(metav1.NewTime(time.Now().Add(-time.Minute)))}
+		reporter, _ := newTestReporter(crd)
+
+		require.False(t, r.rollout(ctx, reporter, crd, drifted))
+		require.Empty(t, rolloutImage(t, r, api))
+
+		crd.Status.SidecarDrift.LastRolloutTime = lo.ToPtr(metav1.NewTime(time.Now().Add(-2 * time.Hour)))
+		require.True(t, r.rollout(ctx, reporter, crd, drifted))
+	})
+}
+
+func TestPodDiskInspectorReconciler_Reconcile_drift(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	crd := newTestInspector("inspector", "default", time.Now())
+	crd.Spec.Selector = nil
+	crd.Spec.SidecarImage = testSidecarImage
+	crd.Spec.Rollout = &v1alpha1.RolloutSpec{}
+	deploy := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: "api", Namespace: "default", UID: "deploy"}}
+	rs := &appsv1.ReplicaSet{ObjectMeta: metav1.ObjectMeta{Name: "api-123", Namespace: "default", UID: "rs"}}
+	ownedBy(rs, deploy, "Deployment")
+	missing := newDriftPod(crd, "missing", "default", "")
+	ownedBy(missing, rs, "ReplicaSet")
+	c := newFakeClient(crd, deploy, rs, missing, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}})
+	r := &PodDiskInspectorReconciler{Client: c, Recorder: record.NewFakeRecorder(100)}
+
+	res, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: client.ObjectKeyFromObject(crd)})
+
+	require.NoError(t, err)
+	require.Equal(t, defaultRolloutInterval, res.RequeueAfter)
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(crd), crd))
+	status := crd.Status.SidecarDrift
+	require.NotNil(t, status)
+	require.EqualValues(t, 1, status.Count)
+	require.Equal(t, []v1alpha1.DriftedPod{{Namespace: "default", Name: "missing", Reason: v1alpha1.DriftReasonMissingSidecar, Owner: "Deployment/default/api"}}, status.Pods)
+	require.NotNil(t, status.LastRolloutTime)
+	require.NoError(t, c.Get(ctx, client.ObjectKeyFromObject(deploy), deploy))
+	require.Equal(t, testSidecarImage, deploy.Spec.Template.Annotations[kube.RolloutImage])
+}
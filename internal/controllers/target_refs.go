@@ -0,0 +1,214 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/samber/lo"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/api/v1alpha1"
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/kube"
+)
+
+// targetRefsFinalizer removes the annotations added to targeted workloads when a PodDiskInspector is deleted.
+const targetRefsFinalizer = "autoscaler.allthatjazzleo/target-refs"
+
+// targetAnnotations are the pod template annotations added to targeted workloads.
+var targetAnnotations = []string{kube.OperatorEnabled, kube.OperatorName, kube.OperatorNamespace, kube.TargetedBy}
+
+// reconcileTargets annotates the pod templates of the workloads in TargetRefs and removes the annotations from
+// workloads which are no longer targeted. It returns the status of each target.
+// On error, it returns the status of the targets processed so far and the previous status of the others, so
+// workloads the operator annotated are still tracked.
+func (r *PodDiskInspectorReconciler) reconcileTargets(ctx context.Context, reporter kube.EventReporter, crd *v1alpha1.PodDiskInspector) ([]v1alpha1.TargetStatus, error) {
+	var targets []v1alpha1.TargetStatus
+	for _, ref := range crd.Spec.TargetRefs {
+		status, err := r.target(ctx, reporter, crd, ref)
+		if status.State != "" {
+			targets = append(targets, status)
+		}
+		if err != nil {
+			return withUntracked(targets, crd.Status.Targets), err
+		}
+	}
+
+	for i, prev := range crd.Status.Targets {
+		if containsTarget(targets, prev) {
+			continue
+		}
+		if err := r.untarget(ctx, reporter, crd, prev); err != nil {
+			return withUntracked(targets, crd.Status.Targets[i:]), err
+		}
+	}
+	return targets, nil
+}
+
+// withUntracked returns targets and the statuses in prev of workloads missing from targets.
+func withUntracked(targets, prev []v1alpha1.TargetStatus) []v1alpha1.TargetStatus {
+	for _, status := range prev {
+		if !containsTarget(targets, status) {
+			targets = append(targets, status)
+		}
+	}
+	return targets
+}
+
+// containsTarget returns true if targets contains the status of the workload of target.
+func containsTarget(targets []v1alpha1.TargetStatus, target v1alpha1.TargetStatus) bool {
+	return lo.ContainsBy(targets, func(status v1alpha1.TargetStatus) bool {
+		return status.Kind == target.Kind && status.Namespace == target.Namespace && status.Name == target.Name
+	})
+}
+
+// target annotates the pod template of the workload referenced by ref, unless it is annotated for another
+// PodDiskInspector or opted out, and returns its status.
+// On error, the state of the returned status is empty unless the workload is annotated.
+func (r *PodDiskInspectorReconciler) target(ctx context.Context, reporter kube.EventReporter, crd *v1alpha1.PodDiskInspector, ref v1alpha1.TargetRef) (v1alpha1.TargetStatus, error) {
+	status := v1alpha1.TargetStatus{
+		Kind:      ref.Kind,
+		Namespace: lo.Ternary(ref.Namespace != "", ref.Namespace, crd.Namespace),
+		Name:      ref.Name,
+	}
+	key := client.ObjectKeyFromObject(crd)
+	display := fmt.Sprintf("%s %s/%s", status.Kind, status.Namespace, status.Name)
+
+	if err := authorizeReference(ctx, r, *crd, status.Namespace); err != nil {
+		status.State = v1alpha1.TargetStateUnauthorized
+		reporter.RecordError("UnauthorizedReference", fmt.Errorf("target %s: %w", display, err))
+		return status, nil
+	}
+
+	workload, err := r.getWorkload(ctx, status)
+	if err != nil {
+		return status, err
+	}
+	if workload == nil {
+		status.State = v1alpha1.TargetStateNotFound
+		return status, nil
+	}
+
+	template := podTemplate(workload)
+	annotations := template.Annotations
+	name, namespace := annotations[kube.OperatorName], annotations[kube.OperatorNamespace]
+	switch {
+	case annotations[kube.OperatorEnabled] == "false",
+		annotations[kube.TargetedBy] != "" && annotations[kube.TargetedBy] != key.String(),
+		(name != "" || namespace != "") && (name != crd.Name || namespace != crd.Namespace):
+		status.State = v1alpha1.TargetStateConflict
+		reporter.RecordError("TargetRefConflict", fmt.Errorf("target %s is already annotated for another PodDiskInspector or opted out", display))
+		return status, nil
+	case annotations[kube.OperatorEnabled] == "true" && name == crd.Name && namespace == crd.Namespace:
+		// Already annotated, either by the operator or by its manifest.
+	default:
+		patch := client.MergeFrom(workload.DeepCopyObject().(client.Object))
+		if template.Annotations == nil {
+			template.Annotations = make(map[string]string)
+		}
+		template.Annotations[kube.OperatorEnabled] = "true"
+		template.Annotations[kube.OperatorName] = crd.Name
+		template.Annotations[kube.OperatorNamespace] = crd.Namespace
+		template.Annotations[kube.TargetedBy] = key.String()
+		if err := r.Patch(ctx, workload, patch); err != nil {
+			return status, fmt.Errorf("annotate target %s: %w", display, err)
+		}
+		reporter.RecordInfo("TargetRef", fmt.Sprintf("Annotated %s", display))
+	}
+
+	status.Pods, status.InjectedPods, err = r.injectedPods(ctx, workload)
+	if err != nil {
+		// The workload is annotated, so its status is kept.
+		status.State = v1alpha1.TargetStatePending
+		return status, err
+	}
+	status.State = lo.Ternary(status.Pods > 0 && status.Pods == status.InjectedPods,
+		v1alpha1.TargetStateInjected, v1alpha1.TargetStatePending)
+	return status, nil
+}
+
+// untarget removes the annotations added by the operator from the pod template of a workload which is no longer
+// targeted. Annotations which were not added by the operator are kept.
+func (r *PodDiskInspectorReconciler) untarget(ctx context.Context, reporter kube.EventReporter, crd *v1alpha1.PodDiskInspector, prev v1alpha1.TargetStatus) error {
+	workload, err := r.getWorkload(ctx, prev)
+	if err != nil || workload == nil {
+		return err
+	}
+	template := podTemplate(workload)
+	if template.Annotations[kube.TargetedBy] != client.ObjectKeyFromObject(crd).String() {
+		return nil
+	}
+	patch := client.MergeFrom(workload.DeepCopyObject().(client.Object))
+	for _, annotation := range targetAnnotations {
+		delete(template.Annotations, annotation)
+	}
+	display := fmt.Sprintf("%s %s/%s", prev.Kind, prev.Namespace, prev.Name)
+	if err := r.Patch(ctx, workload, patch); err != nil {
+		return fmt.Errorf("remove annotations from %s: %w", display, err)
+	}
+	reporter.RecordInfo("TargetRef", fmt.Sprintf("Removed annotations from %s", display))
+	return nil
+}
+
+// untargetAll removes the annotations added by the operator from every targeted workload, e.g. before the
+// PodDiskInspector is deleted.
+func (r *PodDiskInspectorReconciler) untargetAll(ctx context.Context, reporter kube.EventReporter, crd *v1alpha1.PodDiskInspector) error {
+	for _, prev := range crd.Status.Targets {
+		if err := r.untarget(ctx, reporter, crd, prev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getWorkload returns the Deployment or StatefulSet of target, or nil if it does not exist.
+func (r *PodDiskInspectorReconciler) getWorkload(ctx context.Context, target v1alpha1.TargetStatus) (client.Object, error) {
+	var workload client.Object
+	switch target.Kind {
+	case "Deployment":
+		workload = new(appsv1.Deployment)
+	case "StatefulSet":
+		workload = new(appsv1.StatefulSet)
+	default:
+		return nil, nil
+	}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: target.Namespace, Name: target.Name}, workload); err != nil {
+		if kube.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("get %s %s/%s: %w", target.Kind, target.Namespace, target.Name, err)
+	}
+	return workload, nil
+}
+
+// injectedPods returns the number of running pods of workload and how many of them have the sidecar.
+func (r *PodDiskInspectorReconciler) injectedPods(ctx context.Context, workload client.Object) (pods, injected int32, err error) {
+	var labelSelector *metav1.LabelSelector
+	switch w := workload.(type) {
+	case *appsv1.Deployment:
+		labelSelector = w.Spec.Selector
+	case *appsv1.StatefulSet:
+		labelSelector = w.Spec.Selector
+	}
+	selector, err := metav1.LabelSelectorAsSelector(labelSelector)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid workload selector: %w", err)
+	}
+	var list corev1.PodList
+	if err := r.List(ctx, &list, client.InNamespace(workload.GetNamespace()), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return 0, 0, fmt.Errorf("list workload pods: %w", err)
+	}
+	for i := range list.Items {
+		pod := &list.Items[i]
+		if pod.DeletionTimestamp != nil || pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		pods++
+		if _, ok := findSidecar(pod); ok {
+			injected++
+		}
+	}
+	return pods, injected, nil
+}
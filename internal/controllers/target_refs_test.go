@@ -0,0 +1,317 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/api/v1alpha1"
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/inject"
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/kube"
+)
+
+// failingPatchClient fails to patch the workloads named in fail.
+type failingPatchClient struct {
+	client.Client
+	fail []string
+}
+
+func (c failingPatchClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	for _, name := range c.fail {
+		if obj.GetName() == name {
+			return errors.New("boom")
+		}
+	}
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func newTargetDeployment(name, namespace string, annotations map[string]string) *appsv1.Deployment {
+	deploy := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+	deploy.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": name}}
+	deploy.Spec.Template.Annotations = annotations
+	return deploy
+}
+
+func templateAnnotations(t *testing.T, c client.Client, workload client.Object) map[string]string {
+	t.Helper()
+	require.NoError(t, c.Get(context.Background(), client.ObjectKeyFromObject(workload), workload))
+	return podTemplate(workload).Annotations
+}
+
+func targetStates(targets []v1alpha1.TargetStatus) map[string]v1alpha1.TargetState {
+	states := make(map[string]v1alpha1.TargetState)
+	for _, target := range targets {
+		states[target.Kind+"/"+target.Namespace+"/"+target.Name] = target.State
+	}
+	return states
+}
+
+func TestPodDiskInspectorReconciler_reconcileTargets(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	t.Run("target", func(t *testing.T) {
+		crd := newTestInspector("inspector", "default", time.Now())
+		crd.Spec.TargetRefs = []v1alpha1.TargetRef{
+			{Kind: "Deployment", Name: "api"},
+			{Kind: "StatefulSet", Name: "missing"},
+			{Kind: "Deployment", Name: "other", Namespace: "other"},
+		}
+		api := newTargetDeployment("api", "default", map[string]string{"other": "value"})
+		injected := newTestPod("api-0", "default", map[string]string{"app": "api"}, nil)
+		injected.Spec.Containers = []corev1.Container{{Name: inject.ContainerName}}
+		pending := newTestPod("api-1", "default", map[string]string{"app": "api"}, nil)
+		completed := newTestPod("api-2", "default", map[string]string{"app": "api"}, nil)
+		completed.Status.Phase = corev1.PodSucceeded
+		c := newFakeClient(crd, api, injected, pending, completed,
+			newTargetDeployment("other", "other", nil),
+			&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "other"}},
+		)
+		r := &PodDiskInspectorReconciler{Client: c}
+		reporter, recorder := newTestReporter(crd)
+
+		got, err := r.reconcileTargets(ctx, reporter, crd)
+
+		require.NoError(t, err)
+		require.Equal(t, []v1alpha1.TargetStatus{
+			{Kind: "Deployment", Namespace: "default", Name: "api", State: v1alpha1.TargetStatePending, Pods: 2, InjectedPods: 1},
+			{Kind: "StatefulSet", Namespace: "default", Name: "missing", State: v1alpha1.TargetStateNotFound},
+			{Kind: "Deployment", Namespace: "other", Name: "other", State: v1alpha1.TargetStateUnauthorized},
+		}, got)
+		require.Equal(t, map[string]string{
+			"other":                "value",
+			kube.OperatorEnabled:   "true",
+			kube.OperatorName:      "inspector",
+			kube.OperatorNamespace: "default",
+			kube.TargetedBy:        "default/inspector",
+		}, templateAnnotations(t, c, api))
+		require.Empty(t, templateAnnotations(t, c, newTargetDeployment("other", "other", nil)))
+		require.Len(t, recorder.Events, 2)
+		require.Contains(t, <-recorder.Events, "Annotated Deployment default/api")
+		require.Contains(t, <-recorder.Events, "UnauthorizedReference target Deployment other/other")
+
+		// Every pod has the sidecar.
+		require.NoError(t, c.Delete(ctx, pending))
+		got, err = r.reconcileTargets(ctx, reporter, crd)
+
+		require.NoError(t, err)
+		require.Equal(t, v1alpha1.TargetStateInjected, got[0].State)
+		require.Len(t, recorder.Events, 1, "already annotated")
+		require.Contains(t, <-recorder.Events, "UnauthorizedReference")
+	})
+
+	t.Run("conflict", func(t *testing.T) {
+		crd := newTestInspector("inspector", "default", time.Now())
+		conflicts := map[string]map[string]string{
+			"annotated": {kube.OperatorEnabled: "true", kube.OperatorName: "other", kube.OperatorNamespace: "default"},
+			"targeted":  {kube.TargetedBy: "default/other"},
+			"opted-out": {kube.OperatorEnabled: "false"},
+		}
+		objs := []client.Object{crd}
+		for name, annotations := range conflicts {
+			crd.Spec.TargetRefs = append(crd.Spec.TargetRefs, v1alpha1.TargetRef{Kind: "Deployment", Name: name})
+			objs = append(objs, newTargetDeployment(name, "default", annotations))
+		}
+		c := newFakeClient(objs...)
+		r := &PodDiskInspectorReconciler{Client: c}
+		reporter, recorder := newTestReporter(crd)
+
+		got, err := r.reconcileTargets(ctx, reporter, crd)
+
+		require.NoError(t, err)
+		require.Equal(t, map[string]v1alpha1.TargetState{
+			"Deployment/default/annotated": v1alpha1.TargetStateConflict,
+			"Deployment/default/targeted":  v1alpha1.TargetStateConflict,
+			"Deployment/default/opted-out": v1alpha1.TargetStateConflict,
+		}, targetStates(got))
+		for name, annotations := range conflicts {
+			require.Equal(t, annotations, templateAnnotations(t, c, newTargetDeployment(name, "default", nil)), name)
+		}
+		require.Len(t, recorder.Events, 3)
+		require.Contains(t, <-recorder.Events, "TargetRefConflict")
+	})
+
+	t.Run("untarget", func(t *testing.T) {
+		crd := newTestInspector("inspector", "default", time.Now())
+		targeted := newTargetDeployment("targeted", "default", map[string]string{
+			"other":                "value",
+			kube.OperatorEnabled:   "true",
+			kube.OperatorName:      "inspector",
+			kube.OperatorNamespace: "default",
+			kube.TargetedBy:        "default/inspector",
+		})
+		// Annotated by its manifest, so the annotations are kept.
+		manual := newTargetDeployment("manual", "default", inspectorAnnotations(crd))
+		crd.Status.Targets = []v1alpha1.TargetStatus{
+			{Kind: "Deployment", Namespace: "default", Name: "targeted", State: v1alpha1.TargetStateInjected},
+			{Kind: "Deployment", Namespace: "default", Name: "manual", State: v1alpha1.TargetStateInjected},
+			{Kind: "Deployment", Namespace: "default", Name: "deleted", State: v1alpha1.TargetStateInjected},
+		}
+		c := newFakeClient(crd, targeted, manual)
+		r := &PodDiskInspectorReconciler{Client: c}
+		reporter, recorder := newTestReporter(crd)
+
+		got, err := r.reconcileTargets(ctx, reporter, crd)
+
+		require.NoError(t, err)
+		require.Empty(t, got)
+		require.Equal(t, map[string]string{"other": "value"}, templateAnnotations(t, c, targeted))
+		require.Equal(t, inspectorAnnotations(crd), templateAnnotations(t, c, manual))
+		require.Len(t, recorder.Events, 1)
+		require.Contains(t, <-recorder.Events, "Removed annotations from Deployment default/targeted")
+	})
+
+	t.Run("manually annotated", func(t *testing.T) {
+		crd := newTestInspector("inspector", "default", time.Now())
+		crd.Spec.TargetRefs = []v1alpha1.TargetRef{{Kind: "Deployment", Name: "manual"}}
+		manual := newTargetDeployment("manual", "default", inspectorAnnotations(crd))
+		c := newFakeClient(crd, manual)
+		r := &PodDiskInspectorReconciler{Client: c}
+		reporter, recorder := newTestReporter(crd)
+
+		got, err := r.reconcileTargets(ctx, reporter, crd)
+
+		require.NoError(t, err)
+		require.Equal(t, map[string]v1alpha1.TargetState{"Deployment/default/manual": v1alpha1.TargetStatePending}, targetStates(got))
+		require.Equal(t, inspectorAnnotations(crd), templateAnnotations(t, c, manual), "not marked as targeted")
+		require.Empty(t, recorder.Events)
+
+		crd.Spec.TargetRefs = nil
+		crd.Status.Targets = got
+		_, err = r.reconcileTargets(ctx, reporter, crd)
+
+		require.NoError(t, err)
+		require.Equal(t, inspectorAnnotations(crd), templateAnnotations(t, c, manual))
+	})
+
+	t.Run("error", func(t *testing.T) {
+		crd := newTestInspector("inspector", "default", time.Now())
+		crd.Spec.TargetRefs = []v1alpha1.TargetRef{
+			{Kind: "Deployment", Name: "api"},
+			{Kind: "Deployment", Name: "failing"},
+			{Kind: "Deployment", Name: "web"},
+		}
+		crd.Status.Targets = []v1alpha1.TargetStatus{
+			{Kind: "Deployment", Namespace: "default", Name: "web", State: v1alpha1.TargetStateInjected},
+			{Kind: "Deployment", Namespace: "default", Name: "untargeted", State: v1alpha1.TargetStateInjected},
+		}
+		c := failingPatchClient{
+			Client: newFakeClient(crd,
+				newTargetDeployment("api", "default", nil),
+				newTargetDeployment("failing", "default", nil),
+				newTargetDeployment("web", "default", map[string]string{kube.TargetedBy: "default/inspector"}),
+			),
+			fail: []string{"failing"},
+		}
+		r := &PodDiskInspectorReconciler{Client: c}
+		reporter, _ := newTestReporter(crd)
+
+		got, err := r.reconcileTargets(ctx, reporter, crd)
+
+		require.ErrorContains(t, err, "annotate target Deployment default/failing: boom")
+		require.Equal(t, map[string]v1alpha1.TargetState{
+			"Deployment/default/api":        v1alpha1.TargetStatePending,
+			"Deployment/default/web":        v1alpha1.TargetStateInjected,
+			"Deployment/default/untargeted": v1alpha1.TargetStateInjected,
+		}, targetStates(got))
+
+		crd.Spec.TargetRefs = nil
+		crd.Status.Targets = got
+		c.fail = []string{"web"}
+		r.Client = c
+
+		got, err = r.reconcileTargets(ctx, reporter, crd)
+
+		require.ErrorContains(t, err, "remove annotations from Deployment default/web: boom")
+		require.Equal(t, map[string]v1alpha1.TargetState{
+			"Deployment/default/web":        v1alpha1.TargetStateInjected,
+			"Deployment/default/untargeted": v1alpha1.TargetStateInjected,
+		}, targetStates(got))
+		require.Empty(t, templateAnnotations(t, c, newTargetDeployment("api", "default", nil)))
+	})
+}
+
+func TestPodDiskInspectorReconciler_Reconcile_targets(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	t.Run("finalizer", func(t *testing.T) {
+		crd := newTestInspector("inspector", "default", time.Now())
+		crd.Spec.TargetRefs = []v1alpha1.TargetRef{{Kind: "Deployment", Name: "api"}}
+		api := newTargetDeployment("api", "default", nil)
+		c := newFakeClient(crd, api)
+		r := &PodDiskInspectorReconciler{Client: c, Recorder: record.NewFakeRecorder(100)}
+		req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(crd)}
+
+		_, err := r.Reconcile(ctx, req)
+
+		require.NoError(t, err)
+		require.NoError(t, c.Get(ctx, req.NamespacedName, crd))
+		require.Equal(t, []string{targetRefsFinalizer}, crd.Finalizers)
+		require.Equal(t, map[string]v1alpha1.TargetState{"Deployment/default/api": v1alpha1.TargetStatePending}, targetStates(crd.Status.Targets))
+		require.Equal(t, "default/inspector", templateAnnotations(t, c, api)[kube.TargetedBy])
+
+		require.NoError(t, c.Delete(ctx, crd))
+		_, err = r.Reconcile(ctx, req)
+
+		require.NoError(t, err)
+		require.Empty(t, templateAnnotations(t, c, api))
+		require.True(t, kube.IsNotFound(c.Get(ctx, req.NamespacedName, crd)), "finalizer removed")
+	})
+
+	t.Run("finalizer removed without targets", func(t *testing.T) {
+		crd := newTestInspector("inspector", "default", time.Now())
+		crd.Finalizers = []string{targetRefsFinalizer}
+		c := newFakeClient(crd)
+		r := &PodDiskInspectorReconciler{Client: c, Recorder: record.NewFakeRecorder(100)}
+		req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(crd)}
+
+		_, err := r.Reconcile(ctx, req)
+
+		require.NoError(t, err)
+		require.NoError(t, c.Get(ctx, req.NamespacedName, crd))
+		require.Empty(t, crd.Finalizers)
+	})
+
+	t.Run("untarget error keeps finalizer", func(t *testing.T) {
+		crd := newTestInspector("inspector", "default", time.Now())
+		crd.Finalizers = []string{targetRefsFinalizer}
+		crd.Status.Targets = []v1alpha1.TargetStatus{{Kind: "Deployment", Namespace: "default", Name: "api", State: v1alpha1.TargetStateInjected}}
+		fake := newFakeClient(crd, newTargetDeployment("api", "default", map[string]string{kube.TargetedBy: "default/inspector"}))
+		require.NoError(t, fake.Delete(ctx, crd))
+		r := &PodDiskInspectorReconciler{Client: failingPatchClient{Client: fake, fail: []string{"api"}}, Recorder: record.NewFakeRecorder(100)}
+		req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(crd)}
+
+		_, err := r.Reconcile(ctx, req)
+
+		require.ErrorContains(t, err, "boom")
+		require.NoError(t, fake.Get(ctx, req.NamespacedName, crd))
+		require.Equal(t, []string{targetRefsFinalizer}, crd.Finalizers)
+	})
+
+	t.Run("status recorded on error", func(t *testing.T) {
+		crd := newTestInspector("inspector", "default", time.Now())
+		crd.Finalizers = []string{targetRefsFinalizer}
+		crd.Spec.TargetRefs = []v1alpha1.TargetRef{{Kind: "Deployment", Name: "api"}, {Kind: "Deployment", Name: "failing"}}
+		fake := newFakeClient(crd, newTargetDeployment("api", "default", nil), newTargetDeployment("failing", "default", nil))
+		r := &PodDiskInspectorReconciler{Client: failingPatchClient{Client: fake, fail: []string{"failing"}}, Recorder: record.NewFakeRecorder(100)}
+		req := ctrl.Request{NamespacedName: client.ObjectKeyFromObject(crd)}
+
+		_, err := r.Reconcile(ctx, req)
+
+		require.ErrorContains(t, err, "boom")
+		require.NoError(t, fake.Get(ctx, req.NamespacedName, crd))
+		require.Equal(t, map[string]v1alpha1.TargetState{"Deployment/default/api": v1alpha1.TargetStatePending}, targetStates(crd.Status.Targets))
+	})
+}
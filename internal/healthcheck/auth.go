@@ -0,0 +1,110 @@
+package healthcheck
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const authorizationHeader = "authorization"
+
+// TokenSource returns the bearer token used to authenticate with sidecars in a namespace.
+// An empty token means the sidecars do not require authentication.
+type TokenSource interface {
+	Token(ctx context.Context, namespace string) (string, error)
+}
+
+type namespaceKey struct{}
+
+// WithNamespace returns a context carrying the namespace of the pod being queried,
+// which selects the credentials attached by the Client.
+func WithNamespace(ctx context.Context, namespace string) context.Context {
+	return context.WithValue(ctx, namespaceKey{}, namespace)
+}
+
+func namespaceFrom(ctx context.Context) string {
+	ns, _ := ctx.Value(namespaceKey{}).(string)
+	return ns
+}
+
+// tokenAuth verifies bearer tokens against the token in a file.
+// The file is read on every request so a rotated secret is picked up without a restart.
+type tokenAuth struct {
+	tokenFile string
+}
+
+func (a tokenAuth) verify(authorization string) error {
+	want, err := os.ReadFile(a.tokenFile)
+	if err != nil {
+		return fmt.Errorf("read token: %w", err)
+	}
+	want = bytes.TrimSpace(want)
+	if len(want) == 0 {
+		// Fail closed, e.g. the secret is not mounted yet.
+		return fmt.Errorf("no token configured in %s", a.tokenFile)
+	}
+
+	got, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(got), want) != 1 {
+		return fmt.Errorf("invalid bearer token")
+	}
+	return nil
+}
+
+// WriteToken writes a new random token to tokenFile, readable by the other containers of the pod, which may run
+// as other users.
+func WriteToken(tokenFile string) error {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return fmt.Errorf("generate token: %w", err)
+	}
+	if err := os.WriteFile(tokenFile, []byte(hex.EncodeToString(token)), 0o644); err != nil {
+		return fmt.Errorf("write token: %w", err)
+	}
+	// The file mode is subject to the umask.
+	if err := os.Chmod(tokenFile, 0o644); err != nil {
+		return fmt.Errorf("write token: %w", err)
+	}
+	return nil
+}
+
+// RequireToken returns a handler which responds with 401 Unauthorized unless the request carries
+// the bearer token found in tokenFile.
+func RequireToken(tokenFile string, next http.Handler) http.Handler {
+	auth := tokenAuth{tokenFile: tokenFile}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := auth.verify(r.Header.Get(authorizationHeader)); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireTokenStream returns a gRPC interceptor which rejects streams unless they carry
+// the bearer token found in tokenFile.
+func RequireTokenStream(tokenFile string) grpc.StreamServerInterceptor {
+	auth := tokenAuth{tokenFile: tokenFile}
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		md, _ := metadata.FromIncomingContext(ss.Context())
+		var authorization string
+		if vals := md.Get(authorizationHeader); len(vals) > 0 {
+			authorization = vals[0]
+		}
+		if err := auth.verify(authorization); err != nil {
+			return status.Error(codes.Unauthenticated, err.Error())
+		}
+		return handler(srv, ss)
+	}
+}
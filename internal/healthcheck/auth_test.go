@@ -0,0 +1,147 @@
+package healthcheck
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func writeToken(t *testing.T, token string) string {
+	t.Helper()
+	f := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(f, []byte(token), 0o600))
+	return f
+}
+
+func TestRequireToken(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	for _, tt := range []struct {
+		Name          string
+		FileToken     string
+		Authorization string
+		WantStatus    int
+	}{
+		{"valid token", "secret\n", "Bearer secret", http.StatusOK},
+		{"invalid token", "secret", "Bearer wrong", http.StatusUnauthorized},
+		{"missing header", "secret", "", http.StatusUnauthorized},
+		{"not bearer", "secret", "secret", http.StatusUnauthorized},
+		{"empty token file", "", "Bearer ", http.StatusUnauthorized},
+	} {
+		t.Run(tt.Name, func(t *testing.T) {
+			h := RequireToken(writeToken(t, tt.FileToken), ok)
+
+			req := httptest.NewRequest("GET", "/disk", nil)
+			if tt.Authorization != "" {
+				req.Header.Set("Authorization", tt.Authorization)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			require.Equal(t, tt.WantStatus, w.Code)
+		})
+	}
+
+	t.Run("missing token file", func(t *testing.T) {
+		h := RequireToken(filepath.Join(t.TempDir(), "missing"), ok)
+
+		req := httptest.NewRequest("GET", "/disk", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+
+		require.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+}
+
+func TestWriteToken(t *testing.T) {
+	f := filepath.Join(t.TempDir(), LocalTokenFile)
+	require.NoError(t, WriteToken(f))
+
+	token, err := os.ReadFile(f)
+	require.NoError(t, err)
+	require.Len(t, token, 64)
+	info, err := os.Stat(f)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o644), info.Mode().Perm(), "readable by containers running as other users")
+
+	requests := NewResizeRequests(PVCVolumes("data", "/"), time.Hour)
+	h := RequireToken(f, requests.Handler())
+	post := func(authorization string) int {
+		req := httptest.NewRequest("POST", "/request-resize", strings.NewReader(`{"pvc": "data", "min_free": "1Gi"}`))
+		req.RemoteAddr = "127.0.0.1:40000"
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// Another pod on the host network shares the loopback interface, but cannot read the token.
+	require.Equal(t, http.StatusUnauthorized, post(""))
+	require.Equal(t, http.StatusAccepted, post("Bearer "+string(token)))
+
+	// A restarted sidecar writes a new token.
+	require.NoError(t, WriteToken(f))
+	require.Equal(t, http.StatusUnauthorized, post("Bearer "+string(token)))
+
+	require.Error(t, WriteToken(filepath.Join(t.TempDir(), "missing", LocalTokenFile)))
+}
+
+func TestRequireTokenStream(t *testing.T) {
+	var (
+		ctx        = context.Background()
+		httpClient = &http.Client{}
+	)
+
+	srv := &StreamServer{
+		volumes:  PVCVolumes("test", "/"),
+		interval: time.Millisecond,
+		stat: func(volumes []Volume) ([]DiskUsageResponse, error) {
+			return []DiskUsageResponse{{PvcName: "test", AllBytes: 100, FreeBytes: 10}}, nil
+		},
+	}
+	lis := bufconn.Listen(1 << 20)
+	grpcSrv := grpc.NewServer(grpc.StreamInterceptor(RequireTokenStream(writeToken(t, "secret"))))
+	srv.Register(grpcSrv)
+	go func() { _ = grpcSrv.Serve(lis) }()
+	t.Cleanup(grpcSrv.Stop)
+
+	dialer := grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return lis.DialContext(ctx)
+	})
+
+	t.Run("valid token", func(t *testing.T) {
+		client := NewStreamingClient(httpClient, dialer).WithTokenSource(stubTokens{"ns": "secret"})
+		defer client.Close()
+
+		got, err := client.DiskUsage(WithNamespace(ctx, "ns"), "http://10.1.1.1")
+
+		require.NoError(t, err)
+		require.Len(t, got, 1)
+	})
+
+	t.Run("invalid token", func(t *testing.T) {
+		client := NewStreamingClient(httpClient, dialer).WithTokenSource(stubTokens{"ns": "wrong"})
+		defer client.Close()
+
+		_, err := client.DiskUsage(WithNamespace(ctx, "ns"), "http://10.1.1.1")
+
+		require.Error(t, err)
+		require.Contains(t, err.Error(), codes.Unauthenticated.String())
+	})
+}
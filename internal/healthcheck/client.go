@@ -5,53 +5,279 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"mime"
 	"net"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/kube"
 	"github.com/samber/lo"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// Transport is the protocol used to query the healthcheck sidecar.
+type Transport string
+
+const (
+	// TransportHTTP queries the sidecar's JSON /disk endpoint every collection cycle.
+	TransportHTTP Transport = "http"
+	// TransportGRPC keeps a long-lived gRPC stream to every sidecar and receives updates on change.
+	TransportGRPC Transport = "grpc"
 )
 
 // Client can be used to query healthcheck information.
 type Client struct {
-	httpDo func(req *http.Request) (*http.Response, error)
+	httpClient *http.Client
+	httpDo     func(req *http.Request) (*http.Response, error)
+	streams    *streamPool
+	tokens     TokenSource
+	caFile     string
 }
 
 func NewClient(client *http.Client) *Client {
 	return &Client{
-		httpDo: client.Do,
+		httpClient: client,
+		httpDo:     client.Do,
+	}
+}
+
+// NewStreamingClient returns a Client which receives disk usage over long-lived gRPC streams.
+// Sidecars which do not serve gRPC are queried over HTTP with client instead.
+func NewStreamingClient(client *http.Client, opts ...grpc.DialOption) *Client {
+	opts = append([]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials()), clientKeepalive}, opts...)
+	return &Client{
+		httpClient: client,
+		httpDo:     client.Do,
+		streams:    newStreamPool(opts...),
+	}
+}
+
+// WithTokenSource returns a copy of the Client which authenticates with sidecars using tokens.
+// The namespace passed to tokens is taken from the request context, see WithNamespace.
+func (c Client) WithTokenSource(tokens TokenSource) *Client {
+	c.tokens = tokens
+	return &c
+}
+
+// WithTLS returns a copy of the Client which queries sidecars over TLS, verifying their serving certificates
+// against the CA bundle in caFile. The namespace of the pod is taken from the request context, see WithNamespace.
+func (c Client) WithTLS(caFile string) *Client {
+	httpClient := *c.httpClient
+	httpClient.Transport = tlsTransport(caFile)
+	c.httpClient = &httpClient
+	c.httpDo = httpClient.Do
+	c.caFile = caFile
+	return &c
+}
+
+// Close releases any long-lived streams.
+func (c Client) Close() {
+	if c.streams != nil {
+		c.streams.Close()
 	}
 }
 
 // DiskUsage returns disk usage statistics or an error if unable to obtain.
+// If the sidecar failed to check some volumes, the statistics of the others are returned with a *VolumeError.
 // Do not include the port in the host.
 func (c Client) DiskUsage(ctx context.Context, host string) ([]DiskUsageResponse, error) {
-	var diskResps = make([]DiskUsageResponse, 0)
+	report, err := c.DiskReport(ctx, host)
+	if err != nil {
+		return make([]DiskUsageResponse, 0), err
+	}
+	return validDiskUsage(report.Disks)
+}
+
+// DiskReport returns the disk statistics of every volume including those the sidecar failed to check.
+// Reports of sidecars which predate DiskReport have SchemaVersion 1 and AvailableBytes set to FreeBytes.
+// Do not include the port in the host.
+func (c Client) DiskReport(ctx context.Context, host string) (DiskReport, error) {
+	var report DiskReport
+	u, err := url.Parse(host)
+	if err != nil {
+		return report, fmt.Errorf("url parse: %w", err)
+	}
+
+	token, err := c.token(ctx)
+	if err != nil {
+		return report, err
+	}
+
+	if c.streams != nil && !c.streams.UseFallback(u.Hostname()) {
+		var creds credentials.TransportCredentials
+		if c.caFile != "" {
+			cfg, err := clientTLSConfig(c.caFile, namespaceFrom(ctx))
+			if err != nil {
+				return report, err
+			}
+			creds = credentials.NewTLS(cfg)
+		}
+		report, err = c.streams.Report(ctx, u.Hostname(), token, creds)
+		switch {
+		case errors.Is(err, errStaleStream):
+			// Falls back to HTTP.
+		case err != nil:
+			return report, fmt.Errorf("grpc stream: %w", err)
+		default:
+			return normalizeReport(report), nil
+		}
+	}
+
+	resp, err := c.get(ctx, u, "/disk", token, DiskReportMediaType+", application/json")
+	if err != nil {
+		return report, err
+	}
+	defer resp.Body.Close()
+	// The sidecar responds 500 if it failed to check any volume, with the error in the volume's DiskUsageResponse.
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusInternalServerError {
+		return report, statusError(resp)
+	}
+	if mt, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mt == DiskReportMediaType {
+		err = json.NewDecoder(resp.Body).Decode(&report)
+	} else {
+		err = json.NewDecoder(resp.Body).Decode(&report.Disks)
+	}
+	if err != nil {
+		return report, fmt.Errorf("malformed json: %w", err)
+	}
+	return normalizeReport(report), nil
+}
+
+// TopDirs returns the n largest directories at most depth levels below the root of pvc.
+// The sidecar rate limits these requests. Do not include the port in the host.
+func (c Client) TopDirs(ctx context.Context, host string, pvc string, depth, n int) (TopResponse, error) {
+	var top TopResponse
 	u, err := url.Parse(host)
 	if err != nil {
-		return diskResps, fmt.Errorf("url parse: %w", err)
+		return top, fmt.Errorf("url parse: %w", err)
+	}
+	u.RawQuery = url.Values{
+		"pvc":   {pvc},
+		"depth": {strconv.Itoa(depth)},
+		"n":     {strconv.Itoa(n)},
+	}.Encode()
+
+	token, err := c.token(ctx)
+	if err != nil {
+		return top, err
+	}
+	if err = c.getJSON(ctx, u, "/disk/top", token, &top); err != nil {
+		return top, err
+	}
+	if top.Error != "" {
+		return top, errors.New(top.Error)
+	}
+	return top, nil
+}
+
+// getJSON decodes the JSON response of the sidecar's HTTP endpoint at path into v.
+func (c Client) getJSON(ctx context.Context, u *url.URL, path string, token string, v interface{}) error {
+	resp, err := c.get(ctx, u, path, token, "application/json")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("malformed json: %w", err)
+	}
+	return nil
+}
+
+// get requests the sidecar's HTTP endpoint at path. The caller must close the response body.
+func (c Client) get(ctx context.Context, u *url.URL, path string, token string, accept string) (*http.Response, error) {
+	if c.caFile != "" {
+		u.Scheme = "https"
 	}
 	u.Host = net.JoinHostPort(u.Host, strconv.Itoa(Port))
-	u.Path = "/disk"
+	u.Path = path
 
 	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
 	if err != nil {
-		return diskResps, fmt.Errorf("new request: %w", err)
+		return nil, fmt.Errorf("new request: %w", err)
+	}
+	req.Header.Set("Accept", accept)
+	if token != "" {
+		req.Header.Set(authorizationHeader, "Bearer "+token)
 	}
 	resp, err := c.httpDo(req)
 	if err != nil {
-		return diskResps, fmt.Errorf("http do: %w", err)
+		// Network errors are usually temporary, e.g. the sidecar is restarting.
+		return nil, kube.TransientError(fmt.Errorf("http do: %w", err))
 	}
-	defer resp.Body.Close()
-	if err = json.NewDecoder(resp.Body).Decode(&diskResps); err != nil {
-		return diskResps, fmt.Errorf("malformed json: %w", err)
+	if resp.StatusCode == http.StatusUnauthorized {
+		resp.Body.Close()
+		return nil, errors.New("unauthorized: sidecar rejected token")
+	}
+	return resp, nil
+}
+
+// statusError returns an error for an unexpected response status, transient if the sidecar may recover.
+func statusError(resp *http.Response) error {
+	err := fmt.Errorf("unexpected status: %s", resp.Status)
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return kube.TransientError(err)
 	}
-	diskResps = lo.Filter(diskResps, func(item DiskUsageResponse, index int) bool {
+	return err
+}
+
+// normalizeReport fills in the fields of reports from sidecars which predate DiskReport.
+func normalizeReport(report DiskReport) DiskReport {
+	if report.SchemaVersion >= 2 {
+		return report
+	}
+	report.SchemaVersion = 1
+	for i := range report.Disks {
+		// Old sidecars do not report reserved blocks.
+		report.Disks[i].AvailableBytes = report.Disks[i].FreeBytes
+	}
+	return report
+}
+
+func (c Client) token(ctx context.Context) (string, error) {
+	if c.tokens == nil {
+		return "", nil
+	}
+	token, err := c.tokens.Token(ctx, namespaceFrom(ctx))
+	if err != nil {
+		return "", fmt.Errorf("get token: %w", err)
+	}
+	return token, nil
+}
+
+// VolumeError lists the volumes the sidecar failed to check.
+type VolumeError struct {
+	Volumes []DiskUsageResponse
+}
+
+func (e *VolumeError) Error() string {
+	msgs := lo.Map(e.Volumes, func(item DiskUsageResponse, _ int) string {
+		return fmt.Sprintf("pvc %s: %s", item.PvcName, item.Error)
+	})
+	return strings.Join(msgs, "; ")
+}
+
+// validDiskUsage drops responses without usable statistics. Responses with errors are returned in a *VolumeError.
+func validDiskUsage(diskResps []DiskUsageResponse) ([]DiskUsageResponse, error) {
+	failed := lo.Filter(diskResps, func(item DiskUsageResponse, _ int) bool {
+		return item.Error != ""
+	})
+	valid := lo.Filter(diskResps, func(item DiskUsageResponse, _ int) bool {
 		return item.Error == "" && item.AllBytes != 0
 	})
-	if len(diskResps) == 0 {
-		return diskResps, errors.New("no disk usage data")
+	var err error
+	if len(failed) > 0 {
+		err = &VolumeError{Volumes: failed}
+	}
+	if len(valid) == 0 {
+		if err != nil {
+			return valid, fmt.Errorf("no disk usage data: %w", err)
+		}
+		return valid, errors.New("no disk usage data")
 	}
-	return diskResps, nil
+	return valid, err
 }
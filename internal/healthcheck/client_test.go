@@ -10,6 +10,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/kube"
 	"github.com/stretchr/testify/require"
 )
 
@@ -27,9 +28,10 @@ func TestClient_DiskUsage(t *testing.T) {
 
 		want := []DiskUsageResponse{
 			{
-				Dir:       "/test",
-				AllBytes:  100,
-				FreeBytes: 10,
+				Dir:            "/test",
+				AllBytes:       100,
+				FreeBytes:      10,
+				AvailableBytes: 10,
 			},
 		}
 
@@ -41,7 +43,7 @@ func TestClient_DiskUsage(t *testing.T) {
 			if err != nil {
 				panic(err)
 			}
-			return &http.Response{Body: io.NopCloser(bytes.NewReader(b))}, nil
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(b))}, nil
 		}
 
 		got, err := client.DiskUsage(ctx, host)
@@ -50,6 +52,40 @@ func TestClient_DiskUsage(t *testing.T) {
 		require.Equal(t, want, got)
 	})
 
+	t.Run("with token", func(t *testing.T) {
+		client := NewClient(httpClient).WithTokenSource(stubTokens{"ns": "secret"})
+
+		client.httpDo = func(req *http.Request) (*http.Response, error) {
+			require.Equal(t, "Bearer secret", req.Header.Get("Authorization"))
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader(`[{"all_bytes":100,"free_bytes":10}]`)),
+			}, nil
+		}
+
+		got, err := client.DiskUsage(WithNamespace(ctx, "ns"), host)
+
+		require.NoError(t, err)
+		require.Len(t, got, 1)
+	})
+
+	t.Run("unauthorized", func(t *testing.T) {
+		client := NewClient(httpClient).WithTokenSource(stubTokens{})
+
+		client.httpDo = func(req *http.Request) (*http.Response, error) {
+			require.Empty(t, req.Header.Get("Authorization"))
+			return &http.Response{
+				StatusCode: http.StatusUnauthorized,
+				Body:       io.NopCloser(strings.NewReader("invalid bearer token")),
+			}, nil
+		}
+
+		_, err := client.DiskUsage(WithNamespace(ctx, "ns"), host)
+
+		require.Error(t, err)
+		require.EqualError(t, err, "unauthorized: sidecar rejected token")
+	})
+
 	t.Run("request error", func(t *testing.T) {
 		client := NewClient(httpClient)
 		client.httpDo = func(req *http.Request) (*http.Response, error) {
@@ -65,7 +101,7 @@ func TestClient_DiskUsage(t *testing.T) {
 		client := NewClient(httpClient)
 
 		stub := []DiskUsageResponse{
-			{Error: "something bad happened"},
+			{PvcName: "data", Error: "something bad happened"},
 		}
 		client.httpDo = func(req *http.Request) (*http.Response, error) {
 			b, err := json.Marshal(stub)
@@ -73,14 +109,17 @@ func TestClient_DiskUsage(t *testing.T) {
 				panic(err)
 			}
 			return &http.Response{
-				Body: io.NopCloser(bytes.NewReader(b)),
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(bytes.NewReader(b)),
 			}, nil
 		}
 
 		_, err := client.DiskUsage(ctx, host)
 
 		require.Error(t, err)
-		require.EqualError(t, err, "no disk usage data")
+		require.EqualError(t, err, "no disk usage data: pvc data: something bad happened")
+		var verr *VolumeError
+		require.ErrorAs(t, err, &verr)
 	})
 
 	t.Run("invalid JSON", func(t *testing.T) {
@@ -88,7 +127,8 @@ func TestClient_DiskUsage(t *testing.T) {
 
 		client.httpDo = func(req *http.Request) (*http.Response, error) {
 			return &http.Response{
-				Body: io.NopCloser(strings.NewReader("{")),
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader("{")),
 			}, nil
 		}
 
@@ -103,7 +143,8 @@ func TestClient_DiskUsage(t *testing.T) {
 
 		client.httpDo = func(req *http.Request) (*http.Response, error) {
 			return &http.Response{
-				Body: io.NopCloser(strings.NewReader(`[]`)),
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader(`[]`)),
 			}, nil
 		}
 
@@ -112,4 +153,134 @@ func TestClient_DiskUsage(t *testing.T) {
 		require.Error(t, err)
 		require.EqualError(t, err, "no disk usage data")
 	})
+
+	t.Run("disk report", func(t *testing.T) {
+		client := NewClient(httpClient)
+
+		client.httpDo = func(req *http.Request) (*http.Response, error) {
+			require.Contains(t, req.Header.Get("Accept"), DiskReportMediaType)
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Header:     http.Header{"Content-Type": {DiskReportMediaType}},
+				Body: io.NopCloser(strings.NewReader(`{"schema_version":2,"sidecar_version":"v1.2.3","node_name":"node-1",` +
+					`"disks":[{"pvc_name":"data","all_bytes":100,"free_bytes":10,"available_bytes":5,"fs_type":"ext4"}]}`)),
+			}, nil
+		}
+
+		got, err := client.DiskReport(ctx, host)
+
+		require.NoError(t, err)
+		require.Equal(t, 2, got.SchemaVersion)
+		require.Equal(t, "v1.2.3", got.SidecarVersion)
+		require.Equal(t, "node-1", got.NodeName)
+		require.Equal(t, uint64(5), got.Disks[0].AvailableBytes)
+		require.Equal(t, "ext4", got.Disks[0].FSType)
+	})
+
+	t.Run("old sidecar", func(t *testing.T) {
+		client := NewClient(httpClient)
+
+		client.httpDo = func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusOK,
+				Body:       io.NopCloser(strings.NewReader(`[{"pvc_name":"data","all_bytes":100,"free_bytes":10}]`)),
+			}, nil
+		}
+
+		got, err := client.DiskReport(ctx, host)
+
+		require.NoError(t, err)
+		require.Equal(t, 1, got.SchemaVersion)
+		require.Equal(t, uint64(10), got.Disks[0].AvailableBytes)
+	})
+
+	t.Run("partial error", func(t *testing.T) {
+		client := NewClient(httpClient)
+
+		client.httpDo = func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusInternalServerError,
+				Body: io.NopCloser(strings.NewReader(
+					`[{"pvc_name":"data","all_bytes":100,"free_bytes":10},{"pvc_name":"logs","error":"no such file or directory"}]`)),
+			}, nil
+		}
+
+		got, err := client.DiskUsage(ctx, host)
+
+		require.Len(t, got, 1)
+		require.Equal(t, "data", got[0].PvcName)
+		var verr *VolumeError
+		require.ErrorAs(t, err, &verr)
+		require.EqualError(t, err, "pvc logs: no such file or directory")
+	})
+
+	t.Run("unexpected status", func(t *testing.T) {
+		client := NewClient(httpClient)
+
+		client.httpDo = func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusServiceUnavailable,
+				Status:     "503 Service Unavailable",
+				Body:       io.NopCloser(strings.NewReader("upstream connect error")),
+			}, nil
+		}
+
+		_, err := client.DiskUsage(ctx, host)
+
+		require.EqualError(t, err, "unexpected status: 503 Service Unavailable")
+		var rerr kube.ReconcileError
+		require.ErrorAs(t, err, &rerr)
+		require.True(t, rerr.IsTransient())
+	})
+}
+
+func TestClient_TopDirs(t *testing.T) {
+	var (
+		ctx        = context.Background()
+		httpClient = &http.Client{}
+	)
+
+	const host = "http://10.1.1.1"
+
+	t.Run("happy path", func(t *testing.T) {
+		client := NewClient(httpClient)
+
+		want := TopResponse{PvcName: "data", Dirs: []DirUsage{{Path: "logs", Bytes: 100}}}
+		client.httpDo = func(req *http.Request) (*http.Response, error) {
+			require.Equal(t, "http://10.1.1.1:1251/disk/top?depth=2&n=20&pvc=data", req.URL.String())
+
+			b, err := json.Marshal(want)
+			if err != nil {
+				panic(err)
+			}
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(b))}, nil
+		}
+
+		got, err := client.TopDirs(ctx, host, "data", 2, 20)
+
+		require.NoError(t, err)
+		require.Equal(t, want, got)
+	})
+
+	t.Run("error in response", func(t *testing.T) {
+		client := NewClient(httpClient)
+
+		client.httpDo = func(req *http.Request) (*http.Response, error) {
+			return &http.Response{
+				StatusCode: http.StatusTooManyRequests,
+				Body:       io.NopCloser(strings.NewReader(`{"pvc_name":"data","dirs":null,"error":"rate limited"}`)),
+			}, nil
+		}
+
+		_, err := client.TopDirs(ctx, host, "data", 2, 20)
+
+		require.Error(t, err)
+		require.EqualError(t, err, "rate limited")
+	})
+}
+
+type stubTokens map[string]string
+
+func (s stubTokens) Token(_ context.Context, namespace string) (string, error) {
+	return s[namespace], nil
 }
@@ -0,0 +1,133 @@
+package healthcheck
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"sigs.k8s.io/yaml"
+)
+
+// ConfigEnv is the environment variable holding the sidecar Config as JSON or YAML.
+const ConfigEnv = "HEALTHCHECK_CONFIG"
+
+// Volume is a PVC mounted in the sidecar.
+type Volume struct {
+	// Name is the name of the volume in the pod spec. Defaults to ClaimName.
+	Name string `json:"name,omitempty"`
+	// ClaimName is the name of the PVC.
+	ClaimName string `json:"claimName"`
+	// MountPath is where the volume is mounted in the sidecar.
+	MountPath string `json:"mountPath"`
+	// Ephemeral is true for a generic ephemeral volume whose PVC is named after the pod, see EphemeralClaimName.
+	// ClaimName may be empty if the pod's name was not known when the sidecar was injected; it is set by ResolveEphemeral.
+	Ephemeral bool `json:"ephemeral,omitempty"`
+	// ReadOnly is true if the application cannot write to the volume, i.e. the PVC is attached read-only or no
+	// container of the pod mounts it writable. The sidecar mounts every volume read-only, so it is set by the injector.
+	ReadOnly bool `json:"readOnly,omitempty"`
+}
+
+// EphemeralClaimName returns the name of the PVC created for the generic ephemeral volume of a pod.
+func EphemeralClaimName(podName, volumeName string) string {
+	return podName + "-" + volumeName
+}
+
+// Config describes the volumes checked by the sidecar.
+type Config struct {
+	Volumes []Volume `json:"volumes"`
+	// UsageProbe optionally reports the logical usage of the volumes.
+	UsageProbe *UsageProbe `json:"usageProbe,omitempty"`
+}
+
+// ParseConfig parses a JSON or YAML Config and validates it.
+func ParseConfig(data []byte) (Config, error) {
+	var cfg Config
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return Config{}, fmt.Errorf("parse config: %w", err)
+	}
+	seen := make(map[string]bool)
+	for i := range cfg.Volumes {
+		vol := &cfg.Volumes[i]
+		switch {
+		case vol.ClaimName == "" && !vol.Ephemeral:
+			return Config{}, fmt.Errorf("volumes[%d]: missing claimName", i)
+		case vol.ClaimName == "" && vol.Name == "":
+			return Config{}, fmt.Errorf("volumes[%d]: ephemeral volume without claimName requires name", i)
+		}
+		if !filepath.IsAbs(vol.MountPath) {
+			return Config{}, fmt.Errorf("volumes[%d]: mountPath must be absolute", i)
+		}
+		if vol.ClaimName != "" && seen[vol.ClaimName] {
+			return Config{}, fmt.Errorf("volumes[%d]: duplicate claimName %q", i, vol.ClaimName)
+		}
+		seen[vol.ClaimName] = true
+		if vol.Name == "" {
+			vol.Name = vol.ClaimName
+		}
+		vol.MountPath = filepath.Clean(vol.MountPath)
+	}
+	if len(cfg.Volumes) == 0 {
+		return Config{}, errors.New("config: no volumes")
+	}
+	if cfg.UsageProbe != nil {
+		if err := cfg.UsageProbe.Validate(); err != nil {
+			return Config{}, err
+		}
+	}
+	return cfg, nil
+}
+
+// ResolveEphemeral sets the claim name of the ephemeral volumes without one from the name of the pod.
+func (cfg *Config) ResolveEphemeral(podName string) error {
+	for i := range cfg.Volumes {
+		vol := &cfg.Volumes[i]
+		if !vol.Ephemeral || vol.ClaimName != "" {
+			continue
+		}
+		if podName == "" {
+			return fmt.Errorf("volume %s: pod name is required to resolve the claim of an ephemeral volume", vol.Name)
+		}
+		vol.ClaimName = EphemeralClaimName(podName, vol.Name)
+	}
+	return nil
+}
+
+// LoadConfig reads and parses the Config at path.
+func LoadConfig(path string) (Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Config{}, fmt.Errorf("read config: %w", err)
+	}
+	return ParseConfig(data)
+}
+
+// PVCVolumes returns the volumes of a comma delimited list of PVC names, each mounted on mount/<pvc>.
+func PVCVolumes(pvcs string, mount string) []Volume {
+	names := PVCNames(pvcs)
+	volumes := make([]Volume, 0, len(names))
+	for _, pvc := range names {
+		volumes = append(volumes, Volume{Name: pvc, ClaimName: pvc, MountPath: filepath.Clean(mount + "/" + pvc)})
+	}
+	return volumes
+}
+
+// Discover returns a volume for every directory under mount, sorted by claim name.
+// The operator mounts every PVC on mount/<pvc>, so the directory name is taken as the claim name.
+func Discover(mount string) ([]Volume, error) {
+	entries, err := os.ReadDir(mount)
+	if err != nil {
+		return nil, fmt.Errorf("discover volumes: %w", err)
+	}
+	var volumes []Volume
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		volumes = append(volumes, Volume{Name: entry.Name(), ClaimName: entry.Name(), MountPath: filepath.Join(mount, entry.Name())})
+	}
+	if len(volumes) == 0 {
+		return nil, fmt.Errorf("discover volumes: no directories under %s", mount)
+	}
+	return volumes, nil
+}
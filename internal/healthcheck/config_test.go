@@ -0,0 +1,126 @@
+package healthcheck
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseConfig(t *testing.T) {
+	t.Run("yaml", func(t *testing.T) {
+		cfg, err := ParseConfig([]byte(`
+volumes:
+- name: data-vol
+  claimName: data
+  mountPath: /mnt/data/
+- claimName: logs
+  mountPath: /mnt/logs
+`))
+
+		require.NoError(t, err)
+		require.Equal(t, []Volume{
+			{Name: "data-vol", ClaimName: "data", MountPath: "/mnt/data"},
+			{Name: "logs", ClaimName: "logs", MountPath: "/mnt/logs"},
+		}, cfg.Volumes)
+	})
+
+	t.Run("usage probe", func(t *testing.T) {
+		cfg, err := ParseConfig([]byte(`{"volumes":[{"claimName":"data","mountPath":"/mnt/data"}],"usageProbe":{"url":"http://127.0.0.1:8080/usage"}}`))
+
+		require.NoError(t, err)
+		require.Equal(t, &UsageProbe{URL: "http://127.0.0.1:8080/usage"}, cfg.UsageProbe)
+	})
+
+	t.Run("json", func(t *testing.T) {
+		cfg, err := ParseConfig([]byte(`{"volumes":[{"name":"v","claimName":"data","mountPath":"/mnt/data"}]}`))
+
+		require.NoError(t, err)
+		require.Equal(t, []Volume{{Name: "v", ClaimName: "data", MountPath: "/mnt/data"}}, cfg.Volumes)
+	})
+
+	t.Run("ephemeral", func(t *testing.T) {
+		cfg, err := ParseConfig([]byte(`volumes: [{name: scratch, ephemeral: true, mountPath: /mnt/_ephemeral/scratch}]`))
+
+		require.NoError(t, err)
+		require.Equal(t, []Volume{{Name: "scratch", MountPath: "/mnt/_ephemeral/scratch", Ephemeral: true}}, cfg.Volumes)
+	})
+
+	for _, tt := range []struct {
+		Name string
+		Data string
+		Err  string
+	}{
+		{"empty", `volumes: []`, "no volumes"},
+		{"missing claim", `volumes: [{mountPath: /mnt/data}]`, "missing claimName"},
+		{"ephemeral without name", `volumes: [{ephemeral: true, mountPath: /mnt/data}]`, "requires name"},
+		{"relative mount", `volumes: [{claimName: data, mountPath: data}]`, "mountPath must be absolute"},
+		{"duplicate claim", `volumes: [{claimName: data, mountPath: /a}, {claimName: data, mountPath: /b}]`, "duplicate claimName"},
+		{"unknown field", `volumes: [{claim: data, mountPath: /a}]`, "unknown field"},
+		{"invalid usage probe", `{volumes: [{claimName: data, mountPath: /a}], usageProbe: {url: /usage}}`, "usageProbe"},
+	} {
+		t.Run(tt.Name, func(t *testing.T) {
+			_, err := ParseConfig([]byte(tt.Data))
+
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tt.Err)
+		})
+	}
+}
+
+func TestConfig_ResolveEphemeral(t *testing.T) {
+	cfg := Config{Volumes: []Volume{
+		{Name: "data", ClaimName: "data", MountPath: "/mnt/data"},
+		{Name: "scratch", MountPath: "/mnt/_ephemeral/scratch", Ephemeral: true},
+		{Name: "cache", ClaimName: "web-0-cache", MountPath: "/mnt/web-0-cache", Ephemeral: true},
+	}}
+
+	require.Error(t, cfg.ResolveEphemeral(""))
+	require.NoError(t, cfg.ResolveEphemeral("web-7d4b9"))
+
+	require.Equal(t, []string{"data", "web-7d4b9-scratch", "web-0-cache"}, []string{
+		cfg.Volumes[0].ClaimName, cfg.Volumes[1].ClaimName, cfg.Volumes[2].ClaimName,
+	})
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`volumes: [{claimName: data, mountPath: /mnt/data}]`), 0o600))
+
+	cfg, err := LoadConfig(path)
+
+	require.NoError(t, err)
+	require.Len(t, cfg.Volumes, 1)
+
+	_, err = LoadConfig(filepath.Join(t.TempDir(), "missing"))
+
+	require.Error(t, err)
+}
+
+func TestPVCVolumes(t *testing.T) {
+	require.Equal(t, []Volume{
+		{Name: "a", ClaimName: "a", MountPath: "/mnt/a"},
+		{Name: "b", ClaimName: "b", MountPath: "/mnt/b"},
+	}, PVCVolumes("a,,b,a", "/mnt/"))
+}
+
+func TestDiscover(t *testing.T) {
+	mount := t.TempDir()
+	writeFile(t, filepath.Join(mount, "pvc-b", "f"), 1)
+	writeFile(t, filepath.Join(mount, "pvc-a", "f"), 1)
+	writeFile(t, filepath.Join(mount, "not-a-volume"), 1)
+
+	got, err := Discover(mount)
+
+	require.NoError(t, err)
+	require.Equal(t, []Volume{
+		{Name: "pvc-a", ClaimName: "pvc-a", MountPath: filepath.Join(mount, "pvc-a")},
+		{Name: "pvc-b", ClaimName: "pvc-b", MountPath: filepath.Join(mount, "pvc-b")},
+	}, got)
+
+	_, err = Discover(t.TempDir())
+
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "no directories")
+}
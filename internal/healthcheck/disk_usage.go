@@ -4,75 +4,190 @@ import (
 	"encoding/json"
 	"errors"
 	"io"
+	"mime"
 	"net/http"
-	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/version"
 	"github.com/samber/lo"
 )
 
+// DiskSchemaVersion is the version of DiskReport served by this sidecar.
+// Version 1 is a bare JSON array of DiskUsageResponse, served to clients which do not accept DiskReportMediaType.
+const DiskSchemaVersion = 2
+
+// DiskReportMediaType is accepted by clients which understand DiskReport.
+const DiskReportMediaType = "application/vnd.pvc-autoscaler-operator.disk-report.v2+json"
+
+// DiskReport is the versioned envelope of the disk statistics served by the sidecar.
+type DiskReport struct {
+	SchemaVersion int `json:"schema_version"`
+	// SidecarVersion is the version of the sidecar's build.
+	SidecarVersion string `json:"sidecar_version,omitempty"`
+	// SampledAt is when the disk statistics were taken.
+	SampledAt time.Time `json:"sampled_at"`
+	// NodeName is the node the sidecar runs on.
+	NodeName string              `json:"node_name,omitempty"`
+	Disks    []DiskUsageResponse `json:"disks"`
+}
+
+// NewDiskReport returns a DiskReport of disks sampled now by the sidecar on nodeName.
+func NewDiskReport(nodeName string, disks []DiskUsageResponse) DiskReport {
+	return DiskReport{
+		SchemaVersion:  DiskSchemaVersion,
+		SidecarVersion: version.AppVersion(),
+		SampledAt:      time.Now().UTC(),
+		NodeName:       nodeName,
+		Disks:          disks,
+	}
+}
+
 // DiskUsageResponse returns disk statistics in bytes.
 type DiskUsageResponse struct {
 	Dir       string `json:"dir"`
 	PvcName   string `json:"pvc_name"`
 	AllBytes  uint64 `json:"all_bytes,omitempty"`
 	FreeBytes uint64 `json:"free_bytes,omitempty"`
-	Error     string `json:"error,omitempty"`
+	// AvailableBytes is the free space available to unprivileged users, excluding blocks reserved for root.
+	// Clients set it to FreeBytes for sidecars which predate schema version 2.
+	AvailableBytes uint64 `json:"available_bytes,omitempty"`
+	// FSType is the filesystem type, e.g. ext4, or its magic number in hex if unknown.
+	FSType string `json:"fs_type,omitempty"`
+	// ReadOnly is true if the application cannot write to the volume, see Volume.ReadOnly.
+	ReadOnly bool   `json:"read_only,omitempty"`
+	Error    string `json:"error,omitempty"`
+	// LogicalUsedBytes is the usage reported by the application's UsageProbe, if configured.
+	// The operator prefers it over the statfs usage. It is nil if the probe did not report the PVC, so a
+	// reported usage of 0 bytes is kept.
+	LogicalUsedBytes *uint64 `json:"logical_used_bytes,omitempty"`
+	// LogicalError is the error of the UsageProbe, if it failed.
+	LogicalError string `json:"logical_error,omitempty"`
+	// ResizeRequest is the pending resize request of the application, see ResizeRequests.
+	ResizeRequest *ResizeRequest `json:"resize_request,omitempty"`
+	// GrowthBytesPerSecond is the trend of used bytes over the sidecar's recent samples.
+	// Zero if the sidecar does not sample or has too few samples.
+	GrowthBytesPerSecond float64 `json:"growth_bytes_per_second,omitempty"`
+	// TimeToFullSeconds is the estimated time until the volume is full at the current growth rate.
+	// Zero if usage is not growing.
+	TimeToFullSeconds int64 `json:"time_to_full_seconds,omitempty"`
 }
 
-// DiskUsage returns a handler which responds with disk statistics in JSON.
-// Path is the filesystem path from which to check disk usage.
-func DiskUsage(pvcs string, mount string) http.HandlerFunc {
+// DiskUsage returns a handler which responds with disk statistics of volumes in JSON.
+// Clients accepting DiskReportMediaType get a DiskReport, others a bare array of DiskUsageResponse.
+// NodeName is the node the sidecar runs on.
+func DiskUsage(volumes []Volume, nodeName string) http.HandlerFunc {
+	return DiskUsageHandler(volumes, nodeName, func() ([]DiskUsageResponse, error) {
+		return DiskStats(volumes)
+	})
+}
+
+// DiskUsageHandler returns a handler like DiskUsage which responds with the disk statistics returned by stat.
+func DiskUsageHandler(volumes []Volume, nodeName string, stat func() ([]DiskUsageResponse, error)) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		var resps = make([]DiskUsageResponse, 0)
-		pvcList := lo.Filter(lo.Uniq(strings.Split(pvcs, ",")), func(name string, _ int) bool {
-			return name != ""
-		})
-		var merr error
-
-		if len(pvcList) == 0 {
-			w.WriteHeader(http.StatusInternalServerError)
+		var (
+			resps  = make([]DiskUsageResponse, 0)
+			status = http.StatusOK
+		)
+		if len(volumes) == 0 {
+			status = http.StatusInternalServerError
+		} else {
+			var err error
+			if resps, err = stat(); err != nil {
+				status = http.StatusInternalServerError
+			}
+		}
+
+		if !acceptsDiskReport(r) {
+			w.WriteHeader(status)
 			mustJSONEncode(resps, w)
 			return
 		}
+		w.Header().Set("Content-Type", DiskReportMediaType)
+		w.WriteHeader(status)
+		mustJSONEncode(NewDiskReport(nodeName, resps), w)
+	}
+}
 
-		for _, pvc := range pvcList {
-			// it should be mounted on /mnt/<pvc>
-			dir := filepath.Clean(mount + "/" + pvc)
-			var resp DiskUsageResponse
-
-			resp.Dir = dir
-			resp.PvcName = pvc
-			var fs syscall.Statfs_t
-			// Purposefully not adding test hook, so tests may catch OS issues.
-			err := syscall.Statfs(dir, &fs)
-			if err != nil {
-				resp.Error = err.Error()
-				resps = append(resps, resp)
-				merr = errors.Join(merr, err)
-
-				continue
+func acceptsDiskReport(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, mediaType := range strings.Split(accept, ",") {
+			if mt, _, err := mime.ParseMediaType(mediaType); err == nil && mt == DiskReportMediaType {
+				return true
 			}
+		}
+	}
+	return false
+}
 
-			all := fs.Blocks * uint64(fs.Bsize)
-			free := fs.Bfree * uint64(fs.Bsize)
+// PVCNames splits a comma delimited list of PVC names, dropping empty and duplicate names.
+func PVCNames(pvcs string) []string {
+	return lo.Filter(lo.Uniq(strings.Split(pvcs, ",")), func(name string, _ int) bool {
+		return name != ""
+	})
+}
 
-			resp.AllBytes = all
-			resp.FreeBytes = free
+// DiskStats returns disk statistics for each volume.
+// If statfs fails for any volume, the error is reported in its DiskUsageResponse and joined into the returned error.
+func DiskStats(volumes []Volume) ([]DiskUsageResponse, error) {
+	var (
+		resps = make([]DiskUsageResponse, 0, len(volumes))
+		merr  error
+	)
+	for _, vol := range volumes {
+		var resp DiskUsageResponse
 
+		resp.Dir = vol.MountPath
+		resp.PvcName = vol.ClaimName
+		resp.ReadOnly = vol.ReadOnly
+		fs, err := statfs(vol.MountPath)
+		if err != nil {
+			resp.Error = err.Error()
 			resps = append(resps, resp)
-		}
+			merr = errors.Join(merr, err)
 
-		if merr != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			mustJSONEncode(resps, w)
-			return
+			continue
 		}
 
-		w.WriteHeader(http.StatusOK)
-		mustJSONEncode(resps, w)
+		resp.AllBytes = fs.Blocks * uint64(fs.Bsize)
+		resp.FreeBytes = fs.Bfree * uint64(fs.Bsize)
+		resp.AvailableBytes = fs.Bavail * uint64(fs.Bsize)
+		// Statfs_t.Type is int32 on some 32-bit platforms, where magic numbers above 0x7fffffff are negative.
+		resp.FSType = fsType(int64(uint32(fs.Type)))
+
+		resps = append(resps, resp)
+	}
+	return resps, merr
+}
+
+func statfs(dir string) (syscall.Statfs_t, error) {
+	var fs syscall.Statfs_t
+	// Purposefully not adding test hook, so tests may catch OS issues.
+	err := syscall.Statfs(dir, &fs)
+	return fs, err
+}
+
+// fsTypes maps filesystem magic numbers of statfs(2) to names.
+var fsTypes = map[int64]string{
+	0xef53:     "ext4", // also ext2 and ext3
+	0x58465342: "xfs",
+	0x9123683e: "btrfs",
+	0x2fc12fc1: "zfs",
+	0x01021994: "tmpfs",
+	0x794c7630: "overlay",
+	0x6969:     "nfs",
+	0x00c36400: "ceph",
+	0xff534d42: "cifs",
+	0x65735546: "fuse",
+}
+
+func fsType(magic int64) string {
+	if name, ok := fsTypes[magic]; ok {
+		return name
 	}
+	return "0x" + strconv.FormatInt(magic, 16)
 }
 
 func mustJSONEncode(v interface{}, w io.Writer) {
@@ -13,7 +13,7 @@ func TestDiskUsage(t *testing.T) {
 		var (
 			w       = httptest.NewRecorder()
 			r       = httptest.NewRequest("GET", "/ignored", nil)
-			handler = DiskUsage("tmp", "/")
+			handler = DiskUsage(PVCVolumes("tmp", "/"), "node")
 		)
 		handler(w, r)
 
@@ -38,7 +38,7 @@ func TestDiskUsage(t *testing.T) {
 		var (
 			w       = httptest.NewRecorder()
 			r       = httptest.NewRequest("GET", "/ignored", nil)
-			handler = DiskUsage(pvc, "/")
+			handler = DiskUsage(PVCVolumes(pvc, "/"), "node")
 		)
 		handler(w, r)
 
@@ -54,4 +54,43 @@ func TestDiskUsage(t *testing.T) {
 		require.NotContains(t, w.Body.String(), "all_bytes")
 		require.NotContains(t, w.Body.String(), "free_bytes")
 	})
+
+	t.Run("disk report", func(t *testing.T) {
+		var (
+			w       = httptest.NewRecorder()
+			r       = httptest.NewRequest("GET", "/ignored", nil)
+			handler = DiskUsage(PVCVolumes("tmp", "/"), "node")
+		)
+		r.Header.Set("Accept", DiskReportMediaType+", application/json")
+		handler(w, r)
+
+		require.Equal(t, 200, w.Code)
+		require.Equal(t, DiskReportMediaType, w.Header().Get("Content-Type"))
+
+		var report DiskReport
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+		require.Equal(t, DiskSchemaVersion, report.SchemaVersion)
+		require.Equal(t, "(devel)", report.SidecarVersion)
+		require.Equal(t, "node", report.NodeName)
+		require.False(t, report.SampledAt.IsZero())
+
+		got := report.Disks[0]
+		require.Equal(t, "tmp", got.PvcName)
+		require.NotZero(t, got.AvailableBytes)
+		require.LessOrEqual(t, got.AvailableBytes, got.FreeBytes)
+		require.NotEmpty(t, got.FSType)
+		require.False(t, got.ReadOnly)
+	})
+
+	t.Run("read only", func(t *testing.T) {
+		// The sidecar mounts every volume read-only, so the flag comes from the config.
+		got, err := DiskStats([]Volume{
+			{ClaimName: "data", MountPath: "/tmp", ReadOnly: true},
+			{ClaimName: "logs", MountPath: "/tmp"},
+		})
+
+		require.NoError(t, err)
+		require.True(t, got[0].ReadOnly)
+		require.False(t, got[1].ReadOnly)
+	})
 }
@@ -3,6 +3,20 @@ package healthcheck
 // Port is the port for the healthcheck sidecar.
 const Port = 1251
 
+// GRPCPort is the port for the healthcheck sidecar's gRPC service.
+const GRPCPort = 1252
+
 // Mount is the mount point for the healthcheck sidecar pvc.
 // it should be mounted on /<Mount>/<pvc>
 const Mount = "/mnt"
+
+// LocalPort is the port on which the healthcheck sidecar accepts requests from containers in the same pod.
+// It is bound to the loopback interface only.
+const LocalPort = 1253
+
+// LocalTokenDir is the directory shared by the sidecar with the containers of its pod, where it writes the token
+// they send with requests on LocalPort. Other pods, even on the host network, cannot read it.
+const LocalTokenDir = "/var/run/pvc-autoscaler-operator/local"
+
+// LocalTokenFile is the file in LocalTokenDir holding the token.
+const LocalTokenFile = "token"
@@ -0,0 +1,92 @@
+package healthcheck
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Labels match the kubelet's kubelet_volume_stats_* metrics.
+var volumeLabels = []string{"namespace", "persistentvolumeclaim"}
+
+var (
+	capacityDesc = prometheus.NewDesc("pvc_autoscaler_volume_capacity_bytes",
+		"Capacity in bytes of the volume.", volumeLabels, nil)
+	usedDesc = prometheus.NewDesc("pvc_autoscaler_volume_used_bytes",
+		"Number of used bytes in the volume.", volumeLabels, nil)
+	freeDesc = prometheus.NewDesc("pvc_autoscaler_volume_free_bytes",
+		"Number of free bytes in the volume.", volumeLabels, nil)
+	availableDesc = prometheus.NewDesc("pvc_autoscaler_volume_available_bytes",
+		"Number of bytes available to unprivileged users in the volume, excluding blocks reserved for root.", volumeLabels, nil)
+	inodesDesc = prometheus.NewDesc("pvc_autoscaler_volume_inodes",
+		"Maximum number of inodes in the volume.", volumeLabels, nil)
+	inodesFreeDesc = prometheus.NewDesc("pvc_autoscaler_volume_inodes_free",
+		"Number of free inodes in the volume.", volumeLabels, nil)
+)
+
+// MetricsCollector is a prometheus.Collector reporting disk statistics of PVCs.
+// Statistics are gathered when scraped, the same way as the /disk endpoint.
+type MetricsCollector struct {
+	volumes    []Volume
+	namespace  string
+	statErrors *prometheus.CounterVec
+}
+
+// NewMetricsCollector returns a MetricsCollector for volumes.
+// Namespace is the namespace of the PVCs, i.e. the namespace of the pod.
+func NewMetricsCollector(volumes []Volume, namespace string) *MetricsCollector {
+	return &MetricsCollector{
+		volumes:   volumes,
+		namespace: namespace,
+		statErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "pvc_autoscaler_volume_statfs_errors_total",
+			Help: "Number of failed statfs calls on the volume.",
+		}, volumeLabels),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *MetricsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- capacityDesc
+	ch <- usedDesc
+	ch <- freeDesc
+	ch <- availableDesc
+	ch <- inodesDesc
+	ch <- inodesFreeDesc
+	c.statErrors.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (c *MetricsCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, vol := range c.volumes {
+		pvc := vol.ClaimName
+		fs, err := statfs(vol.MountPath)
+		if err != nil {
+			c.statErrors.WithLabelValues(c.namespace, pvc).Inc()
+			continue
+		}
+
+		var (
+			all  = fs.Blocks * uint64(fs.Bsize)
+			free = fs.Bfree * uint64(fs.Bsize)
+		)
+		gauge := func(desc *prometheus.Desc, v uint64) {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(v), c.namespace, pvc)
+		}
+		gauge(capacityDesc, all)
+		gauge(usedDesc, all-free)
+		gauge(freeDesc, free)
+		gauge(availableDesc, fs.Bavail*uint64(fs.Bsize))
+		gauge(inodesDesc, fs.Files)
+		gauge(inodesFreeDesc, fs.Ffree)
+	}
+	c.statErrors.Collect(ch)
+}
+
+// Metrics returns a handler which serves disk statistics in the Prometheus exposition format.
+func Metrics(volumes []Volume, namespace string) http.Handler {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(NewMetricsCollector(volumes, namespace))
+	return promhttp.HandlerFor(reg, promhttp.HandlerOpts{})
+}
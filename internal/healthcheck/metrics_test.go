@@ -0,0 +1,33 @@
+package healthcheck
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	const pvc = "this-directory-had-better-not-be-present-in-any-sort-of-test-environment"
+	var (
+		w       = httptest.NewRecorder()
+		r       = httptest.NewRequest("GET", "/metrics", nil)
+		handler = Metrics(PVCVolumes("tmp,"+pvc, "/"), "default")
+	)
+	handler.ServeHTTP(w, r)
+
+	require.Equal(t, 200, w.Code)
+
+	body := w.Body.String()
+	for _, name := range []string{
+		"pvc_autoscaler_volume_capacity_bytes",
+		"pvc_autoscaler_volume_used_bytes",
+		"pvc_autoscaler_volume_free_bytes",
+		"pvc_autoscaler_volume_inodes",
+		"pvc_autoscaler_volume_inodes_free",
+	} {
+		require.Contains(t, body, name+`{namespace="default",persistentvolumeclaim="tmp"}`)
+		require.NotContains(t, body, name+`{namespace="default",persistentvolumeclaim="`+pvc+`"}`)
+	}
+	require.Contains(t, body, `pvc_autoscaler_volume_statfs_errors_total{namespace="default",persistentvolumeclaim="`+pvc+`"} 1`)
+}
@@ -0,0 +1,50 @@
+package healthcheck
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// ReadyCheck returns an error if the sidecar is not ready to serve.
+type ReadyCheck func() error
+
+// Healthz returns a handler for liveness probes, which responds with 200 OK while the server is running.
+func Healthz() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok\n"))
+	}
+}
+
+// Readyz returns a handler for readiness probes, which responds with 200 OK if all checks pass,
+// otherwise 503 Service Unavailable listing the failed checks.
+// Disk errors are deliberately not checked. The sidecar runs in the application pod, so a single broken mount
+// would take the application out of Service endpoints; disk errors are reported to the operator by /disk instead.
+func Readyz(checks map[string]ReadyCheck) http.HandlerFunc {
+	names := make([]string, 0, len(checks))
+	for name := range checks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		var failed []string
+		for _, name := range names {
+			if err := checks[name](); err != nil {
+				failed = append(failed, fmt.Sprintf("%s: %v", name, err))
+			}
+		}
+		if len(failed) > 0 {
+			http.Error(w, strings.Join(failed, "\n"), http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("ok\n"))
+	}
+}
+
+// errNotSampled is returned by Sampler.Ready until the first sample is taken.
+var errNotSampled = errors.New("disk usage not sampled yet")
@@ -0,0 +1,58 @@
+package healthcheck
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthz(t *testing.T) {
+	w := httptest.NewRecorder()
+	Healthz()(w, httptest.NewRequest("GET", "/healthz", nil))
+
+	require.Equal(t, 200, w.Code)
+	require.Equal(t, "ok\n", w.Body.String())
+}
+
+func TestReadyz(t *testing.T) {
+	t.Run("ready", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		Readyz(map[string]ReadyCheck{"a": func() error { return nil }})(w, httptest.NewRequest("GET", "/readyz", nil))
+
+		require.Equal(t, 200, w.Code)
+	})
+
+	t.Run("no checks", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		Readyz(nil)(w, httptest.NewRequest("GET", "/readyz", nil))
+
+		require.Equal(t, 200, w.Code)
+	})
+
+	t.Run("not ready", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		Readyz(map[string]ReadyCheck{
+			"b": func() error { return errors.New("boom") },
+			"a": func() error { return errors.New("bang") },
+			"c": func() error { return nil },
+		})(w, httptest.NewRequest("GET", "/readyz", nil))
+
+		require.Equal(t, 503, w.Code)
+		require.Equal(t, "a: bang\nb: boom\n", w.Body.String())
+	})
+
+	t.Run("ignores disk errors", func(t *testing.T) {
+		s := NewSampler(PVCVolumes("this-directory-had-better-not-be-present", "/"), time.Minute, 3)
+		require.Error(t, s.Ready())
+
+		s.sample()
+
+		w := httptest.NewRecorder()
+		Readyz(map[string]ReadyCheck{"sampler": s.Ready})(w, httptest.NewRequest("GET", "/readyz", nil))
+
+		require.Equal(t, 200, w.Code)
+	})
+}
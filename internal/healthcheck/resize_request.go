@@ -0,0 +1,164 @@
+package healthcheck
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/samber/lo"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// DefaultResizeRequestTTL is how long a resize request is reported to the operator unless it is satisfied earlier.
+const DefaultResizeRequestTTL = time.Hour
+
+// ResizeRequest asks the operator to grow a PVC before it reaches its usage threshold,
+// e.g. ahead of a database compaction. The operator applies the same safeguards as for any resize.
+type ResizeRequest struct {
+	// MinFreeBytes is the space the application needs to be available.
+	MinFreeBytes uint64 `json:"min_free_bytes,omitempty"`
+	// MinSizeBytes is the capacity the application needs.
+	MinSizeBytes uint64    `json:"min_size_bytes,omitempty"`
+	Reason       string    `json:"reason,omitempty"`
+	RequestedAt  time.Time `json:"requested_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// ResizeRequestBody is the body of POST /request-resize. MinFree and MinSize are resource quantities, e.g. 100Gi.
+type ResizeRequestBody struct {
+	PvcName string `json:"pvc"`
+	MinFree string `json:"min_free,omitempty"`
+	MinSize string `json:"min_size,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// ResizeRequests keeps the pending resize requests of the application and reports them with disk usage.
+// A request is dropped once it expires or the volume satisfies it.
+type ResizeRequests struct {
+	volumes []Volume
+	ttl     time.Duration
+	now     func() time.Time
+
+	mu      sync.Mutex
+	pending map[string]ResizeRequest
+}
+
+// NewResizeRequests returns ResizeRequests for volumes, each kept for at most ttl.
+func NewResizeRequests(volumes []Volume, ttl time.Duration) *ResizeRequests {
+	return &ResizeRequests{
+		volumes: volumes,
+		ttl:     ttl,
+		now:     time.Now,
+		pending: make(map[string]ResizeRequest),
+	}
+}
+
+// Handler returns a handler of POST /request-resize which responds 202 Accepted with the ResizeRequest in JSON.
+// Only requests from the loopback interface are accepted. The loopback interface is shared by every pod on the host
+// network, so the handler must also require the token shared with the containers of the pod, see RequireToken.
+func (r *ResizeRequests) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !isLoopback(req.RemoteAddr) {
+			http.Error(w, "resize requests are only accepted from the pod", http.StatusForbidden)
+			return
+		}
+
+		var body ResizeRequestBody
+		dec := json.NewDecoder(http.MaxBytesReader(w, req.Body, 1<<10))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&body); err != nil {
+			http.Error(w, "malformed json: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !lo.ContainsBy(r.volumes, func(vol Volume) bool { return vol.ClaimName == body.PvcName }) {
+			http.Error(w, "unknown pvc", http.StatusNotFound)
+			return
+		}
+		request, err := r.parse(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		r.mu.Lock()
+		r.pending[body.PvcName] = request
+		r.mu.Unlock()
+
+		w.WriteHeader(http.StatusAccepted)
+		mustJSONEncode(request, w)
+	}
+}
+
+func (r *ResizeRequests) parse(body ResizeRequestBody) (ResizeRequest, error) {
+	now := r.now()
+	request := ResizeRequest{Reason: body.Reason, RequestedAt: now, ExpiresAt: now.Add(r.ttl)}
+	if body.MinFree == "" && body.MinSize == "" {
+		return request, errors.New("min_free or min_size is required")
+	}
+	if body.MinFree != "" {
+		q, err := resource.ParseQuantity(body.MinFree)
+		if err != nil || q.Sign() <= 0 {
+			return request, errors.New("min_free: must be a positive quantity, e.g. 100Gi")
+		}
+		request.MinFreeBytes = uint64(q.Value())
+	}
+	if body.MinSize != "" {
+		q, err := resource.ParseQuantity(body.MinSize)
+		if err != nil || q.Sign() <= 0 {
+			return request, errors.New("min_size: must be a positive quantity, e.g. 100Gi")
+		}
+		request.MinSizeBytes = uint64(q.Value())
+	}
+	return request, nil
+}
+
+// Attach sets the pending resize request of every PVC in resps, dropping requests which expired or are satisfied.
+// Safe to call on a nil ResizeRequests.
+func (r *ResizeRequests) Attach(resps []DiskUsageResponse) []DiskUsageResponse {
+	if r == nil {
+		return resps
+	}
+	now := r.now()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i := range resps {
+		resp := &resps[i]
+		request, ok := r.pending[resp.PvcName]
+		if !ok {
+			continue
+		}
+		satisfied := resp.Error == "" && resp.AvailableBytes >= request.MinFreeBytes && resp.AllBytes >= request.MinSizeBytes
+		if satisfied || !now.Before(request.ExpiresAt) {
+			delete(r.pending, resp.PvcName)
+			continue
+		}
+		resp.ResizeRequest = &request
+	}
+	return resps
+}
+
+// Stat returns stat with the pending resize requests attached.
+func (r *ResizeRequests) Stat(stat func() ([]DiskUsageResponse, error)) func() ([]DiskUsageResponse, error) {
+	return func() ([]DiskUsageResponse, error) {
+		resps, err := stat()
+		return r.Attach(resps), err
+	}
+}
+
+func isLoopback(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,121 @@
+package healthcheck
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResizeRequests_Handler(t *testing.T) {
+	post := func(requests *ResizeRequests, remoteAddr, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/request-resize", strings.NewReader(body))
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		requests.Handler()(w, r)
+		return w
+	}
+
+	t.Run("happy path", func(t *testing.T) {
+		now := time.Now()
+		requests := NewResizeRequests(PVCVolumes("data", "/mnt"), time.Hour)
+		requests.now = func() time.Time { return now }
+
+		w := post(requests, "127.0.0.1:40000", `{"pvc":"data","min_free":"100Gi","reason":"compaction"}`)
+
+		require.Equal(t, 202, w.Code)
+		var got ResizeRequest
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+		require.Equal(t, uint64(100<<30), got.MinFreeBytes)
+		require.Equal(t, "compaction", got.Reason)
+		require.True(t, now.Add(time.Hour).Equal(got.ExpiresAt))
+
+		w = post(requests, "[::1]:40000", `{"pvc":"data","min_size":"200Gi"}`)
+
+		require.Equal(t, 202, w.Code)
+		require.Equal(t, uint64(200<<30), requests.pending["data"].MinSizeBytes, "replaces the previous request")
+		require.Zero(t, requests.pending["data"].MinFreeBytes)
+	})
+
+	for _, tt := range []struct {
+		Name       string
+		RemoteAddr string
+		Body       string
+		Code       int
+	}{
+		{"not loopback", "10.1.1.1:40000", `{"pvc":"data","min_free":"1Gi"}`, 403},
+		{"unknown pvc", "127.0.0.1:40000", `{"pvc":"other","min_free":"1Gi"}`, 404},
+		{"missing size", "127.0.0.1:40000", `{"pvc":"data"}`, 400},
+		{"invalid quantity", "127.0.0.1:40000", `{"pvc":"data","min_size":"lots"}`, 400},
+		{"negative quantity", "127.0.0.1:40000", `{"pvc":"data","min_free":"-1Gi"}`, 400},
+		{"unknown field", "127.0.0.1:40000", `{"pvc":"data","size":"1Gi"}`, 400},
+	} {
+		t.Run(tt.Name, func(t *testing.T) {
+			requests := NewResizeRequests(PVCVolumes("data", "/mnt"), time.Hour)
+
+			w := post(requests, tt.RemoteAddr, tt.Body)
+
+			require.Equal(t, tt.Code, w.Code)
+			require.Empty(t, requests.pending)
+		})
+	}
+
+	t.Run("method not allowed", func(t *testing.T) {
+		requests := NewResizeRequests(PVCVolumes("data", "/mnt"), time.Hour)
+		r := httptest.NewRequest("GET", "/request-resize", nil)
+		r.RemoteAddr = "127.0.0.1:40000"
+		w := httptest.NewRecorder()
+
+		requests.Handler()(w, r)
+
+		require.Equal(t, 405, w.Code)
+	})
+}
+
+func TestResizeRequests_Attach(t *testing.T) {
+	now := time.Now()
+	requests := NewResizeRequests(PVCVolumes("data,logs", "/mnt"), time.Hour)
+	requests.now = func() time.Time { return now }
+	requests.pending["data"] = ResizeRequest{MinFreeBytes: 500, ExpiresAt: now.Add(time.Hour)}
+	requests.pending["logs"] = ResizeRequest{MinSizeBytes: 2000, ExpiresAt: now.Add(time.Hour)}
+
+	stat := func() ([]DiskUsageResponse, error) {
+		return []DiskUsageResponse{
+			{PvcName: "data", AllBytes: 1000, FreeBytes: 100, AvailableBytes: 100},
+			{PvcName: "logs", AllBytes: 1000, FreeBytes: 900, AvailableBytes: 900},
+		}, nil
+	}
+
+	got, err := requests.Stat(stat)()
+
+	require.NoError(t, err)
+	require.Equal(t, uint64(500), got[0].ResizeRequest.MinFreeBytes)
+	require.Equal(t, uint64(2000), got[1].ResizeRequest.MinSizeBytes)
+
+	t.Run("satisfied", func(t *testing.T) {
+		got := requests.Attach([]DiskUsageResponse{{PvcName: "data", AllBytes: 2000, FreeBytes: 1100, AvailableBytes: 1100}})
+
+		require.Nil(t, got[0].ResizeRequest)
+		require.NotContains(t, requests.pending, "data")
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		now = now.Add(time.Hour)
+
+		got, err := requests.Stat(stat)()
+
+		require.NoError(t, err)
+		require.Nil(t, got[1].ResizeRequest)
+		require.Empty(t, requests.pending)
+	})
+
+	t.Run("nil", func(t *testing.T) {
+		var requests *ResizeRequests
+		resps := []DiskUsageResponse{{PvcName: "data"}}
+
+		require.Equal(t, resps, requests.Attach(resps))
+	})
+}
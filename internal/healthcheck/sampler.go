@@ -0,0 +1,147 @@
+package healthcheck
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultSampleHistory is the default number of samples kept per PVC to compute growth.
+const DefaultSampleHistory = 40
+
+type usageSample struct {
+	at   time.Time
+	used uint64
+}
+
+// Sampler samples disk usage of every PVC on its own interval and keeps a short history,
+// so responses include growth rate and time to full even if the operator polls rarely or restarts.
+type Sampler struct {
+	volumes  []Volume
+	interval time.Duration
+	size     int
+	stat     func(volumes []Volume) ([]DiskUsageResponse, error)
+	now      func() time.Time
+
+	mu      sync.Mutex
+	history map[string][]usageSample
+	sampled bool
+}
+
+// NewSampler returns a Sampler which checks disk usage of volumes every interval,
+// keeping the last size samples of each PVC.
+func NewSampler(volumes []Volume, interval time.Duration, size int) *Sampler {
+	return &Sampler{
+		volumes:  volumes,
+		interval: interval,
+		size:     size,
+		stat:     DiskStats,
+		now:      time.Now,
+		history:  make(map[string][]usageSample),
+	}
+}
+
+// Run samples disk usage until ctx is done.
+func (s *Sampler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		s.sample()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Sampler) sample() {
+	// Statfs errors are skipped; the trend is computed from the remaining samples.
+	resps, _ := s.stat(s.volumes)
+	now := s.now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sampled = true
+	for _, resp := range resps {
+		if resp.Error != "" || resp.AllBytes == 0 {
+			continue
+		}
+		history := append(s.history[resp.PvcName], usageSample{at: now, used: resp.AllBytes - resp.FreeBytes})
+		if len(history) > s.size {
+			history = history[len(history)-s.size:]
+		}
+		s.history[resp.PvcName] = history
+	}
+}
+
+// Ready returns an error until the first sample is taken, even if statfs failed for every PVC.
+// It implements ReadyCheck.
+func (s *Sampler) Ready() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.sampled {
+		return errNotSampled
+	}
+	return nil
+}
+
+// DiskStats returns the current disk statistics of every PVC like DiskStats, including growth rate
+// and time to full once at least two samples were taken.
+func (s *Sampler) DiskStats() ([]DiskUsageResponse, error) {
+	resps, err := s.stat(s.volumes)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range resps {
+		resp := &resps[i]
+		if resp.Error != "" {
+			continue
+		}
+		rate, ok := growthRate(s.history[resp.PvcName])
+		if !ok {
+			continue
+		}
+		resp.GrowthBytesPerSecond = rate
+		if rate > 0 {
+			// The volume is full for the application once it runs out of space available to unprivileged users.
+			resp.TimeToFullSeconds = int64(math.Round(float64(resp.AvailableBytes) / rate))
+		}
+	}
+	return resps, err
+}
+
+// DiskUsage returns a handler like DiskUsage which includes growth rate and time to full.
+func (s *Sampler) DiskUsage(nodeName string) http.HandlerFunc {
+	return DiskUsageHandler(s.volumes, nodeName, s.DiskStats)
+}
+
+// growthRate returns the slope of used bytes over time in bytes per second, fitted by least squares.
+// Returns false if the samples span no time.
+func growthRate(history []usageSample) (float64, bool) {
+	if len(history) < 2 {
+		return 0, false
+	}
+	var (
+		start        = history[0].at
+		meanX, meanY float64
+		sumXY, sumXX float64
+		n            = float64(len(history))
+	)
+	for _, sample := range history {
+		meanX += sample.at.Sub(start).Seconds() / n
+		meanY += float64(sample.used) / n
+	}
+	for _, sample := range history {
+		dx := sample.at.Sub(start).Seconds() - meanX
+		dy := float64(sample.used) - meanY
+		sumXY += dx * dy
+		sumXX += dx * dx
+	}
+	if sumXX == 0 {
+		return 0, false
+	}
+	return sumXY / sumXX, true
+}
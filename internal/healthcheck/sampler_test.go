@@ -0,0 +1,116 @@
+package healthcheck
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSampler(t *testing.T) {
+	start := time.Now()
+
+	newSampler := func(free *uint64) (*Sampler, *time.Time) {
+		now := start
+		s := NewSampler(PVCVolumes("data", "/"), time.Minute, 3)
+		s.now = func() time.Time { return now }
+		s.stat = func(volumes []Volume) ([]DiskUsageResponse, error) {
+			return []DiskUsageResponse{{Dir: "/data", PvcName: "data", AllBytes: 10000, FreeBytes: *free, AvailableBytes: *free}}, nil
+		}
+		return s, &now
+	}
+
+	t.Run("growth and time to full", func(t *testing.T) {
+		free := uint64(9000)
+		s, now := newSampler(&free)
+
+		got, err := s.DiskStats()
+		require.NoError(t, err)
+		require.Zero(t, got[0].GrowthBytesPerSecond, "no trend without samples")
+
+		for i := 0; i < 4; i++ {
+			s.sample()
+			*now = now.Add(10 * time.Second)
+			free -= 100
+		}
+
+		got, err = s.DiskStats()
+
+		require.NoError(t, err)
+		require.InDelta(t, 10, got[0].GrowthBytesPerSecond, 0.001)
+		require.Equal(t, int64(860), got[0].TimeToFullSeconds)
+		require.Len(t, s.history["data"], 3, "history is capped")
+	})
+
+	t.Run("shrinking usage", func(t *testing.T) {
+		free := uint64(5000)
+		s, now := newSampler(&free)
+
+		for i := 0; i < 3; i++ {
+			s.sample()
+			*now = now.Add(10 * time.Second)
+			free += 100
+		}
+
+		got, err := s.DiskStats()
+
+		require.NoError(t, err)
+		require.InDelta(t, -10, got[0].GrowthBytesPerSecond, 0.001)
+		require.Zero(t, got[0].TimeToFullSeconds)
+	})
+
+	t.Run("statfs error", func(t *testing.T) {
+		s := NewSampler(PVCVolumes("data", "/"), time.Minute, 3)
+		s.stat = func(volumes []Volume) ([]DiskUsageResponse, error) {
+			return []DiskUsageResponse{{PvcName: "data", Error: "boom"}}, errors.New("boom")
+		}
+
+		s.sample()
+		got, err := s.DiskStats()
+
+		require.Error(t, err)
+		require.Empty(t, s.history)
+		require.Equal(t, "boom", got[0].Error)
+	})
+
+	t.Run("handler", func(t *testing.T) {
+		free := uint64(9000)
+		s, now := newSampler(&free)
+		s.sample()
+		*now = now.Add(10 * time.Second)
+		free = 8000
+		s.sample()
+
+		w := httptest.NewRecorder()
+		s.DiskUsage("node")(w, httptest.NewRequest("GET", "/disk", nil))
+
+		require.Equal(t, 200, w.Code)
+		var resp []DiskUsageResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		require.InDelta(t, 100, resp[0].GrowthBytesPerSecond, 0.001)
+		require.Equal(t, int64(80), resp[0].TimeToFullSeconds)
+	})
+}
+
+func TestGrowthRate(t *testing.T) {
+	start := time.Now()
+
+	_, ok := growthRate(nil)
+	require.False(t, ok)
+
+	_, ok = growthRate([]usageSample{{at: start, used: 1}, {at: start, used: 2}})
+	require.False(t, ok, "samples span no time")
+
+	// Noisy samples around 2 bytes per second.
+	rate, ok := growthRate([]usageSample{
+		{at: start, used: 100},
+		{at: start.Add(time.Second), used: 103},
+		{at: start.Add(2 * time.Second), used: 103},
+		{at: start.Add(3 * time.Second), used: 106},
+	})
+	require.True(t, ok)
+	require.InDelta(t, 1.8, rate, 0.001)
+}
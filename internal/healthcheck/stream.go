@@ -0,0 +1,150 @@
+package healthcheck
+
+import (
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/keepalive"
+)
+
+// The gRPC service is described by hand and its messages are encoded as JSON,
+// so the sidecar protocol does not require generated protobuf code.
+const (
+	streamServiceName = "healthcheck.DiskUsage"
+	watchMethod       = "/" + streamServiceName + "/Watch"
+	jsonCodecName     = "json"
+)
+
+// WatchRequest starts a stream of disk usage updates.
+type WatchRequest struct{}
+
+// DiskUsageUpdate is sent to the operator whenever disk usage changes.
+// Sidecars which predate DiskReport only send Disks.
+type DiskUsageUpdate = DiskReport
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Name() string                               { return jsonCodecName }
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+type diskUsageWatcher interface {
+	Watch(req *WatchRequest, stream grpc.ServerStream) error
+}
+
+var streamServiceDesc = grpc.ServiceDesc{
+	ServiceName: streamServiceName,
+	HandlerType: (*diskUsageWatcher)(nil),
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Watch",
+			Handler:       watchHandler,
+			ServerStreams: true,
+		},
+	},
+}
+
+func watchHandler(srv interface{}, stream grpc.ServerStream) error {
+	req := new(WatchRequest)
+	if err := stream.RecvMsg(req); err != nil {
+		return err
+	}
+	return srv.(diskUsageWatcher).Watch(req, stream)
+}
+
+// StreamHeartbeat is the maximum time between updates of a disk usage stream.
+const StreamHeartbeat = time.Minute
+
+// ServerKeepalive returns the gRPC server options permitting the keepalive pings of the operator's streaming client.
+func ServerKeepalive() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{MinTime: streamKeepaliveTime / 2}),
+	}
+}
+
+// StreamServer streams disk usage to the operator over gRPC.
+type StreamServer struct {
+	volumes  []Volume
+	nodeName string
+	interval time.Duration
+	stat     func(volumes []Volume) ([]DiskUsageResponse, error)
+}
+
+// NewStreamServer returns a StreamServer which checks disk usage of volumes every interval.
+// NodeName is the node the sidecar runs on.
+func NewStreamServer(volumes []Volume, nodeName string, interval time.Duration) *StreamServer {
+	return &StreamServer{
+		volumes:  volumes,
+		nodeName: nodeName,
+		interval: interval,
+		stat:     DiskStats,
+	}
+}
+
+// WithSampler returns a copy of the StreamServer which streams disk usage including the trend computed by sampler.
+func (s StreamServer) WithSampler(sampler *Sampler) *StreamServer {
+	s.stat = func([]Volume) ([]DiskUsageResponse, error) { return sampler.DiskStats() }
+	return &s
+}
+
+// WithResizeRequests returns a copy of the StreamServer which streams the pending resize requests with disk usage.
+func (s StreamServer) WithResizeRequests(requests *ResizeRequests) *StreamServer {
+	stat := s.stat
+	s.stat = func(volumes []Volume) ([]DiskUsageResponse, error) {
+		resps, err := stat(volumes)
+		return requests.Attach(resps), err
+	}
+	return &s
+}
+
+// WithUsageProber returns a copy of the StreamServer which streams the logical usage reported by prober with disk usage.
+func (s StreamServer) WithUsageProber(prober *UsageProber) *StreamServer {
+	stat := s.stat
+	s.stat = func(volumes []Volume) ([]DiskUsageResponse, error) {
+		resps, err := stat(volumes)
+		return prober.Merge(resps), err
+	}
+	return &s
+}
+
+// Register registers the disk usage service with the gRPC server.
+func (s *StreamServer) Register(srv *grpc.Server) {
+	srv.RegisterService(&streamServiceDesc, s)
+}
+
+// Watch sends the current disk usage, then an update every time disk usage changes.
+// Unchanged disk usage is sent again every StreamHeartbeat, so the operator can tell a stalled stream from
+// unchanged usage.
+// Statfs errors are reported in the DiskUsageResponse and do not end the stream.
+func (s *StreamServer) Watch(_ *WatchRequest, stream grpc.ServerStream) error {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	var (
+		last     []DiskUsageResponse
+		lastSent time.Time
+	)
+	for {
+		disks, _ := s.stat(s.volumes)
+		if last == nil || !reflect.DeepEqual(disks, last) || time.Since(lastSent) >= StreamHeartbeat {
+			report := NewDiskReport(s.nodeName, disks)
+			if err := stream.SendMsg(&report); err != nil {
+				return err
+			}
+			last, lastSent = disks, time.Now()
+		}
+
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package healthcheck
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/kube"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// streamIdleTimeout closes streams to sidecars which have not been queried for a while, e.g. deleted pods.
+	streamIdleTimeout = 10 * time.Minute
+	// fallbackTTL is how long to use HTTP for a sidecar which does not serve gRPC before trying gRPC again.
+	fallbackTTL = 10 * time.Minute
+	// streamMaxAge is the maximum age of the last update of a stream. Sidecars send an update at least every
+	// StreamHeartbeat, so older updates mean the stream stalled.
+	streamMaxAge = 3 * StreamHeartbeat
+	// streamKeepaliveTime is how often idle connections to sidecars are pinged.
+	streamKeepaliveTime = 30 * time.Second
+)
+
+// errStaleStream means the last update of a stream is older than streamMaxAge.
+var errStaleStream = errors.New("stale disk usage stream")
+
+// clientKeepalive detects connections to sidecars which silently went away.
+var clientKeepalive = grpc.WithKeepaliveParams(keepalive.ClientParameters{
+	Time:    streamKeepaliveTime,
+	Timeout: 10 * time.Second,
+})
+
+// streamPool keeps a long-lived stream to each sidecar.
+type streamPool struct {
+	mu       sync.Mutex
+	dialOpts []grpc.DialOption
+	streams  map[string]*diskStream
+	fallback map[string]time.Time
+	now      func() time.Time
+}
+
+func newStreamPool(opts ...grpc.DialOption) *streamPool {
+	return &streamPool{
+		dialOpts: opts,
+		streams:  make(map[string]*diskStream),
+		fallback: make(map[string]time.Time),
+		now:      time.Now,
+	}
+}
+
+// Report returns the last update received from the sidecar on host, opening a stream if needed.
+// Blocks until the first update if the stream was just opened.
+// If not empty, token authenticates new streams. If not nil, creds secure new streams.
+func (p *streamPool) Report(ctx context.Context, host string, token string, creds credentials.TransportCredentials) (DiskReport, error) {
+	target := net.JoinHostPort(host, strconv.Itoa(GRPCPort))
+	s := p.stream(target, token, creds)
+
+	select {
+	case <-ctx.Done():
+		return DiskReport{}, ctx.Err()
+	case <-s.ready:
+	}
+
+	report, received, err := s.latest()
+	if err == nil && p.now().Sub(received) > streamMaxAge {
+		// The sidecar is queried over HTTP until the stream is reopened.
+		err = fmt.Errorf("%w: last update %s ago", errStaleStream, p.now().Sub(received).Round(time.Second))
+	}
+	if err != nil {
+		p.remove(target, s)
+		if code := status.Code(err); code == codes.Unimplemented || code == codes.Unavailable || errors.Is(err, errStaleStream) {
+			p.mu.Lock()
+			p.fallback[host] = p.now()
+			p.mu.Unlock()
+		}
+		// The stream is reopened on the next attempt.
+		return DiskReport{}, kube.TransientError(err)
+	}
+	return report, nil
+}
+
+// UseFallback returns true if the sidecar on host recently failed to serve gRPC.
+func (p *streamPool) UseFallback(host string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	since, ok := p.fallback[host]
+	if !ok {
+		return false
+	}
+	if p.now().Sub(since) > fallbackTTL {
+		delete(p.fallback, host)
+		return false
+	}
+	return true
+}
+
+// Close closes all streams.
+func (p *streamPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for target, s := range p.streams {
+		s.cancel()
+		delete(p.streams, target)
+	}
+}
+
+func (p *streamPool) stream(target string, token string, creds credentials.TransportCredentials) *diskStream {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	for t, s := range p.streams {
+		if now.Sub(s.lastUsed) > streamIdleTimeout {
+			s.cancel()
+			delete(p.streams, t)
+		}
+	}
+
+	s, ok := p.streams[target]
+	if !ok {
+		opts := p.dialOpts
+		if creds != nil {
+			// Overrides the default insecure credentials.
+			opts = append(opts[:len(opts):len(opts)], grpc.WithTransportCredentials(creds))
+		}
+		s = openDiskStream(target, token, opts, p.now)
+		p.streams[target] = s
+	}
+	s.lastUsed = now
+	return s
+}
+
+func (p *streamPool) remove(target string, s *diskStream) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.streams[target] == s {
+		s.cancel()
+		delete(p.streams, target)
+	}
+}
+
+// diskStream receives disk usage updates from a single sidecar in the background.
+type diskStream struct {
+	cancel   context.CancelFunc
+	ready    chan struct{}
+	once     sync.Once
+	lastUsed time.Time // guarded by streamPool.mu
+	now      func() time.Time
+
+	mu       sync.Mutex
+	report   DiskReport
+	received time.Time
+	err      error
+}
+
+func openDiskStream(target string, token string, opts []grpc.DialOption, now func() time.Time) *diskStream {
+	ctx, cancel := context.WithCancel(context.Background())
+	if token != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, authorizationHeader, "Bearer "+token)
+	}
+	s := &diskStream{cancel: cancel, ready: make(chan struct{}), now: now}
+	go s.run(ctx, target, opts)
+	return s
+}
+
+func (s *diskStream) run(ctx context.Context, target string, opts []grpc.DialOption) {
+	conn, err := grpc.DialContext(ctx, target, opts...)
+	if err != nil {
+		s.set(DiskReport{}, err)
+		return
+	}
+	defer conn.Close()
+
+	stream, err := conn.NewStream(ctx, &streamServiceDesc.Streams[0], watchMethod, grpc.CallContentSubtype(jsonCodecName))
+	if err != nil {
+		s.set(DiskReport{}, err)
+		return
+	}
+	if err = stream.SendMsg(&WatchRequest{}); err != nil {
+		s.set(DiskReport{}, err)
+		return
+	}
+	if err = stream.CloseSend(); err != nil {
+		s.set(DiskReport{}, err)
+		return
+	}
+
+	for {
+		var update DiskUsageUpdate
+		if err = stream.RecvMsg(&update); err != nil {
+			s.set(DiskReport{}, err)
+			return
+		}
+		s.set(update, nil)
+	}
+}
+
+func (s *diskStream) set(report DiskReport, err error) {
+	s.mu.Lock()
+	s.report, s.received, s.err = report, s.now(), err
+	s.mu.Unlock()
+	s.once.Do(func() { close(s.ready) })
+}
+
+// latest returns the last update and when it was received.
+func (s *diskStream) latest() (DiskReport, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.report, s.received, s.err
+}
@@ -0,0 +1,180 @@
+package healthcheck
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestStreamingClient_DiskUsage(t *testing.T) {
+	var (
+		ctx        = context.Background()
+		httpClient = &http.Client{}
+	)
+
+	const host = "http://10.1.1.1"
+
+	t.Run("happy path", func(t *testing.T) {
+		var (
+			mu   sync.Mutex
+			free uint64 = 10
+		)
+		srv := &StreamServer{
+			volumes:  PVCVolumes("test", "/"),
+			interval: time.Millisecond,
+			stat: func(volumes []Volume) ([]DiskUsageResponse, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				return []DiskUsageResponse{{Dir: "/test", PvcName: "test", AllBytes: 100, FreeBytes: free}}, nil
+			},
+		}
+		lis := serveStream(t, srv)
+
+		client := NewStreamingClient(httpClient, grpc.WithContextDialer(func(ctx context.Context, target string) (net.Conn, error) {
+			require.Equal(t, "10.1.1.1:1252", target)
+			return lis.DialContext(ctx)
+		}))
+		defer client.Close()
+		client.httpDo = func(req *http.Request) (*http.Response, error) {
+			panic("should not be called")
+		}
+
+		got, err := client.DiskUsage(ctx, host)
+
+		require.NoError(t, err)
+		require.Equal(t, []DiskUsageResponse{{Dir: "/test", PvcName: "test", AllBytes: 100, FreeBytes: 10}}, got)
+
+		mu.Lock()
+		free = 5
+		mu.Unlock()
+
+		require.Eventually(t, func() bool {
+			got, err = client.DiskUsage(ctx, host)
+			return err == nil && got[0].FreeBytes == 5
+		}, 5*time.Second, time.Millisecond)
+	})
+
+	t.Run("stream error", func(t *testing.T) {
+		srv := &StreamServer{
+			volumes:  PVCVolumes("test", "/"),
+			interval: time.Millisecond,
+			stat: func(volumes []Volume) ([]DiskUsageResponse, error) {
+				return []DiskUsageResponse{{PvcName: "test", Error: "boom"}}, errors.New("boom")
+			},
+		}
+		lis := serveStream(t, srv)
+
+		client := NewStreamingClient(httpClient, grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}))
+		defer client.Close()
+
+		_, err := client.DiskUsage(ctx, host)
+
+		require.Error(t, err)
+		require.EqualError(t, err, "no disk usage data: pvc test: boom")
+	})
+
+	t.Run("stale stream falls back to http", func(t *testing.T) {
+		srv := &StreamServer{
+			volumes:  PVCVolumes("test", "/"),
+			interval: time.Hour,
+			stat: func(volumes []Volume) ([]DiskUsageResponse, error) {
+				return []DiskUsageResponse{{PvcName: "test", AllBytes: 100, FreeBytes: 10}}, nil
+			},
+		}
+		lis := serveStream(t, srv)
+
+		client := NewStreamingClient(httpClient, grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}))
+		defer client.Close()
+		var (
+			mu  sync.Mutex
+			now = time.Now()
+		)
+		client.streams.now = func() time.Time {
+			mu.Lock()
+			defer mu.Unlock()
+			return now
+		}
+
+		var httpCalls int
+		client.httpDo = func(req *http.Request) (*http.Response, error) {
+			httpCalls++
+			b, err := json.Marshal([]DiskUsageResponse{{PvcName: "test", AllBytes: 100, FreeBytes: 50}})
+			if err != nil {
+				panic(err)
+			}
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(b))}, nil
+		}
+
+		got, err := client.DiskUsage(ctx, host)
+
+		require.NoError(t, err)
+		require.EqualValues(t, 10, got[0].FreeBytes)
+		require.Zero(t, httpCalls)
+
+		mu.Lock()
+		now = now.Add(streamMaxAge + time.Second)
+		mu.Unlock()
+
+		got, err = client.DiskUsage(ctx, host)
+
+		require.NoError(t, err)
+		require.EqualValues(t, 50, got[0].FreeBytes)
+		require.Equal(t, 1, httpCalls)
+		require.True(t, client.streams.UseFallback("10.1.1.1"))
+	})
+
+	t.Run("falls back to http", func(t *testing.T) {
+		client := NewStreamingClient(httpClient, grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return nil, errors.New("connection refused")
+		}))
+		defer client.Close()
+
+		var httpCalls int
+		client.httpDo = func(req *http.Request) (*http.Response, error) {
+			httpCalls++
+			require.Equal(t, "http://10.1.1.1:1251/disk", req.URL.String())
+			b, err := json.Marshal([]DiskUsageResponse{{PvcName: "test", AllBytes: 100, FreeBytes: 10}})
+			if err != nil {
+				panic(err)
+			}
+			return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(b))}, nil
+		}
+
+		_, err := client.DiskUsage(ctx, host)
+
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "grpc stream:")
+		require.Zero(t, httpCalls)
+
+		got, err := client.DiskUsage(ctx, host)
+
+		require.NoError(t, err)
+		require.Len(t, got, 1)
+		require.Equal(t, 1, httpCalls)
+	})
+}
+
+func serveStream(t *testing.T, srv *StreamServer) *bufconn.Listener {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	grpcSrv := grpc.NewServer()
+	srv.Register(grpcSrv)
+	go func() { _ = grpcSrv.Serve(lis) }()
+	t.Cleanup(grpcSrv.Stop)
+	return lis
+}
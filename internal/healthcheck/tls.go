@@ -0,0 +1,79 @@
+package healthcheck
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// ServerName is the DNS name in the serving certificate of every sidecar in namespace.
+// Sidecars are queried by pod IP, so the client verifies this name instead of the host.
+func ServerName(namespace string) string {
+	return fmt.Sprintf("diskhealthcheck.%s.pvc-autoscaler-operator", namespace)
+}
+
+// ServerTLSConfig returns a TLS config which serves the certificate in certFile and keyFile.
+// The files are read on every handshake so a renewed certificate is picked up without a restart.
+func ServerTLSConfig(certFile, keyFile string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+			if err != nil {
+				return nil, fmt.Errorf("load certificate: %w", err)
+			}
+			return &cert, nil
+		},
+	}
+}
+
+// clientTLSConfig returns a TLS config which trusts the CA bundle in caFile and verifies the
+// serving certificate of sidecars in namespace.
+func clientTLSConfig(caFile string, namespace string) (*tls.Config, error) {
+	if namespace == "" {
+		return nil, errors.New("tls: missing pod namespace")
+	}
+	caPEM, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("read ca: %w", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificates in %s", caFile)
+	}
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		RootCAs:    roots,
+		ServerName: ServerName(namespace),
+	}, nil
+}
+
+// tlsTransport returns a transport which verifies sidecars against the CA bundle in caFile.
+// The namespace of the pod is taken from the request context, see WithNamespace.
+func tlsTransport(caFile string) *http.Transport {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialTLSContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		cfg, err := clientTLSConfig(caFile, namespaceFrom(ctx))
+		if err != nil {
+			return nil, err
+		}
+		conn, err := dialer.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		tlsConn := tls.Client(conn, cfg)
+		if err = tlsConn.HandshakeContext(ctx); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+		return tlsConn, nil
+	}
+	return transport
+}
@@ -0,0 +1,183 @@
+package healthcheck
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// testPKI writes a CA bundle and a serving certificate for namespace to a temp dir.
+func testPKI(t *testing.T, namespace string) (caFile, certFile, keyFile string) {
+	t.Helper()
+	dir := t.TempDir()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, caKey.Public(), caKey)
+	require.NoError(t, err)
+	caCert, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		DNSNames:     []string{ServerName(namespace)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, key.Public(), caKey)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	caFile = filepath.Join(dir, "ca.crt")
+	certFile = filepath.Join(dir, "tls.crt")
+	keyFile = filepath.Join(dir, "tls.key")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}), 0o600))
+	require.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return caFile, certFile, keyFile
+}
+
+func TestTLSTransport(t *testing.T) {
+	ctx := context.Background()
+	caFile, certFile, keyFile := testPKI(t, "ns")
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	srv.TLS = ServerTLSConfig(certFile, keyFile)
+	srv.StartTLS()
+	defer srv.Close()
+
+	get := func(ctx context.Context) error {
+		httpClient := &http.Client{Transport: tlsTransport(caFile)}
+		req, err := http.NewRequestWithContext(ctx, "GET", srv.URL, nil)
+		require.NoError(t, err)
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		return resp.Body.Close()
+	}
+
+	t.Run("happy path", func(t *testing.T) {
+		require.NoError(t, get(WithNamespace(ctx, "ns")))
+	})
+
+	t.Run("wrong namespace", func(t *testing.T) {
+		err := get(WithNamespace(ctx, "other"))
+
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "certificate is valid for diskhealthcheck.ns.pvc-autoscaler-operator")
+	})
+
+	t.Run("missing namespace", func(t *testing.T) {
+		err := get(ctx)
+
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "tls: missing pod namespace")
+	})
+
+	t.Run("untrusted ca", func(t *testing.T) {
+		otherCA, _, _ := testPKI(t, "ns")
+		httpClient := &http.Client{Transport: tlsTransport(otherCA)}
+		req, err := http.NewRequestWithContext(WithNamespace(ctx, "ns"), "GET", srv.URL, nil)
+		require.NoError(t, err)
+
+		_, err = httpClient.Do(req)
+
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "certificate signed by unknown authority")
+	})
+}
+
+func TestClient_WithTLS(t *testing.T) {
+	var (
+		ctx        = context.Background()
+		httpClient = &http.Client{}
+	)
+	caFile, certFile, keyFile := testPKI(t, "ns")
+
+	t.Run("https", func(t *testing.T) {
+		client := NewClient(httpClient).WithTLS(caFile)
+		require.NotSame(t, httpClient, client.httpClient)
+		require.Nil(t, httpClient.Transport)
+
+		client.httpDo = func(req *http.Request) (*http.Response, error) {
+			require.Equal(t, "https://10.1.1.1:1251/disk", req.URL.String())
+			return nil, context.Canceled
+		}
+
+		_, _ = client.DiskUsage(WithNamespace(ctx, "ns"), "http://10.1.1.1")
+	})
+
+	t.Run("grpc", func(t *testing.T) {
+		srv := &StreamServer{
+			volumes:  PVCVolumes("test", "/"),
+			interval: time.Millisecond,
+			stat: func(volumes []Volume) ([]DiskUsageResponse, error) {
+				return []DiskUsageResponse{{PvcName: "test", AllBytes: 100, FreeBytes: 10}}, nil
+			},
+		}
+		lis := bufconn.Listen(1 << 20)
+		grpcSrv := grpc.NewServer(grpc.Creds(credentials.NewTLS(ServerTLSConfig(certFile, keyFile))))
+		srv.Register(grpcSrv)
+		go func() { _ = grpcSrv.Serve(lis) }()
+		t.Cleanup(grpcSrv.Stop)
+
+		client := NewStreamingClient(httpClient, grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		})).WithTLS(caFile)
+		defer client.Close()
+
+		got, err := client.DiskUsage(WithNamespace(ctx, "ns"), "http://10.1.1.1")
+
+		require.NoError(t, err)
+		require.Len(t, got, 1)
+	})
+}
+
+func TestServerTLSConfig(t *testing.T) {
+	_, certFile, keyFile := testPKI(t, "ns")
+
+	cfg := ServerTLSConfig(certFile, keyFile)
+	cert, err := cfg.GetCertificate(&tls.ClientHelloInfo{})
+
+	require.NoError(t, err)
+	require.NotNil(t, cert)
+
+	_, err = ServerTLSConfig(certFile, "missing").GetCertificate(&tls.ClientHelloInfo{})
+
+	require.Error(t, err)
+}
@@ -0,0 +1,197 @@
+package healthcheck
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/samber/lo"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// DefaultTopDepth and DefaultTopEntries are used if the request does not set depth or n.
+	DefaultTopDepth   = 2
+	DefaultTopEntries = 20
+
+	maxTopDepth   = 5
+	maxTopEntries = 100
+	// topTimeout bounds a walk of a large volume; the partial result is returned.
+	topTimeout = 20 * time.Second
+)
+
+// DirUsage is the disk usage of a directory including its subdirectories.
+type DirUsage struct {
+	// Path is relative to the root of the PVC.
+	Path  string `json:"path"`
+	Bytes uint64 `json:"bytes"`
+}
+
+// TopResponse lists the largest directories of a PVC.
+type TopResponse struct {
+	PvcName string     `json:"pvc_name"`
+	Dirs    []DirUsage `json:"dirs"`
+	// Partial is true if the walk timed out or some directories could not be read.
+	Partial bool   `json:"partial,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Top returns a handler which responds with the largest directories of one of volumes in JSON,
+// e.g. /disk/top?pvc=data&depth=2&n=20.
+// Walking a volume is expensive, so the handler serves at most one request per interval and responds with
+// 429 Too Many Requests otherwise.
+func Top(volumes []Volume, interval time.Duration) http.Handler {
+	return &topHandler{
+		volumes:  volumes,
+		limiter:  rate.NewLimiter(rate.Every(interval), 1),
+		interval: interval,
+	}
+}
+
+type topHandler struct {
+	volumes  []Volume
+	limiter  *rate.Limiter
+	interval time.Duration
+	mu       sync.Mutex
+}
+
+func (h *topHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	pvc := query.Get("pvc")
+	vol, ok := lo.Find(h.volumes, func(vol Volume) bool { return vol.ClaimName == pvc })
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		mustJSONEncode(TopResponse{PvcName: pvc, Error: "unknown pvc"}, w)
+		return
+	}
+	depth, err := intParam(query.Get("depth"), DefaultTopDepth, maxTopDepth)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		mustJSONEncode(TopResponse{PvcName: pvc, Error: "depth: " + err.Error()}, w)
+		return
+	}
+	n, err := intParam(query.Get("n"), DefaultTopEntries, maxTopEntries)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		mustJSONEncode(TopResponse{PvcName: pvc, Error: "n: " + err.Error()}, w)
+		return
+	}
+
+	// Only one walk at a time, even if it takes longer than interval.
+	if !h.mu.TryLock() {
+		h.tooManyRequests(w, pvc)
+		return
+	}
+	defer h.mu.Unlock()
+	if !h.limiter.Allow() {
+		h.tooManyRequests(w, pvc)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), topTimeout)
+	defer cancel()
+
+	dirs, partial, err := TopDirs(ctx, vol.MountPath, depth, n)
+	resp := TopResponse{PvcName: pvc, Dirs: dirs, Partial: partial}
+	if err != nil {
+		resp.Error = err.Error()
+		w.WriteHeader(http.StatusInternalServerError)
+		mustJSONEncode(resp, w)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	mustJSONEncode(resp, w)
+}
+
+func (h *topHandler) tooManyRequests(w http.ResponseWriter, pvc string) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(h.interval.Seconds())))
+	w.WriteHeader(http.StatusTooManyRequests)
+	mustJSONEncode(TopResponse{PvcName: pvc, Error: "rate limited"}, w)
+}
+
+func intParam(s string, def, upper int) (int, error) {
+	if s == "" {
+		return def, nil
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+	if v < 1 || v > upper {
+		return 0, errors.New("must be between 1 and " + strconv.Itoa(upper))
+	}
+	return v, nil
+}
+
+// TopDirs walks root and returns the n largest directories at most depth levels below root, largest first.
+// Sizes are the disk space allocated to files, like du. Symlinks are not followed.
+// If ctx is done or some directories cannot be read, partial is true and the usage found so far is returned.
+func TopDirs(ctx context.Context, root string, depth, n int) (dirs []DirUsage, partial bool, err error) {
+	sizes := make(map[string]uint64)
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			partial = true
+			return nil
+		}
+		if ctx.Err() != nil {
+			partial = true
+			return filepath.SkipAll
+		}
+		if d.IsDir() || !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			partial = true
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		size := allocated(info)
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		// The last part is the file itself.
+		for i := 1; i < len(parts) && i <= depth; i++ {
+			sizes[strings.Join(parts[:i], "/")] += size
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, partial, err
+	}
+
+	dirs = make([]DirUsage, 0, len(sizes))
+	for path, size := range sizes {
+		dirs = append(dirs, DirUsage{Path: path, Bytes: size})
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		if dirs[i].Bytes != dirs[j].Bytes {
+			return dirs[i].Bytes > dirs[j].Bytes
+		}
+		return dirs[i].Path < dirs[j].Path
+	})
+	if len(dirs) > n {
+		dirs = dirs[:n]
+	}
+	return dirs, partial, nil
+}
+
+// allocated returns the disk space allocated to a file, falling back to its size.
+func allocated(info fs.FileInfo) uint64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Blocks) * 512
+	}
+	return uint64(info.Size())
+}
@@ -0,0 +1,140 @@
+package healthcheck
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, path string, size int) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, make([]byte, size), 0o600))
+}
+
+func TestTopDirs(t *testing.T) {
+	ctx := context.Background()
+
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "data", "big", "a"), 256<<10)
+	writeFile(t, filepath.Join(root, "data", "big", "deeper", "b"), 256<<10)
+	writeFile(t, filepath.Join(root, "data", "small", "c"), 16<<10)
+	writeFile(t, filepath.Join(root, "logs", "d"), 64<<10)
+	writeFile(t, filepath.Join(root, "e"), 1<<20)
+
+	t.Run("happy path", func(t *testing.T) {
+		dirs, partial, err := TopDirs(ctx, root, 2, 10)
+
+		require.NoError(t, err)
+		require.False(t, partial)
+		require.Equal(t, []string{"data", "data/big", "logs", "data/small"}, lo.Map(dirs, func(d DirUsage, _ int) string { return d.Path }))
+		require.GreaterOrEqual(t, dirs[0].Bytes, uint64(528<<10))
+		require.Equal(t, dirs[0].Bytes, dirs[1].Bytes+dirs[3].Bytes)
+	})
+
+	t.Run("depth and n", func(t *testing.T) {
+		dirs, _, err := TopDirs(ctx, root, 1, 1)
+
+		require.NoError(t, err)
+		require.Len(t, dirs, 1)
+		require.Equal(t, "data", dirs[0].Path)
+	})
+
+	t.Run("missing root", func(t *testing.T) {
+		_, _, err := TopDirs(ctx, filepath.Join(root, "missing"), 2, 10)
+
+		require.Error(t, err)
+	})
+
+	t.Run("context done", func(t *testing.T) {
+		cctx, cancel := context.WithCancel(ctx)
+		cancel()
+
+		dirs, partial, err := TopDirs(cctx, root, 2, 10)
+
+		require.NoError(t, err)
+		require.True(t, partial)
+		require.Empty(t, dirs)
+	})
+}
+
+func TestTop(t *testing.T) {
+	mount := t.TempDir()
+	writeFile(t, filepath.Join(mount, "pvc-a", "data", "f"), 4<<10)
+
+	get := func(t *testing.T, handler http.Handler, target string) (int, TopResponse) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		var resp TopResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return w.Code, resp
+	}
+
+	t.Run("happy path", func(t *testing.T) {
+		handler := Top(PVCVolumes("pvc-a,pvc-b", mount), time.Hour)
+
+		code, resp := get(t, handler, "/disk/top?pvc=pvc-a")
+
+		require.Equal(t, 200, code)
+		require.Equal(t, "pvc-a", resp.PvcName)
+		require.Len(t, resp.Dirs, 1)
+		require.Equal(t, "data", resp.Dirs[0].Path)
+		require.Empty(t, resp.Error)
+	})
+
+	t.Run("rate limited", func(t *testing.T) {
+		handler := Top(PVCVolumes("pvc-a", mount), time.Hour)
+
+		code, _ := get(t, handler, "/disk/top?pvc=pvc-a")
+		require.Equal(t, 200, code)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/disk/top?pvc=pvc-a", nil))
+
+		require.Equal(t, 429, w.Code)
+		require.Equal(t, "3600", w.Header().Get("Retry-After"))
+	})
+
+	t.Run("unknown pvc", func(t *testing.T) {
+		handler := Top(PVCVolumes("pvc-a", mount), time.Hour)
+
+		code, resp := get(t, handler, "/disk/top?pvc=../etc")
+
+		require.Equal(t, 404, code)
+		require.Equal(t, "unknown pvc", resp.Error)
+	})
+
+	t.Run("invalid params", func(t *testing.T) {
+		handler := Top(PVCVolumes("pvc-a", mount), time.Hour)
+
+		code, resp := get(t, handler, "/disk/top?pvc=pvc-a&depth=10")
+		require.Equal(t, 400, code)
+		require.Equal(t, "depth: must be between 1 and 5", resp.Error)
+
+		code, resp = get(t, handler, "/disk/top?pvc=pvc-a&n=abc")
+		require.Equal(t, 400, code)
+		require.Contains(t, resp.Error, "n: ")
+
+		// Invalid requests do not use up the rate limit.
+		code, _ = get(t, handler, "/disk/top?pvc=pvc-a")
+		require.Equal(t, 200, code)
+	})
+
+	t.Run("missing volume", func(t *testing.T) {
+		handler := Top(PVCVolumes("pvc-b", mount), time.Hour)
+
+		code, resp := get(t, handler, "/disk/top?pvc=pvc-b")
+
+		require.Equal(t, 500, code)
+		require.Contains(t, resp.Error, "no such file or directory")
+	})
+}
@@ -0,0 +1,211 @@
+package healthcheck
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+const (
+	defaultUsageProbeTimeout = 5 * time.Second
+	// usageProbeInterval caches probe results, since disk usage is read by every /disk request and gRPC check.
+	usageProbeInterval = 15 * time.Second
+	// maxUsageProbeOutput bounds the output read from the probe.
+	maxUsageProbeOutput = 1 << 20
+)
+
+// UsageProbe reports the logical usage of volumes as seen by the application, e.g. a database which preallocates
+// its files and is mostly free internally although statfs reports the volume nearly full.
+// Exactly one of Command and URL must be set.
+type UsageProbe struct {
+	// Command is run in the sidecar container and must print a JSON array of LogicalUsage to stdout.
+	Command []string `json:"command,omitempty"`
+	// URL is requested with GET and must respond with a JSON array of LogicalUsage,
+	// e.g. http://127.0.0.1:8080/usage for an application container in the same pod.
+	URL string `json:"url,omitempty"`
+	// TimeoutSeconds bounds a single probe. Defaults to 5.
+	TimeoutSeconds int `json:"timeoutSeconds,omitempty"`
+}
+
+// Validate returns an error if the probe is misconfigured.
+func (p UsageProbe) Validate() error {
+	switch {
+	case len(p.Command) > 0 && p.URL != "":
+		return errors.New("usageProbe: only one of command and url may be set")
+	case len(p.Command) == 0 && p.URL == "":
+		return errors.New("usageProbe: command or url is required")
+	case p.TimeoutSeconds < 0:
+		return errors.New("usageProbe: timeoutSeconds must not be negative")
+	}
+	if p.URL != "" {
+		u, err := url.Parse(p.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("usageProbe: url must be an absolute http(s) url: %q", p.URL)
+		}
+	}
+	return nil
+}
+
+// LogicalUsage is the usage of a PVC reported by a UsageProbe.
+type LogicalUsage struct {
+	PvcName   string `json:"pvc_name"`
+	UsedBytes uint64 `json:"used_bytes"`
+}
+
+// UsageProber runs a UsageProbe and merges its results into disk statistics.
+type UsageProber struct {
+	probe   UsageProbe
+	timeout time.Duration
+	run     func(ctx context.Context) ([]byte, error)
+	now     func() time.Time
+
+	mu      sync.Mutex
+	last    map[string]uint64
+	lastErr error
+	lastAt  time.Time
+	probing bool
+}
+
+// NewUsageProber returns a UsageProber for probe, which must be valid.
+func NewUsageProber(probe UsageProbe) *UsageProber {
+	p := &UsageProber{
+		probe:   probe,
+		timeout: defaultUsageProbeTimeout,
+		now:     time.Now,
+	}
+	if probe.TimeoutSeconds > 0 {
+		p.timeout = time.Duration(probe.TimeoutSeconds) * time.Second
+	}
+	p.run = p.runURL
+	if len(probe.Command) > 0 {
+		p.run = p.runCommand
+	}
+	return p
+}
+
+// Stat returns stat with the logical usage of every PVC reported by the probe.
+// If the probe fails, the error is reported in each DiskUsageResponse and the statfs statistics are kept.
+// Safe to call on a nil UsageProber.
+func (p *UsageProber) Stat(stat func() ([]DiskUsageResponse, error)) func() ([]DiskUsageResponse, error) {
+	return func() ([]DiskUsageResponse, error) {
+		resps, err := stat()
+		return p.Merge(resps), err
+	}
+}
+
+// Merge sets the logical usage reported by the probe on resps.
+func (p *UsageProber) Merge(resps []DiskUsageResponse) []DiskUsageResponse {
+	if p == nil {
+		return resps
+	}
+	usage, err := p.usage()
+	for i := range resps {
+		resp := &resps[i]
+		if resp.Error != "" {
+			continue
+		}
+		if err != nil {
+			resp.LogicalError = err.Error()
+			continue
+		}
+		if used, ok := usage[resp.PvcName]; ok {
+			resp.LogicalUsedBytes = &used
+		}
+	}
+	return resps
+}
+
+// usage returns the last probe result, probing again if it is older than usageProbeInterval.
+// The probe runs without holding the lock, so concurrent callers get the last result instead of waiting for it.
+func (p *UsageProber) usage() (map[string]uint64, error) {
+	p.mu.Lock()
+	if p.probing || (!p.lastAt.IsZero() && p.now().Sub(p.lastAt) < usageProbeInterval) {
+		last, lastErr := p.last, p.lastErr
+		p.mu.Unlock()
+		return last, lastErr
+	}
+	p.probing = true
+	p.mu.Unlock()
+
+	last, err := p.probeOnce()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.last, p.lastErr, p.lastAt, p.probing = last, err, p.now(), false
+	return last, err
+}
+
+func (p *UsageProber) probeOnce() (map[string]uint64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	out, err := p.run(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("usage probe: %w", err)
+	}
+	var usage []LogicalUsage
+	if err = json.Unmarshal(out, &usage); err != nil {
+		return nil, fmt.Errorf("usage probe: malformed json: %w", err)
+	}
+	last := make(map[string]uint64, len(usage))
+	for _, item := range usage {
+		last[item.PvcName] = item.UsedBytes
+	}
+	return last, nil
+}
+
+func (p *UsageProber) runCommand(ctx context.Context) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.probe.Command[0], p.probe.Command[1:]...)
+	cmd.Stdout = &limitedBuffer{buf: &stdout, limit: maxUsageProbeOutput}
+	cmd.Stderr = &limitedBuffer{buf: &stderr, limit: 1 << 10}
+	if err := cmd.Run(); err != nil {
+		if stderr.Len() > 0 {
+			return nil, fmt.Errorf("%w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+		}
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
+
+func (p *UsageProber) runURL(ctx context.Context) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", p.probe.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxUsageProbeOutput))
+}
+
+// limitedBuffer discards writes beyond limit, so a chatty probe cannot exhaust the sidecar's memory.
+type limitedBuffer struct {
+	buf   *bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.buf.Len(); room > 0 {
+		if len(p) > room {
+			b.buf.Write(p[:room])
+		} else {
+			b.buf.Write(p)
+		}
+	}
+	return len(p), nil
+}
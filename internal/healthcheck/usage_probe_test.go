@@ -0,0 +1,182 @@
+package healthcheck
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsageProbe_Validate(t *testing.T) {
+	require.NoError(t, UsageProbe{URL: "http://127.0.0.1:8080/usage"}.Validate())
+	require.NoError(t, UsageProbe{Command: []string{"/bin/usage"}}.Validate())
+
+	for _, tt := range []struct {
+		Name  string
+		Probe UsageProbe
+		Err   string
+	}{
+		{"empty", UsageProbe{}, "command or url is required"},
+		{"both", UsageProbe{URL: "http://localhost", Command: []string{"usage"}}, "only one of"},
+		{"relative url", UsageProbe{URL: "/usage"}, "absolute http(s) url"},
+		{"other scheme", UsageProbe{URL: "file:///usage"}, "absolute http(s) url"},
+		{"negative timeout", UsageProbe{URL: "http://localhost", TimeoutSeconds: -1}, "timeoutSeconds"},
+	} {
+		t.Run(tt.Name, func(t *testing.T) {
+			err := tt.Probe.Validate()
+
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tt.Err)
+		})
+	}
+}
+
+func TestUsageProber(t *testing.T) {
+	stat := func() ([]DiskUsageResponse, error) {
+		return []DiskUsageResponse{
+			{PvcName: "data", AllBytes: 1000, FreeBytes: 10},
+			{PvcName: "logs", AllBytes: 1000, FreeBytes: 500},
+			{PvcName: "broken", Error: "boom"},
+		}, nil
+	}
+
+	t.Run("url", func(t *testing.T) {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`[{"pvc_name":"data","used_bytes":200},{"pvc_name":"broken","used_bytes":1}]`))
+		}))
+		defer srv.Close()
+
+		got, err := NewUsageProber(UsageProbe{URL: srv.URL}).Stat(stat)()
+
+		require.NoError(t, err)
+		require.Equal(t, lo.ToPtr(uint64(200)), got[0].LogicalUsedBytes)
+		require.Nil(t, got[1].LogicalUsedBytes, "not reported by the probe")
+		require.Nil(t, got[2].LogicalUsedBytes, "statfs failed")
+	})
+
+	t.Run("command", func(t *testing.T) {
+		prober := NewUsageProber(UsageProbe{Command: []string{"sh", "-c", `echo '[{"pvc_name":"logs","used_bytes":300}]'`}})
+
+		got, err := prober.Stat(stat)()
+
+		require.NoError(t, err)
+		require.Equal(t, lo.ToPtr(uint64(300)), got[1].LogicalUsedBytes)
+	})
+
+	t.Run("command error", func(t *testing.T) {
+		prober := NewUsageProber(UsageProbe{Command: []string{"sh", "-c", "echo oops >&2; exit 3"}})
+
+		got, err := prober.Stat(stat)()
+
+		require.NoError(t, err)
+		require.Equal(t, "usage probe: exit status 3: oops", got[0].LogicalError)
+		require.Nil(t, got[0].LogicalUsedBytes)
+		require.Empty(t, got[2].LogicalError)
+	})
+
+	t.Run("zero usage", func(t *testing.T) {
+		prober := NewUsageProber(UsageProbe{URL: "http://127.0.0.1"})
+		prober.run = func(ctx context.Context) ([]byte, error) {
+			return []byte(`[{"pvc_name":"data","used_bytes":0}]`), nil
+		}
+
+		got, err := prober.Stat(stat)()
+
+		require.NoError(t, err)
+		require.Equal(t, lo.ToPtr(uint64(0)), got[0].LogicalUsedBytes)
+		require.Nil(t, got[1].LogicalUsedBytes)
+	})
+
+	t.Run("serves last result while probing", func(t *testing.T) {
+		var (
+			now     = time.Now()
+			started = make(chan struct{})
+			release = make(chan struct{})
+			calls   int
+		)
+		prober := NewUsageProber(UsageProbe{URL: "http://127.0.0.1"})
+		prober.now = func() time.Time { return now }
+		prober.run = func(ctx context.Context) ([]byte, error) {
+			calls++
+			if calls > 1 {
+				close(started)
+				<-release
+				return []byte(`[{"pvc_name":"data","used_bytes":300}]`), nil
+			}
+			return []byte(`[{"pvc_name":"data","used_bytes":200}]`), nil
+		}
+		_, err := prober.Stat(stat)()
+		require.NoError(t, err)
+
+		now = now.Add(usageProbeInterval)
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, _ := prober.Stat(stat)()
+			require.Equal(t, lo.ToPtr(uint64(300)), got[0].LogicalUsedBytes)
+		}()
+		<-started
+
+		got, err := prober.Stat(stat)()
+
+		require.NoError(t, err)
+		require.Equal(t, lo.ToPtr(uint64(200)), got[0].LogicalUsedBytes)
+		close(release)
+		wg.Wait()
+		require.Equal(t, 2, calls)
+	})
+
+	t.Run("caches results", func(t *testing.T) {
+		var (
+			now   = time.Now()
+			calls int
+		)
+		prober := NewUsageProber(UsageProbe{URL: "http://127.0.0.1"})
+		prober.now = func() time.Time { return now }
+		prober.run = func(ctx context.Context) ([]byte, error) {
+			calls++
+			if calls > 1 {
+				return nil, errors.New("boom")
+			}
+			return []byte(`[{"pvc_name":"data","used_bytes":200}]`), nil
+		}
+
+		for i := 0; i < 3; i++ {
+			got, _ := prober.Stat(stat)()
+			require.Equal(t, lo.ToPtr(uint64(200)), got[0].LogicalUsedBytes)
+		}
+		require.Equal(t, 1, calls)
+
+		now = now.Add(usageProbeInterval)
+		got, _ := prober.Stat(stat)()
+
+		require.Equal(t, 2, calls)
+		require.Equal(t, "usage probe: boom", got[0].LogicalError)
+	})
+
+	t.Run("malformed json", func(t *testing.T) {
+		prober := NewUsageProber(UsageProbe{URL: "http://127.0.0.1"})
+		prober.run = func(ctx context.Context) ([]byte, error) { return []byte("{"), nil }
+
+		got, _ := prober.Stat(stat)()
+
+		require.True(t, strings.HasPrefix(got[0].LogicalError, "usage probe: malformed json"))
+	})
+
+	t.Run("nil", func(t *testing.T) {
+		var prober *UsageProber
+
+		got, err := prober.Stat(stat)()
+
+		require.NoError(t, err)
+		require.Len(t, got, 3)
+	})
+}
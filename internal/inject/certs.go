@@ -0,0 +1,159 @@
+package inject
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/healthcheck"
+)
+
+const (
+	// Keys of the CA in the Secret managed by the webhook cert rotator.
+	caCertKey = "ca.crt"
+	caKeyKey  = "ca.key"
+
+	certValidity = 365 * 24 * time.Hour
+	// certRenewBefore renews sidecar certificates well before they expire, because kubelet
+	// takes a while to update mounted Secrets.
+	certRenewBefore = 30 * 24 * time.Hour
+)
+
+// CertIssuer issues sidecar serving certificates signed by the operator's CA.
+type CertIssuer struct {
+	reader   client.Reader
+	caSecret client.ObjectKey
+	now      func() time.Time
+}
+
+// NewCertIssuer returns a CertIssuer using the CA in caSecret, which is kept up to date by the webhook
+// cert rotator. Reader should not be cached, since the operator does not cache Secrets.
+func NewCertIssuer(reader client.Reader, caSecret client.ObjectKey) *CertIssuer {
+	return &CertIssuer{
+		reader:   reader,
+		caSecret: caSecret,
+		now:      time.Now,
+	}
+}
+
+// Issue returns a PEM encoded certificate and key for sidecars in namespace.
+func (i *CertIssuer) Issue(ctx context.Context, namespace string) (certPEM, keyPEM []byte, err error) {
+	caCert, caKey, err := i.ca(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("generate key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, fmt.Errorf("generate serial: %w", err)
+	}
+	now := i.now()
+	name := healthcheck.ServerName(namespace)
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{name},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(certValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, caCert, key.Public(), caKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("marshal key: %w", err)
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// NeedsRenewal returns true unless certPEM is valid for sidecars in namespace, is signed by the current CA
+// and does not expire soon.
+func (i *CertIssuer) NeedsRenewal(ctx context.Context, namespace string, certPEM []byte) (bool, error) {
+	caCert, _, err := i.ca(ctx)
+	if err != nil {
+		return false, err
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return true, nil
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return true, nil
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(caCert)
+	_, err = cert.Verify(x509.VerifyOptions{
+		DNSName:     healthcheck.ServerName(namespace),
+		Roots:       roots,
+		CurrentTime: i.now().Add(certRenewBefore),
+		KeyUsages:   []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	return err != nil, nil
+}
+
+func (i *CertIssuer) ca(ctx context.Context) (*x509.Certificate, crypto.Signer, error) {
+	var secret corev1.Secret
+	if err := i.reader.Get(ctx, i.caSecret, &secret); err != nil {
+		return nil, nil, fmt.Errorf("get ca secret: %w", err)
+	}
+
+	certBlock, _ := pem.Decode(secret.Data[caCertKey])
+	if certBlock == nil {
+		return nil, nil, fmt.Errorf("ca secret: missing %s", caCertKey)
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse ca certificate: %w", err)
+	}
+
+	keyBlock, _ := pem.Decode(secret.Data[caKeyKey])
+	if keyBlock == nil {
+		return nil, nil, fmt.Errorf("ca secret: missing %s", caKeyKey)
+	}
+	key, err := parsePrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("parse ca key: %w", err)
+	}
+	return cert, key, nil
+}
+
+func parsePrivateKey(der []byte) (crypto.Signer, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("unsupported key type")
+	}
+	return signer, nil
+}
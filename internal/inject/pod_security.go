@@ -0,0 +1,99 @@
+package inject
+
+import (
+	"fmt"
+
+	"github.com/samber/lo"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// nonRootUID is the nonroot user of the distroless sidecar image.
+const nonRootUID int64 = 65532
+
+// Pod Security Standards levels, see https://kubernetes.io/docs/concepts/security/pod-security-standards/.
+const (
+	PodSecurityPrivileged = "privileged"
+	PodSecurityBaseline   = "baseline"
+	PodSecurityRestricted = "restricted"
+)
+
+// baselineCapabilities are the capabilities the baseline level allows adding.
+var baselineCapabilities = []corev1.Capability{
+	"AUDIT_WRITE", "CHOWN", "DAC_OVERRIDE", "FOWNER", "FSETID", "KILL", "MKNOD", "NET_BIND_SERVICE",
+	"SETFCAP", "SETGID", "SETPCAP", "SETUID", "SYS_CHROOT",
+}
+
+// securityContext returns the default security context of the sidecar, which complies with the restricted level.
+func securityContext() *corev1.SecurityContext {
+	return &corev1.SecurityContext{
+		RunAsNonRoot:             ptr(true),
+		RunAsUser:                ptr(nonRootUID),
+		RunAsGroup:               ptr(nonRootUID),
+		ReadOnlyRootFilesystem:   ptr(true),
+		AllowPrivilegeEscalation: ptr(false),
+		Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+		SeccompProfile:           &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+	}
+}
+
+// PodSecurityViolations returns why sidecar, injected into pod, does not comply with the Pod Security Standards level.
+// Unknown levels are treated as privileged. Only the sidecar is checked, not the pod's own containers.
+func PodSecurityViolations(level string, pod *corev1.Pod, sidecar corev1.Container) []string {
+	if level != PodSecurityBaseline && level != PodSecurityRestricted {
+		return nil
+	}
+
+	sc := lo.FromPtr(sidecar.SecurityContext)
+	podSC := lo.FromPtr(pod.Spec.SecurityContext)
+	var caps corev1.Capabilities
+	if sc.Capabilities != nil {
+		caps = *sc.Capabilities
+	}
+	seccomp := sc.SeccompProfile
+	if seccomp == nil {
+		seccomp = podSC.SeccompProfile
+	}
+
+	var violations []string
+	if lo.FromPtr(sc.Privileged) {
+		violations = append(violations, "privileged must not be true")
+	}
+	allowedCaps := baselineCapabilities
+	if level == PodSecurityRestricted {
+		allowedCaps = []corev1.Capability{"NET_BIND_SERVICE"}
+	}
+	if added, _ := lo.Difference(caps.Add, allowedCaps); len(added) > 0 {
+		violations = append(violations, fmt.Sprintf("capabilities %v must not be added", added))
+	}
+	if seccomp != nil && seccomp.Type == corev1.SeccompProfileTypeUnconfined {
+		violations = append(violations, "seccompProfile must not be Unconfined")
+	}
+	if level == PodSecurityBaseline {
+		return violations
+	}
+
+	if sc.AllowPrivilegeEscalation == nil || *sc.AllowPrivilegeEscalation {
+		violations = append(violations, "allowPrivilegeEscalation must be false")
+	}
+	if !lo.Contains(caps.Drop, "ALL") {
+		violations = append(violations, "capabilities must drop ALL")
+	}
+	runAsNonRoot := sc.RunAsNonRoot
+	if runAsNonRoot == nil {
+		runAsNonRoot = podSC.RunAsNonRoot
+	}
+	if !lo.FromPtr(runAsNonRoot) {
+		violations = append(violations, "runAsNonRoot must be true")
+	}
+	runAsUser := sc.RunAsUser
+	if runAsUser == nil {
+		runAsUser = podSC.RunAsUser
+	}
+	if runAsUser != nil && *runAsUser == 0 {
+		violations = append(violations, "runAsUser must not be 0")
+	}
+	if seccomp == nil {
+		violations = append(violations, "seccompProfile must be RuntimeDefault or Localhost")
+	}
+	return violations
+}
@@ -0,0 +1,166 @@
+package inject
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newSecurityTestPod() *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"},
+		Spec: corev1.PodSpec{
+			Volumes: []corev1.Volume{{
+				Name:         "data",
+				VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data-claim"}},
+			}},
+		},
+	}
+}
+
+func TestPodSecurityViolations(t *testing.T) {
+	t.Parallel()
+
+	pod := newSecurityTestPod()
+	sidecar, err := Sidecar(pod, Options{Image: "sidecar:latest"})
+	require.NoError(t, err)
+
+	t.Run("default sidecar", func(t *testing.T) {
+		for _, level := range []string{"", PodSecurityPrivileged, PodSecurityBaseline, PodSecurityRestricted, "unknown"} {
+			require.Empty(t, PodSecurityViolations(level, pod, sidecar), level)
+		}
+	})
+
+	t.Run("privileged namespaces", func(t *testing.T) {
+		insecure := sidecar
+		insecure.SecurityContext = &corev1.SecurityContext{
+			Privileged:     ptr(true),
+			RunAsUser:      ptr(int64(0)),
+			Capabilities:   &corev1.Capabilities{Add: []corev1.Capability{"SYS_ADMIN"}},
+			SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeUnconfined},
+		}
+
+		for _, level := range []string{"", PodSecurityPrivileged, "unknown"} {
+			require.Empty(t, PodSecurityViolations(level, pod, insecure), level)
+		}
+	})
+
+	t.Run("baseline and restricted namespaces", func(t *testing.T) {
+		for _, tt := range []struct {
+			Name            string
+			SecurityContext *corev1.SecurityContext
+			PodSC           *corev1.PodSecurityContext
+			Baseline        []string
+			Restricted      []string
+		}{
+			{
+				Name:            "privileged",
+				SecurityContext: withSecurityContext(func(sc *corev1.SecurityContext) { sc.Privileged = ptr(true) }),
+				Baseline:        []string{"privileged must not be true"},
+				Restricted:      []string{"privileged must not be true"},
+			},
+			{
+				Name: "baseline capability",
+				SecurityContext: withSecurityContext(func(sc *corev1.SecurityContext) {
+					sc.Capabilities.Add = []corev1.Capability{"CHOWN"}
+				}),
+				Restricted: []string{"capabilities [CHOWN] must not be added"},
+			},
+			{
+				Name: "net bind service",
+				SecurityContext: withSecurityContext(func(sc *corev1.SecurityContext) {
+					sc.Capabilities.Add = []corev1.Capability{"NET_BIND_SERVICE"}
+				}),
+			},
+			{
+				Name: "privileged capability",
+				SecurityContext: withSecurityContext(func(sc *corev1.SecurityContext) {
+					sc.Capabilities.Add = []corev1.Capability{"SYS_ADMIN"}
+				}),
+				Baseline:   []string{"capabilities [SYS_ADMIN] must not be added"},
+				Restricted: []string{"capabilities [SYS_ADMIN] must not be added"},
+			},
+			{
+				Name: "unconfined seccomp",
+				SecurityContext: withSecurityContext(func(sc *corev1.SecurityContext) {
+					sc.SeccompProfile = &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeUnconfined}
+				}),
+				Baseline:   []string{"seccompProfile must not be Unconfined"},
+				Restricted: []string{"seccompProfile must not be Unconfined"},
+			},
+			{
+				Name:            "empty security context",
+				SecurityContext: &corev1.SecurityContext{},
+				Restricted: []string{
+					"allowPrivilegeEscalation must be false",
+					"capabilities must drop ALL",
+					"runAsNonRoot must be true",
+					"seccompProfile must be RuntimeDefault or Localhost",
+				},
+			},
+			{
+				Name: "pod security context",
+				SecurityContext: &corev1.SecurityContext{
+					AllowPrivilegeEscalation: ptr(false),
+					Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+				},
+				PodSC: &corev1.PodSecurityContext{
+					RunAsNonRoot:   ptr(true),
+					SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+				},
+			},
+			{
+				Name: "root user",
+				SecurityContext: withSecurityContext(func(sc *corev1.SecurityContext) {
+					sc.RunAsUser = nil
+				}),
+				PodSC:      &corev1.PodSecurityContext{RunAsUser: ptr(int64(0))},
+				Restricted: []string{"runAsUser must not be 0"},
+			},
+		} {
+			target := sidecar
+			target.SecurityContext = tt.SecurityContext
+			p := pod.DeepCopy()
+			p.Spec.SecurityContext = tt.PodSC
+
+			require.Equal(t, tt.Baseline, PodSecurityViolations(PodSecurityBaseline, p, target), tt.Name)
+			require.Equal(t, tt.Restricted, PodSecurityViolations(PodSecurityRestricted, p, target), tt.Name)
+		}
+	})
+
+	t.Run("template override", func(t *testing.T) {
+		template := &corev1.Container{
+			SecurityContext: &corev1.SecurityContext{
+				RunAsNonRoot:             ptr(false),
+				AllowPrivilegeEscalation: ptr(true),
+			},
+		}
+		overridden, err := Sidecar(pod, Options{Image: "sidecar:latest", Template: template})
+		require.NoError(t, err)
+
+		require.Empty(t, PodSecurityViolations(PodSecurityBaseline, pod, overridden))
+		require.Equal(t, []string{
+			"allowPrivilegeEscalation must be false",
+			"runAsNonRoot must be true",
+		}, PodSecurityViolations(PodSecurityRestricted, pod, overridden))
+
+		// Fields the template does not set keep the defaults.
+		template = &corev1.Container{
+			SecurityContext: &corev1.SecurityContext{RunAsUser: ptr(int64(1000))},
+		}
+		overridden, err = Sidecar(pod, Options{Image: "sidecar:latest", Template: template})
+		require.NoError(t, err)
+
+		require.Equal(t, int64(1000), *overridden.SecurityContext.RunAsUser)
+		require.Empty(t, PodSecurityViolations(PodSecurityRestricted, pod, overridden))
+	})
+}
+
+// withSecurityContext returns the default sidecar security context modified by fn.
+func withSecurityContext(fn func(sc *corev1.SecurityContext)) *corev1.SecurityContext {
+	sc := securityContext()
+	fn(sc)
+	return sc
+}
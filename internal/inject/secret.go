@@ -0,0 +1,162 @@
+package inject
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/kube"
+)
+
+const (
+	// SecretName is the name of the Secret holding the sidecar's token and serving certificate in every namespace
+	// with injected pods.
+	SecretName = "pvc-autoscaler-operator-sidecar"
+	// TokenKey is the Secret key of the bearer token the operator authenticates with.
+	TokenKey = "token"
+
+	secretVolume    = "diskhealthcheck-credentials"
+	secretMountPath = "/var/run/secrets/pvc-autoscaler-operator"
+)
+
+// SecretLabels are set on every Secret managed by the operator.
+var SecretLabels = map[string]string{
+	"app.kubernetes.io/managed-by": "pvc-autoscaler-operator",
+	"app.kubernetes.io/component":  "diskhealthcheck",
+}
+
+// SecretManager creates the sidecar Secret of each namespace and renews its serving certificate.
+type SecretManager struct {
+	client client.Client
+	opts   Options
+	issuer *CertIssuer
+}
+
+// NewSecretManager returns a SecretManager for sidecars injected with opts.
+// Issuer is required if opts.TLS is true.
+func NewSecretManager(c client.Client, opts Options, issuer *CertIssuer) *SecretManager {
+	return &SecretManager{client: c, opts: opts, issuer: issuer}
+}
+
+// Enabled returns true if injected sidecars mount the sidecar Secret.
+func (m *SecretManager) Enabled() bool {
+	return m != nil && (m.opts.Auth || m.opts.TLS)
+}
+
+// Ensure creates the sidecar Secret with a random token in namespace if it does not exist.
+// If TLS is enabled, it also issues a serving certificate, renewing it before it expires.
+func (m *SecretManager) Ensure(ctx context.Context, namespace string) error {
+	var secret corev1.Secret
+	err := m.client.Get(ctx, client.ObjectKey{Namespace: namespace, Name: SecretName}, &secret)
+	switch {
+	case kube.IsNotFound(err):
+		return m.create(ctx, namespace)
+	case err != nil:
+		return fmt.Errorf("get secret: %w", err)
+	}
+
+	if !m.opts.TLS {
+		return nil
+	}
+	renew, err := m.issuer.NeedsRenewal(ctx, namespace, secret.Data[corev1.TLSCertKey])
+	if err != nil || !renew {
+		return err
+	}
+	certPEM, keyPEM, err := m.issuer.Issue(ctx, namespace)
+	if err != nil {
+		return err
+	}
+	if secret.Data == nil {
+		secret.Data = make(map[string][]byte)
+	}
+	secret.Data[corev1.TLSCertKey] = certPEM
+	secret.Data[corev1.TLSPrivateKeyKey] = keyPEM
+	if err = m.client.Update(ctx, &secret); err != nil {
+		return fmt.Errorf("update secret: %w", err)
+	}
+	return nil
+}
+
+func (m *SecretManager) create(ctx context.Context, namespace string) error {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return fmt.Errorf("generate token: %w", err)
+	}
+	secret := corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      SecretName,
+			Labels:    SecretLabels,
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: map[string][]byte{
+			TokenKey: []byte(hex.EncodeToString(token)),
+		},
+	}
+	if m.opts.TLS {
+		certPEM, keyPEM, err := m.issuer.Issue(ctx, namespace)
+		if err != nil {
+			return err
+		}
+		secret.Data[corev1.TLSCertKey] = certPEM
+		secret.Data[corev1.TLSPrivateKeyKey] = keyPEM
+	}
+	if err := m.client.Create(ctx, &secret); kube.IgnoreAlreadyExists(err) != nil {
+		return fmt.Errorf("create secret: %w", err)
+	}
+	return nil
+}
+
+// tokenTTL is how long SecretTokenSource caches a token. Tokens are only set when the Secret is created.
+const tokenTTL = time.Minute
+
+// SecretTokenSource reads sidecar tokens from the sidecar Secret of each namespace.
+// It implements healthcheck.TokenSource.
+type SecretTokenSource struct {
+	reader client.Reader
+	now    func() time.Time
+
+	mu     sync.Mutex
+	tokens map[string]cachedToken
+}
+
+type cachedToken struct {
+	token   string
+	fetched time.Time
+}
+
+// NewSecretTokenSource returns a SecretTokenSource reading Secrets with reader.
+// Since reader is not expected to cache Secrets, tokens are cached for a minute.
+func NewSecretTokenSource(reader client.Reader) *SecretTokenSource {
+	return &SecretTokenSource{reader: reader, now: time.Now, tokens: make(map[string]cachedToken)}
+}
+
+// Token returns the token in the namespace's sidecar Secret, or an empty token if there is no Secret.
+func (s *SecretTokenSource) Token(ctx context.Context, namespace string) (string, error) {
+	s.mu.Lock()
+	cached, ok := s.tokens[namespace]
+	s.mu.Unlock()
+	if ok && s.now().Sub(cached.fetched) < tokenTTL {
+		return cached.token, nil
+	}
+
+	var secret corev1.Secret
+	if err := s.reader.Get(ctx, client.ObjectKey{Namespace: namespace, Name: SecretName}, &secret); kube.IgnoreNotFound(err) != nil {
+		return "", err
+	}
+	token := string(secret.Data[TokenKey])
+	// Missing tokens are not cached, so sidecars are authenticated as soon as their Secret is created.
+	if token != "" {
+		s.mu.Lock()
+		s.tokens[namespace] = cachedToken{token: token, fetched: s.now()}
+		s.mu.Unlock()
+	}
+	return token, nil
+}
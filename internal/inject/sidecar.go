@@ -1,54 +1,169 @@
 package inject
 
 import (
+	"encoding/json"
 	"fmt"
 	"path/filepath"
-	"strings"
 
+	"github.com/samber/lo"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
 
 	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/healthcheck"
 )
 
 const healthCheckPort = healthcheck.Port
 
-// SidecarInjector is a sidecar injector
-func Sidecar(pod *corev1.Pod, image string) (corev1.Container, error) {
-	volMap := make(map[string]string)
+// ContainerName is the name of the injected sidecar container.
+const ContainerName = "diskhealthcheck"
+
+// OperatorEnv are the env vars of the sidecar set by the operator, which its template cannot override.
+var OperatorEnv = []string{"POD_NAMESPACE", "POD_NAME", "NODE_NAME", healthcheck.ConfigEnv}
+
+// localVolume is the volume shared by the sidecar with the app containers, see healthcheck.LocalTokenDir.
+const localVolume = "diskhealthcheck-local"
+
+// ephemeralDir is the directory under healthcheck.Mount where ephemeral volumes with unknown claim names are mounted.
+// It is not a valid PVC name, so it never clashes with the mount of a claim.
+const ephemeralDir = "_ephemeral"
+
+// Options configures the injected sidecar.
+type Options struct {
+	// Image is the sidecar image.
+	Image string
+	// Auth requires the operator to authenticate with the sidecar using the token in the namespace's sidecar Secret.
+	Auth bool
+	// TLS serves the sidecar over TLS with the serving certificate in the namespace's sidecar Secret.
+	TLS bool
+	// Native injects the sidecar as a restartable init container, see Inject.
+	Native bool
+	// UsageProbe optionally reports the logical usage of the pod's volumes to the sidecar.
+	UsageProbe *healthcheck.UsageProbe
+	// Template is a partial container strategically merged onto the default sidecar, see MergeTemplate.
+	Template *corev1.Container
+	// ImagePullSecrets are added to the pod's image pull secrets.
+	ImagePullSecrets []corev1.LocalObjectReference
+	// Volumes selects the PVCs mounted in the sidecar. Every PVC is mounted if empty.
+	Volumes VolumeFilter
+}
 
+// SidecarInjector is a sidecar injector
+func Sidecar(pod *corev1.Pod, opts Options) (corev1.Container, error) {
+	// Every claim is mounted once, in the order of the pod's volumes, so the sidecar spec is stable.
+	var volumes []healthcheck.Volume
 	for _, volume := range pod.Spec.Volumes {
-		if volume.PersistentVolumeClaim != nil {
-			volMap[volume.Name] = volume.PersistentVolumeClaim.ClaimName
+		if volume.PersistentVolumeClaim == nil && volume.Ephemeral == nil {
+			continue
+		}
+		claim := ClaimName(pod, volume)
+		if !opts.Volumes.Monitors(volume.Name, claim) {
+			continue
+		}
+		if claim == "" {
+			// The pod's name is generated after admission, so the sidecar resolves the claim from $POD_NAME.
+			volumes = append(volumes, healthcheck.Volume{
+				Name:      volume.Name,
+				MountPath: filepath.Join(healthcheck.Mount, ephemeralDir, volume.Name),
+				Ephemeral: true,
+				ReadOnly:  readOnly(pod, volume),
+			})
+			continue
+		}
+		if _, i, ok := lo.FindIndexOf(volumes, func(v healthcheck.Volume) bool { return v.ClaimName == claim }); ok {
+			// The claim is writable if any volume referencing it is.
+			volumes[i].ReadOnly = volumes[i].ReadOnly && readOnly(pod, volume)
+			continue
 		}
+		volumes = append(volumes, healthcheck.Volume{
+			Name:      volume.Name,
+			ClaimName: claim,
+			MountPath: filepath.Clean(healthcheck.Mount + "/" + claim),
+			Ephemeral: volume.Ephemeral != nil,
+			ReadOnly:  readOnly(pod, volume),
+		})
 	}
 
-	if len(volMap) == 0 {
+	if len(volumes) == 0 {
 		return corev1.Container{}, fmt.Errorf("no PVCs to monitor")
 	}
 
 	// Mounts required by sidecar container.
 	var mounts []corev1.VolumeMount
-	var pvcNames []string
-	for vol, pvc := range volMap {
-		mountPath := filepath.Clean(healthcheck.Mount + "/" + pvc)
+	for _, vol := range volumes {
 		mounts = append(mounts, corev1.VolumeMount{
-			Name:      vol,
-			MountPath: mountPath,
+			Name:      vol.Name,
+			MountPath: vol.MountPath,
 			ReadOnly:  true,
 		})
-		pvcNames = append(pvcNames, pvc)
+	}
+	config, err := json.Marshal(healthcheck.Config{Volumes: volumes, UsageProbe: opts.UsageProbe})
+	if err != nil {
+		return corev1.Container{}, fmt.Errorf("marshal sidecar config: %w", err)
+	}
+
+	command := []string{"/manager", "healthcheck"}
+	// Disk errors are reported to the operator by /disk and must not affect the pod's readiness.
+	readiness := probeHandler("/readyz", opts)
+	liveness := probeHandler("/healthz", opts)
+	if opts.Auth || opts.TLS {
+		mounts = append(mounts, corev1.VolumeMount{
+			Name:      secretVolume,
+			MountPath: secretMountPath,
+			ReadOnly:  true,
+		})
+	}
+	if opts.TLS {
+		command = append(command,
+			"--tls-cert-file", filepath.Join(secretMountPath, corev1.TLSCertKey),
+			"--tls-key-file", filepath.Join(secretMountPath, corev1.TLSPrivateKeyKey),
+		)
+	}
+	if opts.Auth {
+		command = append(command, "--auth-token-file", filepath.Join(secretMountPath, TokenKey))
+	}
+	if pod.Spec.HostNetwork {
+		// Every pod on the host network shares the loopback interface, so resize requests are disabled.
+		command = append(command, "--local-addr", "")
+	} else {
+		mounts = append(mounts, corev1.VolumeMount{Name: localVolume, MountPath: healthcheck.LocalTokenDir})
+	}
+
+	var restartPolicy *corev1.ContainerRestartPolicy
+	if opts.Native {
+		restartPolicy = ptr(corev1.ContainerRestartPolicyAlways)
 	}
 
-	return corev1.Container{
-		Name: "diskhealthcheck",
+	sidecar := corev1.Container{
+		Name: ContainerName,
 		// Available images: https://github.com/allthatjazzleo/pvc-autoscaler-operator/packages
-		Image:           image,
+		Image:           opts.Image,
 		ImagePullPolicy: corev1.PullIfNotPresent,
-		Command:         []string{"/manager", "healthcheck", "--pvcs", strings.Join(pvcNames, ",")},
+		Command:         command,
+		Env: []corev1.EnvVar{
+			{
+				Name:      "POD_NAMESPACE",
+				ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.namespace"}},
+			},
+			{
+				Name:      "POD_NAME",
+				ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"}},
+			},
+			{
+				Name:      "NODE_NAME",
+				ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "spec.nodeName"}},
+			},
+			{Name: healthcheck.ConfigEnv, Value: string(config)},
+		},
 		VolumeMounts:    mounts,
-		Ports:           []corev1.ContainerPort{{ContainerPort: healthCheckPort, Protocol: corev1.ProtocolTCP}},
+		RestartPolicy:   restartPolicy,
+		SecurityContext: securityContext(),
+		// The healthcheck port also serves Prometheus metrics on /metrics.
+		Ports: []corev1.ContainerPort{
+			{Name: "healthcheck", ContainerPort: healthCheckPort, Protocol: corev1.ProtocolTCP},
+			{Name: "healthcheck-rpc", ContainerPort: healthcheck.GRPCPort, Protocol: corev1.ProtocolTCP},
+		},
 		Resources: corev1.ResourceRequirements{
 			Requests: corev1.ResourceList{
 				corev1.ResourceCPU:    resource.MustParse("5m"),
@@ -56,18 +171,169 @@ func Sidecar(pod *corev1.Pod, image string) (corev1.Container, error) {
 			},
 		},
 		ReadinessProbe: &corev1.Probe{
-			ProbeHandler: corev1.ProbeHandler{
-				HTTPGet: &corev1.HTTPGetAction{
-					Path:   "/disk",
-					Port:   intstr.FromInt(healthCheckPort),
-					Scheme: corev1.URISchemeHTTP,
-				},
-			},
+			ProbeHandler:        readiness,
 			InitialDelaySeconds: 1,
 			TimeoutSeconds:      10,
 			PeriodSeconds:       10,
 			SuccessThreshold:    1,
 			FailureThreshold:    3,
 		},
-	}, nil
+		LivenessProbe: &corev1.Probe{
+			ProbeHandler:        liveness,
+			InitialDelaySeconds: 10,
+			TimeoutSeconds:      10,
+			PeriodSeconds:       30,
+			SuccessThreshold:    1,
+			FailureThreshold:    3,
+		},
+	}
+	if opts.Template == nil {
+		return sidecar, nil
+	}
+	return MergeTemplate(sidecar, *opts.Template)
+}
+
+// MergeTemplate strategically merges template onto sidecar, e.g. env vars are merged by name.
+// The template cannot rename the sidecar or override OperatorEnv.
+func MergeTemplate(sidecar corev1.Container, template corev1.Container) (corev1.Container, error) {
+	template.Name = sidecar.Name
+	template.Env = lo.Filter(template.Env, func(env corev1.EnvVar, _ int) bool {
+		return !lo.Contains(OperatorEnv, env.Name)
+	})
+
+	original, err := json.Marshal(sidecar)
+	if err != nil {
+		return corev1.Container{}, fmt.Errorf("marshal sidecar: %w", err)
+	}
+	patch, err := json.Marshal(template)
+	if err != nil {
+		return corev1.Container{}, fmt.Errorf("marshal sidecar template: %w", err)
+	}
+	merged, err := strategicpatch.StrategicMergePatch(original, patch, corev1.Container{})
+	if err != nil {
+		return corev1.Container{}, fmt.Errorf("merge sidecar template: %w", err)
+	}
+	var result corev1.Container
+	if err = json.Unmarshal(merged, &result); err != nil {
+		return corev1.Container{}, fmt.Errorf("unmarshal sidecar: %w", err)
+	}
+	return result, nil
+}
+
+// probeHandler returns a probe of the sidecar's unauthenticated probe endpoint at path.
+func probeHandler(path string, opts Options) corev1.ProbeHandler {
+	scheme := corev1.URISchemeHTTP
+	if opts.TLS {
+		// The kubelet does not verify certificates.
+		scheme = corev1.URISchemeHTTPS
+	}
+	return corev1.ProbeHandler{
+		HTTPGet: &corev1.HTTPGetAction{
+			Path:   path,
+			Port:   intstr.FromInt(healthCheckPort),
+			Scheme: scheme,
+		},
+	}
+}
+
+// ClaimName returns the name of the PVC backing volume, or "" if it is not backed by a PVC.
+// The PVC of a generic ephemeral volume is named after the pod, so its name is "" while the pod only has a generateName.
+func ClaimName(pod *corev1.Pod, volume corev1.Volume) string {
+	switch {
+	case volume.PersistentVolumeClaim != nil:
+		return volume.PersistentVolumeClaim.ClaimName
+	case volume.Ephemeral != nil && pod.Name != "":
+		return healthcheck.EphemeralClaimName(pod.Name, volume.Name)
+	}
+	return ""
+}
+
+// readOnly returns true if the application cannot write to volume: its PVC is attached read-only or no container
+// of the pod mounts it writable.
+func readOnly(pod *corev1.Pod, volume corev1.Volume) bool {
+	if volume.PersistentVolumeClaim != nil && volume.PersistentVolumeClaim.ReadOnly {
+		return true
+	}
+	writable := func(mount corev1.VolumeMount) bool { return mount.Name == volume.Name && !mount.ReadOnly }
+	return !lo.SomeBy(append(append([]corev1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...), func(c corev1.Container) bool {
+		return lo.SomeBy(c.VolumeMounts, writable)
+	})
+}
+
+// HasSidecar returns true if the pod already has the sidecar, either as a container or as a native sidecar.
+func HasSidecar(pod *corev1.Pod) bool {
+	isSidecar := func(c corev1.Container) bool { return c.Name == ContainerName }
+	return lo.SomeBy(pod.Spec.Containers, isSidecar) || lo.SomeBy(pod.Spec.InitContainers, isSidecar)
+}
+
+// Inject adds the sidecar returned by Sidecar, its volumes and image pull secrets to the pod.
+// If opts.Native is true, the sidecar is appended to the init containers, so it starts after any existing
+// init containers complete and runs alongside the app containers without blocking Jobs from completing.
+// Unless the pod uses the host network, the directory the sidecar writes the token for resize requests to is
+// mounted read-only into the app containers, see healthcheck.LocalTokenDir.
+func Inject(pod *corev1.Pod, sidecar corev1.Container, opts Options) {
+	if !pod.Spec.HostNetwork {
+		for i := range pod.Spec.Containers {
+			container := &pod.Spec.Containers[i]
+			if lo.ContainsBy(container.VolumeMounts, func(mount corev1.VolumeMount) bool { return mount.MountPath == healthcheck.LocalTokenDir }) {
+				continue
+			}
+			container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+				Name:      localVolume,
+				MountPath: healthcheck.LocalTokenDir,
+				ReadOnly:  true,
+			})
+		}
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+			Name: localVolume,
+			VolumeSource: corev1.VolumeSource{
+				EmptyDir: &corev1.EmptyDirVolumeSource{Medium: corev1.StorageMediumMemory, SizeLimit: resource.NewQuantity(1<<20, resource.BinarySI)},
+			},
+		})
+	}
+	if opts.Native {
+		pod.Spec.InitContainers = append(pod.Spec.InitContainers, sidecar)
+	} else {
+		pod.Spec.Containers = append(pod.Spec.Containers, sidecar)
+	}
+	pod.Spec.Volumes = append(pod.Spec.Volumes, Volumes(opts)...)
+	for _, secret := range opts.ImagePullSecrets {
+		if !lo.Contains(pod.Spec.ImagePullSecrets, secret) {
+			pod.Spec.ImagePullSecrets = append(pod.Spec.ImagePullSecrets, secret)
+		}
+	}
+}
+
+// Volumes returns the pod volumes required by the sidecar.
+func Volumes(opts Options) []corev1.Volume {
+	var items []corev1.KeyToPath
+	if opts.Auth {
+		items = append(items, corev1.KeyToPath{Key: TokenKey, Path: TokenKey})
+	}
+	if opts.TLS {
+		items = append(items,
+			corev1.KeyToPath{Key: corev1.TLSCertKey, Path: corev1.TLSCertKey},
+			corev1.KeyToPath{Key: corev1.TLSPrivateKeyKey, Path: corev1.TLSPrivateKeyKey},
+		)
+	}
+	if len(items) == 0 {
+		return nil
+	}
+	return []corev1.Volume{
+		{
+			Name: secretVolume,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: SecretName,
+					Items:      items,
+					// The sidecar rejects all requests until the secret exists.
+					Optional: ptr(true),
+				},
+			},
+		},
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
 }
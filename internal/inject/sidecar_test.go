@@ -0,0 +1,177 @@
+package inject
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/samber/lo"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/healthcheck"
+)
+
+// sidecarConfig returns the config passed to sidecar.
+func sidecarConfig(t *testing.T, sidecar corev1.Container) healthcheck.Config {
+	t.Helper()
+	env, ok := lo.Find(sidecar.Env, func(env corev1.EnvVar) bool { return env.Name == healthcheck.ConfigEnv })
+	require.True(t, ok)
+	cfg, err := healthcheck.ParseConfig([]byte(env.Value))
+	require.NoError(t, err)
+	return cfg
+}
+
+func claimVolume(name, claim string) corev1.Volume {
+	return corev1.Volume{
+		Name:         name,
+		VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claim}},
+	}
+}
+
+func TestSidecar_readOnly(t *testing.T) {
+	t.Parallel()
+
+	readOnlyClaim := claimVolume("archive", "archive")
+	readOnlyClaim.PersistentVolumeClaim.ReadOnly = true
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"},
+		Spec: corev1.PodSpec{
+			Volumes: []corev1.Volume{
+				claimVolume("data", "data"),
+				claimVolume("config", "config"),
+				claimVolume("shared", "shared"),
+				readOnlyClaim,
+				claimVolume("unmounted", "unmounted"),
+				// Writable through another volume referencing the same claim.
+				claimVolume("config-rw", "config"),
+			},
+			InitContainers: []corev1.Container{{
+				Name:         "init",
+				VolumeMounts: []corev1.VolumeMount{{Name: "shared", MountPath: "/shared"}, {Name: "config-rw", MountPath: "/config"}},
+			}},
+			Containers: []corev1.Container{{
+				Name: "app",
+				VolumeMounts: []corev1.VolumeMount{
+					{Name: "data", MountPath: "/data"},
+					{Name: "config", MountPath: "/config", ReadOnly: true},
+					{Name: "shared", MountPath: "/shared", ReadOnly: true},
+					{Name: "archive", MountPath: "/archive"},
+				},
+			}},
+		},
+	}
+
+	sidecar, err := Sidecar(pod, Options{Image: "image"})
+	require.NoError(t, err)
+
+	got := lo.SliceToMap(sidecarConfig(t, sidecar).Volumes, func(v healthcheck.Volume) (string, bool) { return v.ClaimName, v.ReadOnly })
+	require.Equal(t, map[string]bool{
+		"data":      false,
+		"config":    false,
+		"shared":    false,
+		"archive":   true,
+		"unmounted": true,
+	}, got)
+
+	pod.Spec.InitContainers = nil
+	sidecar, err = Sidecar(pod, Options{Image: "image"})
+	require.NoError(t, err)
+
+	got = lo.SliceToMap(sidecarConfig(t, sidecar).Volumes, func(v healthcheck.Volume) (string, bool) { return v.ClaimName, v.ReadOnly })
+	require.True(t, got["config"], "only mounted read-only")
+	require.True(t, got["shared"], "only mounted read-only")
+	require.True(t, lo.EveryBy(sidecar.VolumeMounts, func(m corev1.VolumeMount) bool {
+		return m.ReadOnly || m.MountPath == healthcheck.LocalTokenDir
+	}), "sidecar mounts PVCs read-only")
+}
+
+func TestSidecar_ephemeralMountPath(t *testing.T) {
+	t.Parallel()
+
+	pod := &corev1.Pod{
+		// The pod's name is generated after admission, so the ephemeral claim name is unknown.
+		ObjectMeta: metav1.ObjectMeta{GenerateName: "db-", Namespace: "default"},
+		Spec: corev1.PodSpec{
+			Volumes: []corev1.Volume{
+				claimVolume("data", "ephemeral"),
+				{Name: "scratch", VolumeSource: corev1.VolumeSource{Ephemeral: &corev1.EphemeralVolumeSource{}}},
+			},
+		},
+	}
+
+	sidecar, err := Sidecar(pod, Options{Image: "image"})
+	require.NoError(t, err)
+
+	got := lo.SliceToMap(sidecarConfig(t, sidecar).Volumes, func(v healthcheck.Volume) (string, string) { return v.Name, v.MountPath })
+	require.Equal(t, map[string]string{
+		"data":    "/mnt/ephemeral",
+		"scratch": "/mnt/_ephemeral/scratch",
+	}, got)
+	for _, a := range got {
+		for _, b := range got {
+			require.False(t, a != b && strings.HasPrefix(b, a+"/"), "%s is mounted inside %s", b, a)
+		}
+	}
+}
+
+func TestInject_localToken(t *testing.T) {
+	t.Parallel()
+
+	newPod := func() *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "pod", Namespace: "default"},
+			Spec: corev1.PodSpec{
+				Volumes:        []corev1.Volume{claimVolume("data", "data")},
+				InitContainers: []corev1.Container{{Name: "init"}},
+				Containers: []corev1.Container{
+					{Name: "app", VolumeMounts: []corev1.VolumeMount{{Name: "data", MountPath: "/data"}}},
+					{Name: "proxy"},
+				},
+			},
+		}
+	}
+	localMount := func(c corev1.Container) (corev1.VolumeMount, bool) {
+		return lo.Find(c.VolumeMounts, func(m corev1.VolumeMount) bool { return m.MountPath == healthcheck.LocalTokenDir })
+	}
+
+	for _, native := range []bool{false, true} {
+		pod := newPod()
+		opts := Options{Image: "image", Native: native}
+		sidecar, err := Sidecar(pod, opts)
+		require.NoError(t, err)
+
+		Inject(pod, sidecar, opts)
+
+		for _, c := range pod.Spec.Containers[:2] {
+			mount, ok := localMount(c)
+			require.True(t, ok, c.Name)
+			require.True(t, mount.ReadOnly, c.Name)
+		}
+		_, ok := localMount(pod.Spec.InitContainers[0])
+		require.False(t, ok, "init containers finish before the sidecar writes the token")
+		mount, ok := localMount(sidecar)
+		require.True(t, ok)
+		require.False(t, mount.ReadOnly, "the sidecar writes the token")
+		require.True(t, lo.ContainsBy(pod.Spec.Volumes, func(v corev1.Volume) bool {
+			return v.Name == mount.Name && v.EmptyDir != nil && v.EmptyDir.Medium == corev1.StorageMediumMemory
+		}))
+		require.NotContains(t, sidecar.Command, "--local-addr")
+	}
+
+	t.Run("host network", func(t *testing.T) {
+		pod := newPod()
+		pod.Spec.HostNetwork = true
+		sidecar, err := Sidecar(pod, Options{Image: "image"})
+		require.NoError(t, err)
+
+		Inject(pod, sidecar, Options{})
+
+		require.Equal(t, []string{"--local-addr", ""}, sidecar.Command[len(sidecar.Command)-2:])
+		for _, c := range pod.Spec.Containers {
+			_, ok := localMount(c)
+			require.False(t, ok, c.Name)
+		}
+		require.Len(t, pod.Spec.Volumes, 1)
+	})
+}
@@ -0,0 +1,76 @@
+package inject
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/samber/lo"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/kube"
+)
+
+// VolumeFilter selects the PVCs monitored by the sidecar.
+// Patterns are path.Match patterns, e.g. "data-*", matched against both the volume name and the claim name.
+type VolumeFilter struct {
+	// Include only monitors the volumes matching any pattern. If empty, every volume is included.
+	Include []string
+	// Exclude never monitors the volumes matching any pattern, even if included.
+	Exclude []string
+}
+
+// PodVolumeFilter returns filter overridden by the include-volumes and exclude-volumes annotations of a pod.
+// The pod's include patterns replace those of filter, while its exclude patterns are added to them.
+func PodVolumeFilter(filter VolumeFilter, annotations map[string]string) VolumeFilter {
+	if include := splitPatterns(annotations[kube.IncludeVolumes]); len(include) > 0 {
+		filter.Include = include
+	}
+	if exclude := splitPatterns(annotations[kube.ExcludeVolumes]); len(exclude) > 0 {
+		filter.Exclude = append(append([]string(nil), filter.Exclude...), exclude...)
+	}
+	return filter
+}
+
+// Validate returns an error if any pattern is malformed.
+func (f VolumeFilter) Validate() error {
+	for _, pattern := range append(append([]string(nil), f.Include...), f.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid volume pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Monitors returns true if the volume named name, backed by claim, is monitored.
+// Malformed patterns never match.
+func (f VolumeFilter) Monitors(name, claim string) bool {
+	matches := func(pattern string) bool {
+		return matchPattern(pattern, name) || matchPattern(pattern, claim)
+	}
+	if len(f.Include) > 0 && !lo.SomeBy(f.Include, matches) {
+		return false
+	}
+	return !lo.SomeBy(f.Exclude, matches)
+}
+
+// MonitorsClaim returns true if the pod's volume backed by claim is monitored.
+func (f VolumeFilter) MonitorsClaim(pod *corev1.Pod, claim string) bool {
+	name := claim
+	if volume, ok := lo.Find(pod.Spec.Volumes, func(v corev1.Volume) bool {
+		return ClaimName(pod, v) == claim
+	}); ok {
+		name = volume.Name
+	}
+	return f.Monitors(name, claim)
+}
+
+func matchPattern(pattern, name string) bool {
+	ok, _ := path.Match(pattern, name)
+	return ok
+}
+
+func splitPatterns(val string) []string {
+	patterns := lo.Map(strings.Split(val, ","), func(p string, _ int) string { return strings.TrimSpace(p) })
+	return lo.Compact(patterns)
+}
@@ -0,0 +1,174 @@
+package inject
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/kube"
+)
+
+func TestPodVolumeFilter(t *testing.T) {
+	t.Parallel()
+
+	filter := VolumeFilter{Include: []string{"data-*"}, Exclude: []string{"cache"}}
+
+	for _, tt := range []struct {
+		Name        string
+		Annotations map[string]string
+		Want        VolumeFilter
+	}{
+		{"no annotations", nil, filter},
+		{
+			"include replaces",
+			map[string]string{kube.IncludeVolumes: "logs, wal-*"},
+			VolumeFilter{Include: []string{"logs", "wal-*"}, Exclude: []string{"cache"}},
+		},
+		{
+			"exclude appends",
+			map[string]string{kube.ExcludeVolumes: "scratch,tmp-*"},
+			VolumeFilter{Include: []string{"data-*"}, Exclude: []string{"cache", "scratch", "tmp-*"}},
+		},
+		{
+			"both",
+			map[string]string{kube.IncludeVolumes: "logs", kube.ExcludeVolumes: "scratch"},
+			VolumeFilter{Include: []string{"logs"}, Exclude: []string{"cache", "scratch"}},
+		},
+		{
+			"empty patterns",
+			map[string]string{kube.IncludeVolumes: "", kube.ExcludeVolumes: " , ,"},
+			filter,
+		},
+		{
+			"whitespace around patterns",
+			map[string]string{kube.IncludeVolumes: "  logs ,, wal-* ", kube.ExcludeVolumes: "\tscratch\n"},
+			VolumeFilter{Include: []string{"logs", "wal-*"}, Exclude: []string{"cache", "scratch"}},
+		},
+	} {
+		got := PodVolumeFilter(filter, tt.Annotations)
+
+		require.Equal(t, tt.Want, got, tt.Name)
+	}
+
+	t.Run("does not modify the filter", func(t *testing.T) {
+		exclude := make([]string, 1, 10)
+		exclude[0] = "cache"
+		filter := VolumeFilter{Exclude: exclude}
+
+		a := PodVolumeFilter(filter, map[string]string{kube.ExcludeVolumes: "a"})
+		b := PodVolumeFilter(filter, map[string]string{kube.ExcludeVolumes: "b"})
+
+		require.Equal(t, []string{"cache"}, filter.Exclude)
+		require.Equal(t, []string{"cache", "a"}, a.Exclude)
+		require.Equal(t, []string{"cache", "b"}, b.Exclude)
+	})
+}
+
+func TestVolumeFilter_Validate(t *testing.T) {
+	t.Parallel()
+
+	require.NoError(t, VolumeFilter{}.Validate())
+	require.NoError(t, VolumeFilter{Include: []string{"data-*", "logs"}, Exclude: []string{"tmp-[0-9]"}}.Validate())
+
+	err := VolumeFilter{Include: []string{"data-*"}, Exclude: []string{"tmp-["}}.Validate()
+	require.EqualError(t, err, `invalid volume pattern "tmp-[": syntax error in pattern`)
+
+	require.Error(t, VolumeFilter{Include: []string{"[a-"}}.Validate())
+}
+
+func TestVolumeFilter_Monitors(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		Name   string
+		Filter VolumeFilter
+		Volume string
+		Claim  string
+		Want   bool
+	}{
+		{"empty filter", VolumeFilter{}, "data", "data-claim", true},
+		{"include volume name", VolumeFilter{Include: []string{"data"}}, "data", "data-claim", true},
+		{"include claim name", VolumeFilter{Include: []string{"data-claim"}}, "data", "data-claim", true},
+		{"include glob", VolumeFilter{Include: []string{"data-*"}}, "vol", "data-claim", true},
+		{"not included", VolumeFilter{Include: []string{"logs", "wal-*"}}, "data", "data-claim", false},
+		{"exclude volume name", VolumeFilter{Exclude: []string{"data"}}, "data", "data-claim", false},
+		{"exclude claim name", VolumeFilter{Exclude: []string{"*-claim"}}, "data", "data-claim", false},
+		{"exclude wins over include", VolumeFilter{Include: []string{"data"}, Exclude: []string{"data"}}, "data", "data-claim", false},
+		{"not excluded", VolumeFilter{Exclude: []string{"logs"}}, "data", "data-claim", true},
+		{"malformed include never matches", VolumeFilter{Include: []string{"data-["}}, "data-[", "data-claim", false},
+		{"malformed exclude never matches", VolumeFilter{Exclude: []string{"data-["}}, "data-[", "data-claim", true},
+		{"unknown claim", VolumeFilter{Include: []string{"data"}}, "data", "", true},
+	} {
+		got := tt.Filter.Monitors(tt.Volume, tt.Claim)
+
+		require.Equal(t, tt.Want, got, tt.Name)
+	}
+}
+
+func TestVolumeFilter_MonitorsClaim(t *testing.T) {
+	t.Parallel()
+
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "db-0"},
+		Spec: corev1.PodSpec{
+			Volumes: []corev1.Volume{
+				{Name: "data", VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data-db-0"}}},
+				{Name: "scratch", VolumeSource: corev1.VolumeSource{Ephemeral: &corev1.EphemeralVolumeSource{}}},
+			},
+		},
+	}
+
+	for _, tt := range []struct {
+		Name   string
+		Filter VolumeFilter
+		Claim  string
+		Want   bool
+	}{
+		{"matched by volume name", VolumeFilter{Include: []string{"data"}}, "data-db-0", true},
+		{"excluded by volume name", VolumeFilter{Exclude: []string{"data"}}, "data-db-0", false},
+		{"ephemeral volume name", VolumeFilter{Exclude: []string{"scratch"}}, "db-0-scratch", false},
+		{"ephemeral claim name", VolumeFilter{Include: []string{"db-0-*"}}, "db-0-scratch", true},
+		// Claims not mounted by the pod are matched by claim name only.
+		{"unmounted claim", VolumeFilter{Include: []string{"data"}}, "other", false},
+		{"unmounted claim included", VolumeFilter{Include: []string{"oth*"}}, "other", true},
+	} {
+		got := tt.Filter.MonitorsClaim(pod, tt.Claim)
+
+		require.Equal(t, tt.Want, got, tt.Name)
+	}
+}
+
+func TestMatchPattern(t *testing.T) {
+	t.Parallel()
+
+	require.True(t, matchPattern("data", "data"))
+	require.True(t, matchPattern("data-*", "data-0"))
+	require.True(t, matchPattern("data-?", "data-1"))
+	require.True(t, matchPattern("*", ""))
+	require.False(t, matchPattern("data", "data-0"))
+	require.False(t, matchPattern("data-*", "logs-0"))
+	require.False(t, matchPattern("", "data"))
+	require.False(t, matchPattern("[", "["))
+}
+
+func TestSplitPatterns(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		Val  string
+		Want []string
+	}{
+		{"", []string{}},
+		{"   ", []string{}},
+		{",, ,", []string{}},
+		{"data", []string{"data"}},
+		{" data , logs-* ", []string{"data", "logs-*"}},
+		{"data,,logs", []string{"data", "logs"}},
+	} {
+		got := splitPatterns(tt.Val)
+
+		require.Equal(t, tt.Want, got, tt.Val)
+	}
+}
@@ -7,6 +7,19 @@ const (
 	OperatorName      = "pvc-autoscaler-operator.kubernetes.io/operator-name"
 	OperatorNamespace = "pvc-autoscaler-operator.kubernetes.io/operator-namespace"
 	OperatorImage     = "pvc-autoscaler-operator.kubernetes.io/sidecar-image"
+	OperatorMode      = "pvc-autoscaler-operator.kubernetes.io/sidecar-mode"
+	// UsageProbeURL is the URL the sidecar queries for the logical usage of the pod's volumes, see healthcheck.UsageProbe.
+	UsageProbeURL = "pvc-autoscaler-operator.kubernetes.io/usage-probe-url"
+	// IncludeVolumes and ExcludeVolumes are comma delimited volume or claim name patterns, see inject.VolumeFilter.
+	IncludeVolumes = "pvc-autoscaler-operator.kubernetes.io/include-volumes"
+	ExcludeVolumes = "pvc-autoscaler-operator.kubernetes.io/exclude-volumes"
+	// RolloutImage is the pod template annotation of a workload restarted to roll out the sidecar image it is set to.
+	RolloutImage = "pvc-autoscaler-operator.kubernetes.io/rollout-image"
+	// TargetedBy is the pod template annotation of a workload annotated by a PodDiskInspector's targetRefs.
+	// Its value is the namespace/name of the PodDiskInspector.
+	TargetedBy = "pvc-autoscaler-operator.kubernetes.io/targeted-by"
+	// PodSecurityEnforce is the namespace label with the Pod Security Standards level enforced by pod security admission.
+	PodSecurityEnforce = "pod-security.kubernetes.io/enforce"
 )
 
 // Fields.
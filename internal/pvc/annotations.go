@@ -0,0 +1,98 @@
+package pvc
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/api/v1alpha1"
+)
+
+const UsedSpacePercentage = "pvc-autoscaler-operator.kubernetes.io/used-space-percentage"
+const IncreaseQuantity = "pvc-autoscaler-operator.kubernetes.io/increase-quantity"
+const Cooldown = "pvc-autoscaler-operator.kubernetes.io/cooldown"
+const MaxSize = "pvc-autoscaler-operator.kubernetes.io/max-size"
+
+// SpecAnnotations are the annotations of pods and PVCs overriding the v1alpha1.PVCScalingSpec.
+var SpecAnnotations = []string{UsedSpacePercentage, IncreaseQuantity, Cooldown, MaxSize}
+
+// AnnotationError is a malformed annotation.
+type AnnotationError struct {
+	Key   string
+	Value string
+	Err   error
+}
+
+func (e *AnnotationError) Error() string {
+	return fmt.Sprintf("annotation %s=%q: %v", e.Key, e.Value, e.Err)
+}
+
+func (e *AnnotationError) Unwrap() error { return e.Err }
+
+// OverideSpec overrides defaultSpec with the annotations of a pod or PVC.
+// Malformed annotations are skipped, keeping the value of defaultSpec, and returned as joined *AnnotationErrors.
+// A nil defaultSpec is returned as is, since there is nothing to override.
+func OverideSpec(defaultSpec *v1alpha1.PVCScalingSpec, annotations map[string]string) (*v1alpha1.PVCScalingSpec, error) {
+	if defaultSpec == nil {
+		return nil, nil
+	}
+	var merr error
+	for _, key := range SpecAnnotations {
+		val, ok := annotations[key]
+		if !ok || val == "" {
+			continue
+		}
+		if err := overrideSpec(defaultSpec, key, val); err != nil {
+			merr = errors.Join(merr, &AnnotationError{Key: key, Value: val, Err: err})
+		}
+	}
+	return defaultSpec, merr
+}
+
+// ValidateSpecAnnotations returns joined *AnnotationErrors for the malformed SpecAnnotations.
+func ValidateSpecAnnotations(annotations map[string]string) error {
+	_, err := OverideSpec(&v1alpha1.PVCScalingSpec{}, annotations)
+	return err
+}
+
+func overrideSpec(spec *v1alpha1.PVCScalingSpec, key, val string) error {
+	switch key {
+	case UsedSpacePercentage:
+		num, err := strconv.ParseInt(val, 10, 32)
+		if err != nil {
+			return errors.New("must be an integer")
+		}
+		if num < 1 || num > 100 {
+			return errors.New("must be between 1 and 100")
+		}
+		spec.UsedSpacePercentage = int32(num)
+	case IncreaseQuantity:
+		if err := ValidateIncreaseQuantity(val); err != nil {
+			return err
+		}
+		spec.IncreaseQuantity = val
+	case Cooldown:
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			return errors.New("must be a duration (e.g. 6h)")
+		}
+		if d < 0 {
+			return errors.New("must not be negative")
+		}
+		spec.Cooldown = v1.Duration{Duration: d}
+	case MaxSize:
+		q, err := resource.ParseQuantity(val)
+		if err != nil {
+			return errors.New("must be a storage quantity (e.g. 2000Gi)")
+		}
+		if q.Sign() < 0 {
+			return errors.New("must not be negative")
+		}
+		spec.MaxSize = q
+	}
+	return nil
+}
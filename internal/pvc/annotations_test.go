@@ -0,0 +1,95 @@
+package pvc
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/api/v1alpha1"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestOverideSpec(t *testing.T) {
+	t.Parallel()
+
+	defaultSpec := func() *v1alpha1.PVCScalingSpec {
+		return &v1alpha1.PVCScalingSpec{
+			UsedSpacePercentage: 80,
+			IncreaseQuantity:    "20%",
+			Cooldown:            metav1.Duration{Duration: time.Hour},
+			MaxSize:             resource.MustParse("100Gi"),
+		}
+	}
+
+	t.Run("happy path", func(t *testing.T) {
+		spec, err := OverideSpec(defaultSpec(), map[string]string{
+			UsedSpacePercentage: "90",
+			IncreaseQuantity:    "10Gi",
+			Cooldown:            "30m",
+			MaxSize:             "2Ti",
+		})
+
+		require.NoError(t, err)
+		require.EqualValues(t, 90, spec.UsedSpacePercentage)
+		require.Equal(t, "10Gi", spec.IncreaseQuantity)
+		require.Equal(t, 30*time.Minute, spec.Cooldown.Duration)
+		require.Equal(t, "2Ti", spec.MaxSize.String())
+	})
+
+	t.Run("invalid annotations", func(t *testing.T) {
+		var spec *v1alpha1.PVCScalingSpec
+		var err error
+		require.NotPanics(t, func() {
+			spec, err = OverideSpec(defaultSpec(), map[string]string{
+				UsedSpacePercentage: "101",
+				IncreaseQuantity:    "twenty",
+				Cooldown:            "forever",
+				MaxSize:             "lots",
+			})
+		})
+
+		require.Equal(t, defaultSpec(), spec)
+		require.Error(t, err)
+		for _, key := range SpecAnnotations {
+			require.Contains(t, err.Error(), key)
+		}
+		var annotationErr *AnnotationError
+		require.True(t, errors.As(err, &annotationErr))
+	})
+
+	t.Run("partially invalid", func(t *testing.T) {
+		spec, err := OverideSpec(defaultSpec(), map[string]string{
+			UsedSpacePercentage: "ninety",
+			Cooldown:            "10m",
+		})
+
+		require.Error(t, err)
+		require.EqualValues(t, 80, spec.UsedSpacePercentage)
+		require.Equal(t, 10*time.Minute, spec.Cooldown.Duration)
+	})
+
+	t.Run("nil spec", func(t *testing.T) {
+		spec, err := OverideSpec(nil, map[string]string{MaxSize: "lots"})
+
+		require.NoError(t, err)
+		require.Nil(t, spec)
+	})
+}
+
+func TestValidateSpecAnnotations(t *testing.T) {
+	t.Parallel()
+
+	require.NoError(t, ValidateSpecAnnotations(nil))
+	require.NoError(t, ValidateSpecAnnotations(map[string]string{Cooldown: "0s", MaxSize: "0", "other": "value"}))
+
+	for key, val := range map[string]string{
+		UsedSpacePercentage: "0",
+		IncreaseQuantity:    "0%",
+		Cooldown:            "-1h",
+		MaxSize:             "-1Gi",
+	} {
+		require.Error(t, ValidateSpecAnnotations(map[string]string{key: val}), key)
+	}
+}
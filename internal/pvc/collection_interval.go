@@ -0,0 +1,79 @@
+package pvc
+
+import (
+	"math"
+	"time"
+
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/api/v1alpha1"
+	"github.com/samber/lo"
+)
+
+// Defaults for v1alpha1.CollectionSpec.
+const (
+	DefaultCollectionInterval      = 60 * time.Second
+	DefaultMinCollectionInterval   = 15 * time.Second
+	DefaultMaxCollectionInterval   = 5 * time.Minute
+	DefaultNearThresholdPercentage = 10
+	DefaultJitterPercentage        = 10
+)
+
+// CollectionInterval returns how long to wait before collecting disk usage again.
+//
+// Without adaptive collection, or without any usage, it returns the spec's interval.
+// With adaptive collection, the PVC closest to its scaling threshold decides the interval:
+// MinInterval when within NearThresholdPercentage of the threshold, growing linearly up to
+// MaxInterval as the PVC approaches empty.
+//
+// The result is jittered by JitterPercentage. Rnd must return a number in [0.0,1.0).
+func CollectionInterval(spec *v1alpha1.CollectionSpec, usage []PVCDiskUsage, rnd func() float64) time.Duration {
+	var collection v1alpha1.CollectionSpec
+	if spec != nil {
+		collection = *spec
+	}
+
+	interval := lo.Ternary(collection.Interval.Duration > 0, collection.Interval.Duration, DefaultCollectionInterval)
+	if collection.Adaptive != nil {
+		if d, ok := adaptiveInterval(*collection.Adaptive, usage); ok {
+			interval = d
+		}
+	}
+
+	jitter := float64(DefaultJitterPercentage)
+	if collection.JitterPercentage != nil {
+		jitter = float64(*collection.JitterPercentage)
+	}
+	factor := 1 + (jitter/100)*(2*rnd()-1)
+	return time.Duration(float64(interval) * factor)
+}
+
+func adaptiveInterval(spec v1alpha1.AdaptiveCollectionSpec, usage []PVCDiskUsage) (time.Duration, bool) {
+	var (
+		minInterval = lo.Ternary(spec.MinInterval.Duration > 0, spec.MinInterval.Duration, DefaultMinCollectionInterval)
+		maxInterval = lo.Ternary(spec.MaxInterval.Duration > 0, spec.MaxInterval.Duration, DefaultMaxCollectionInterval)
+		near        = lo.Ternary(spec.NearThresholdPercentage > 0, int(spec.NearThresholdPercentage), DefaultNearThresholdPercentage)
+	)
+	if maxInterval < minInterval {
+		maxInterval = minInterval
+	}
+
+	// Fraction of the way from MinInterval to MaxInterval. The closest PVC wins.
+	fraction, found := 1.0, false
+	for _, u := range usage {
+		if u.PVCScalingSpec == nil {
+			continue
+		}
+		found = true
+		threshold := int(u.PVCScalingSpec.UsedSpacePercentage)
+		headroom := threshold - u.PercentUsed
+		if headroom <= near || threshold <= near {
+			fraction = 0
+			break
+		}
+		fraction = math.Min(fraction, float64(headroom-near)/float64(threshold-near))
+	}
+	if !found {
+		return 0, false
+	}
+
+	return minInterval + time.Duration(fraction*float64(maxInterval-minInterval)), true
+}
@@ -0,0 +1,85 @@
+package pvc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/api/v1alpha1"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCollectionInterval(t *testing.T) {
+	t.Parallel()
+
+	var (
+		noJitter   = func() float64 { return 0.5 }
+		zeroJitter = ptr(int32(0))
+		scaling    = &v1alpha1.PVCScalingSpec{UsedSpacePercentage: 80}
+	)
+
+	usage := func(percents ...int) []PVCDiskUsage {
+		var found []PVCDiskUsage
+		for _, p := range percents {
+			found = append(found, PVCDiskUsage{PercentUsed: p, PVCScalingSpec: scaling})
+		}
+		return found
+	}
+
+	t.Run("default", func(t *testing.T) {
+		require.Equal(t, 60*time.Second, CollectionInterval(nil, usage(50), noJitter))
+	})
+
+	t.Run("fixed interval", func(t *testing.T) {
+		spec := &v1alpha1.CollectionSpec{Interval: metav1.Duration{Duration: 2 * time.Minute}}
+		require.Equal(t, 2*time.Minute, CollectionInterval(spec, usage(50), noJitter))
+	})
+
+	t.Run("jitter", func(t *testing.T) {
+		spec := &v1alpha1.CollectionSpec{Interval: metav1.Duration{Duration: 100 * time.Second}}
+
+		require.Equal(t, 90*time.Second, CollectionInterval(spec, nil, func() float64 { return 0 }))
+		require.Equal(t, 100*time.Second, CollectionInterval(spec, nil, noJitter))
+		require.Equal(t, 105*time.Second, CollectionInterval(spec, nil, func() float64 { return 0.75 }))
+
+		spec.JitterPercentage = ptr(int32(50))
+		require.Equal(t, 50*time.Second, CollectionInterval(spec, nil, func() float64 { return 0 }))
+	})
+
+	t.Run("adaptive", func(t *testing.T) {
+		spec := &v1alpha1.CollectionSpec{
+			Interval: metav1.Duration{Duration: time.Minute},
+			Adaptive: &v1alpha1.AdaptiveCollectionSpec{
+				MinInterval:             metav1.Duration{Duration: 10 * time.Second},
+				MaxInterval:             metav1.Duration{Duration: 610 * time.Second},
+				NearThresholdPercentage: 20,
+			},
+			JitterPercentage: zeroJitter,
+		}
+
+		for _, tt := range []struct {
+			Usage []PVCDiskUsage
+			Want  time.Duration
+		}{
+			{nil, time.Minute},
+			{[]PVCDiskUsage{{PercentUsed: 10}}, time.Minute},
+			{usage(0), 610 * time.Second},
+			{usage(30), 310 * time.Second},
+			{usage(60), 10 * time.Second},
+			{usage(95), 10 * time.Second},
+			{usage(0, 30, 45), 160 * time.Second},
+		} {
+			require.Equal(t, tt.Want, CollectionInterval(spec, tt.Usage, noJitter), tt)
+		}
+	})
+
+	t.Run("adaptive defaults", func(t *testing.T) {
+		spec := &v1alpha1.CollectionSpec{
+			Adaptive:         &v1alpha1.AdaptiveCollectionSpec{},
+			JitterPercentage: zeroJitter,
+		}
+
+		require.Equal(t, DefaultMaxCollectionInterval, CollectionInterval(spec, usage(0), noJitter))
+		require.Equal(t, DefaultMinCollectionInterval, CollectionInterval(spec, usage(75), noJitter))
+	})
+}
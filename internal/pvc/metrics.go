@@ -0,0 +1,35 @@
+package pvc
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// Metrics for disk usage collection, labelled by the PodDiskInspector's NamespacedName.
+var (
+	collectionCycleDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "pvc_autoscaler_disk_usage_collection_duration_seconds",
+		Help:    "Duration of a disk usage collection cycle across all pods of a PodDiskInspector.",
+		Buckets: []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"inspector"})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "pvc_autoscaler_disk_usage_request_duration_seconds",
+		Help:    "Duration of a single disk usage request to a healthcheck sidecar, including retries.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"inspector"})
+
+	requestRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pvc_autoscaler_disk_usage_request_retries_total",
+		Help: "Number of disk usage requests to healthcheck sidecars which were retried.",
+	}, []string{"inspector"})
+
+	requestFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "pvc_autoscaler_disk_usage_request_failures_total",
+		Help: "Number of disk usage requests to healthcheck sidecars which failed after all retries.",
+	}, []string{"inspector"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(collectionCycleDuration, requestDuration, requestRetries, requestFailures)
+}
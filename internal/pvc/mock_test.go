@@ -87,6 +87,8 @@ func (m *mockClient[T]) Get(ctx context.Context, key client.ObjectKey, obj clien
 	switch ref := obj.(type) {
 	case *corev1.PersistentVolumeClaim:
 		*ref = m.Object.(corev1.PersistentVolumeClaim)
+	case *corev1.Pod:
+		*ref = m.Object.(corev1.Pod)
 	case *v1alpha1.PodDiskInspector:
 		*ref = m.Object.(v1alpha1.PodDiskInspector)
 	default:
@@ -293,7 +295,7 @@ func (b mockPodBuilder) WithOrdinalBuild(ordinal int32) (*corev1.Pod, error) {
 	pod.Spec.Containers[0].VolumeMounts = mounts
 
 	// Inject healthcheck sidecar
-	sidecar, err := inject.Sidecar(pod, b.crd.Spec.SidecarImage)
+	sidecar, err := inject.Sidecar(pod, inject.Options{Image: b.crd.Spec.SidecarImage})
 	if err != nil {
 		return nil, err
 	}
@@ -16,6 +16,12 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+// Defaults for v1alpha1.PVCScalingSpec.
+const (
+	DefaultUsedSpacePercentage = 80
+	DefaultIncreaseQuantity    = "20%"
+)
+
 // Client is a controller client. It is a subset of client.Client.
 type Client interface {
 	client.Reader
@@ -23,9 +29,13 @@ type Client interface {
 	client.StatusClient
 }
 
+// ResizeHook is called after a PVC is patched to newSize.
+type ResizeHook func(ctx context.Context, usage PVCDiskUsage, newSize resource.Quantity)
+
 type PVCAutoScaler struct {
-	client Client
-	now    func() time.Time
+	client   Client
+	now      func() time.Time
+	onResize ResizeHook
 }
 
 func NewPVCAutoScaler(client Client) *PVCAutoScaler {
@@ -35,10 +45,19 @@ func NewPVCAutoScaler(client Client) *PVCAutoScaler {
 	}
 }
 
+// WithResizeHook returns a copy of the PVCAutoScaler which calls hook after every successful resize.
+func (scaler PVCAutoScaler) WithResizeHook(hook ResizeHook) *PVCAutoScaler {
+	scaler.onResize = hook
+	return &scaler
+}
+
 // ProcessPVCResize patches the PVC request storage size and update annotation for resize time
 //
 // Returns true if the status was patched.
 //
+// A PVC is resized if it reached its usage threshold or the application requested a larger size through the sidecar,
+// whichever results in the larger size.
+//
 // Returns false and does not patch if:
 // 1. The PVCs do not need resizing
 // 2. The status already has >= calculated size.
@@ -57,14 +76,23 @@ func (scaler PVCAutoScaler) ProcessPVCResize(ctx context.Context, crd *v1alpha1.
 		if pvcCandidate.PVCScalingSpec == nil {
 			continue
 		}
-		if pvcCandidate.PercentUsed < int(pvcCandidate.PVCScalingSpec.UsedSpacePercentage) {
+		atThreshold := pvcCandidate.PercentUsed >= int(pvcCandidate.PVCScalingSpec.UsedSpacePercentage)
+		if !atThreshold && pvcCandidate.RequestedSize.IsZero() {
 			continue
 		}
 
-		// Calc new size first to catch errors with the increase quantity
-		newSize, err := scaler.calcNextCapacity(pvcCandidate.Capacity, pvcCandidate.PVCScalingSpec.IncreaseQuantity)
-		if err != nil {
-			merr = errors.Join(merr, fmt.Errorf("increaseQuantity must be a percentage string (e.g. 10%%) or a storage quantity (e.g. 100Gi): %w", err))
+		var newSize resource.Quantity
+		if atThreshold {
+			// Calc new size first to catch errors with the increase quantity
+			var err error
+			newSize, err = scaler.calcNextCapacity(pvcCandidate.Capacity, pvcCandidate.PVCScalingSpec.IncreaseQuantity)
+			if err != nil {
+				merr = errors.Join(merr, fmt.Errorf("increaseQuantity must be a percentage string (e.g. 10%%) or a storage quantity (e.g. 100Gi): %w", err))
+			}
+		}
+		requested := pvcCandidate.RequestedSize.Cmp(newSize) > 0
+		if requested {
+			newSize = pvcCandidate.RequestedSize.DeepCopy()
 		}
 
 		// Handle max size
@@ -101,7 +129,11 @@ func (scaler PVCAutoScaler) ProcessPVCResize(ctx context.Context, crd *v1alpha1.
 			}
 		}
 
-		reporter.Info("Patching pvc", "pvc", pvcCandidate.Name, "namespace", pvcCandidate.Namespace, "newSize", newSize.String())
+		reporter.Info("Patching pvc", "pvc", pvcCandidate.Name, "namespace", pvcCandidate.Namespace, "newSize", newSize.String(), "requested", requested)
+		if requested {
+			reporter.RecordInfo("PVCAutoScaleRequested", fmt.Sprintf("Resizing %s/%s to %s as requested by the application: %s",
+				pvcCandidate.Namespace, pvcCandidate.Name, newSize.String(), pvcCandidate.RequestReason))
+		}
 
 		currentRequests := pvcCandidate.pvc.Spec.Resources.Requests
 		currentRequests[corev1.ResourceStorage] = newSize
@@ -121,6 +153,9 @@ func (scaler PVCAutoScaler) ProcessPVCResize(ctx context.Context, crd *v1alpha1.
 			continue
 		}
 		reporter.Info("PVC patch succeeded", "pvc", pvcCandidate.Name, "namespace", pvcCandidate.Namespace, "newSize", newSize.String())
+		if scaler.onResize != nil {
+			scaler.onResize(ctx, pvcCandidate, newSize)
+		}
 
 		pvcCandidates[key.String()] = v1alpha1.ScalingStatus{
 			RequestedSize: newSize,
@@ -150,6 +185,20 @@ func (scaler PVCAutoScaler) ProcessPVCResize(ctx context.Context, crd *v1alpha1.
 	return merr
 }
 
+// ValidateIncreaseQuantity returns an error if increase, the v1alpha1.PVCScalingSpec IncreaseQuantity, is neither a
+// percentage (e.g. 20%) nor a storage quantity (e.g. 100Gi), or would not increase a PVC's capacity.
+func ValidateIncreaseQuantity(increase string) error {
+	current := resource.MustParse("100Gi")
+	next, err := PVCAutoScaler{}.calcNextCapacity(current, increase)
+	if err != nil {
+		return fmt.Errorf("must be a percentage string (e.g. 10%%) or a storage quantity (e.g. 100Gi): %w", err)
+	}
+	if next.Cmp(current) <= 0 {
+		return errors.New("must increase the capacity")
+	}
+	return nil
+}
+
 func (scaler PVCAutoScaler) calcNextCapacity(current resource.Quantity, increase string) (resource.Quantity, error) {
 	var (
 		merr     error
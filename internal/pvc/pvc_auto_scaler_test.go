@@ -279,4 +279,102 @@ func TestProcessPVCResize(t *testing.T) {
 
 		require.Nil(t, reader.LastPatchObject)
 	})
+	t.Run("calls resize hook", func(t *testing.T) {
+		var reader mockReader
+		capacity := resource.MustParse("100Gi")
+
+		var crd v1alpha1.PodDiskInspector
+		crd.Name = "auto-scale-test"
+		crd.Namespace = "default"
+		crd.Spec.PVCScaling = &v1alpha1.PVCScalingSpec{UsedSpacePercentage: 80, IncreaseQuantity: "20Gi"}
+
+		usage := []PVCDiskUsage{
+			{
+				Name:           "pvc-0",
+				Namespace:      "default",
+				Capacity:       capacity,
+				PercentUsed:    90,
+				PVCScalingSpec: crd.Spec.PVCScaling,
+				pvc: &corev1.PersistentVolumeClaim{
+					ObjectMeta: metav1.ObjectMeta{Name: "pvc-0", Namespace: "default"},
+					Spec: corev1.PersistentVolumeClaimSpec{
+						Resources: corev1.ResourceRequirements{
+							Requests: corev1.ResourceList{corev1.ResourceStorage: capacity},
+						},
+					},
+				},
+			},
+		}
+		reader.Object = crd
+
+		var resized []string
+		scaler := NewPVCAutoScaler(&reader).WithResizeHook(func(_ context.Context, usage PVCDiskUsage, newSize resource.Quantity) {
+			resized = append(resized, usage.Name+"="+newSize.String())
+		})
+
+		err := scaler.ProcessPVCResize(ctx, &crd, usage, nopReporter)
+
+		require.NoError(t, err)
+		require.Equal(t, []string{"pvc-0=120Gi"}, resized)
+	})
+
+	t.Run("resizes on application request", func(t *testing.T) {
+		var reader mockReader
+		capacity := resource.MustParse("100Gi")
+
+		var crd v1alpha1.PodDiskInspector
+		crd.Name = "auto-scale-test"
+		crd.Namespace = "default"
+		crd.Spec.PVCScaling = &v1alpha1.PVCScalingSpec{UsedSpacePercentage: 80, IncreaseQuantity: "20Gi", MaxSize: resource.MustParse("500Gi")}
+
+		candidate := func(name string, percentUsed int, requested string) PVCDiskUsage {
+			usage := PVCDiskUsage{
+				Name:           name,
+				Namespace:      "default",
+				Capacity:       capacity,
+				PercentUsed:    percentUsed,
+				PVCScalingSpec: crd.Spec.PVCScaling,
+				pvc: &corev1.PersistentVolumeClaim{
+					ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+					Spec: corev1.PersistentVolumeClaimSpec{
+						Resources: corev1.ResourceRequirements{
+							Requests: corev1.ResourceList{corev1.ResourceStorage: capacity},
+						},
+					},
+				},
+			}
+			if requested != "" {
+				usage.RequestedSize = resource.MustParse(requested)
+			}
+			return usage
+		}
+		usage := []PVCDiskUsage{
+			candidate("below-threshold", 10, "150Gi"),
+			candidate("larger-increase", 90, "110Gi"),
+			candidate("exceeds-max", 10, "600Gi"),
+			candidate("no-request", 10, ""),
+		}
+		reader.Object = crd
+
+		var resized []string
+		scaler := NewPVCAutoScaler(&reader).WithResizeHook(func(_ context.Context, usage PVCDiskUsage, newSize resource.Quantity) {
+			resized = append(resized, usage.Name+"="+newSize.String())
+		})
+
+		err := scaler.ProcessPVCResize(ctx, &crd, usage, nopReporter)
+
+		require.NoError(t, err)
+		require.Equal(t, []string{"below-threshold=150Gi", "larger-increase=120Gi", "exceeds-max=500Gi"}, resized)
+	})
+}
+
+func TestValidateIncreaseQuantity(t *testing.T) {
+	t.Parallel()
+
+	for _, increase := range []string{"20%", "150%", "100Gi", "512Mi"} {
+		require.NoError(t, ValidateIncreaseQuantity(increase), increase)
+	}
+	for _, increase := range []string{"", "20 %", "0%", "-10%", "0", "-1Gi", "twenty"} {
+		require.Error(t, ValidateIncreaseQuantity(increase), increase)
+	}
 }
@@ -5,25 +5,22 @@ import (
 	"errors"
 	"fmt"
 	"math"
-	"strconv"
+	"sort"
 	"time"
 
 	"github.com/allthatjazzleo/pvc-autoscaler-operator/api/v1alpha1"
 	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/healthcheck"
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/inject"
 	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/kube"
 	"github.com/samber/lo"
 	"golang.org/x/sync/errgroup"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
-	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
-const UsedSpacePercentage = "pvc-autoscaler-operator.kubernetes.io/used-space-percentage"
-const IncreaseQuantity = "pvc-autoscaler-operator.kubernetes.io/increase-quantity"
-const Cooldown = "pvc-autoscaler-operator.kubernetes.io/cooldown"
-const MaxSize = "pvc-autoscaler-operator.kubernetes.io/max-size"
-
 var ErrNoPodsFound = errors.New("no pods found")
 
 // DiskUsager fetches disk usage statistics
@@ -31,31 +28,174 @@ type DiskUsager interface {
 	DiskUsage(ctx context.Context, host string) ([]healthcheck.DiskUsageResponse, error)
 }
 
+// MaxSamplesPerPVC is the maximum number of pods queried for a PVC mounted by several pods,
+// e.g. a ReadWriteMany volume. All pods mounting the PVC report the same filesystem, so a few samples suffice.
+const MaxSamplesPerPVC = 3
+
+// DivergenceThreshold is the spread in percentage points between samples of the same PVC
+// above which the samples are considered divergent.
+const DivergenceThreshold = 5
+
+// DiskUsageSample is a single disk usage reading of a PVC reported by a pod.
+type DiskUsageSample struct {
+	Pod         string // name of the pod that reported the sample
+	Node        string // node the pod was scheduled on
+	PercentUsed int
+	// Logical is true if PercentUsed is based on the usage reported by the application's usage probe.
+	Logical bool
+}
+
 type PVCDiskUsage struct {
 	Name           string // pvc name
 	Namespace      string // pvc namespace
 	PercentUsed    int
 	Capacity       resource.Quantity
 	PVCScalingSpec *v1alpha1.PVCScalingSpec
-	pvc            *corev1.PersistentVolumeClaim
+	// Samples lists every reading of the PVC this cycle. PercentUsed is the maximum of the samples.
+	Samples []DiskUsageSample
+	// Divergent is true if the samples disagree by more than DivergenceThreshold percentage points.
+	Divergent bool
+	// GrowthBytesPerSecond and TimeToFull are the highest trend reported by the sidecars sampling the PVC.
+	// Zero if no sidecar reports a trend, e.g. older sidecars.
+	GrowthBytesPerSecond float64
+	TimeToFull           time.Duration
+	// RequestedSize is the capacity requested by the application through the sidecar, see healthcheck.ResizeRequest.
+	// Zero if there is no pending request or the PVC already satisfies it.
+	RequestedSize resource.Quantity
+	// RequestReason is the reason given by the application for RequestedSize.
+	RequestReason string
+	pvc           *corev1.PersistentVolumeClaim
+}
+
+// podSample is a raw disk usage response and the pod which reported it.
+type podSample struct {
+	pod  *corev1.Pod
+	resp healthcheck.DiskUsageResponse
 }
 
+// CollectorOptions configures how disk usage is collected from the healthcheck sidecars.
+type CollectorOptions struct {
+	// Workers is the maximum number of sidecars queried concurrently.
+	Workers int
+	// RequestTimeout bounds a single request to a sidecar.
+	RequestTimeout time.Duration
+	// Retries is how many times a request failing with a transient error is retried.
+	Retries int
+	// RetryBackoff is the delay before the first retry. It doubles with every retry.
+	RetryBackoff time.Duration
+	// Deadline bounds a whole collection cycle. Sidecars not queried by then are reported as failed.
+	Deadline time.Duration
+}
+
+// DefaultCollectorOptions returns the default CollectorOptions.
+func DefaultCollectorOptions() CollectorOptions {
+	return CollectorOptions{
+		Workers:        50,
+		RequestTimeout: 10 * time.Second,
+		Retries:        2,
+		RetryBackoff:   500 * time.Millisecond,
+		Deadline:       60 * time.Second,
+	}
+}
+
+// PodLister returns the pods whose PVCs are inspected by crd.
+type PodLister func(ctx context.Context, crd *v1alpha1.PodDiskInspector) ([]corev1.Pod, error)
+
 type DiskUsageCollector struct {
 	diskClient DiskUsager
 	client     client.Reader
+	opts       CollectorOptions
+	recorder   record.EventRecorder
+	listPods   PodLister
+}
+
+func NewDiskUsageCollector(diskClient DiskUsager, lister client.Reader, opts CollectorOptions) *DiskUsageCollector {
+	return &DiskUsageCollector{diskClient: diskClient, client: lister, opts: opts}
 }
 
-func NewDiskUsageCollector(diskClient DiskUsager, lister client.Reader) *DiskUsageCollector {
-	return &DiskUsageCollector{diskClient: diskClient, client: lister}
+// WithRecorder returns a copy of the collector emitting warning events on pods and PVCs with malformed annotations.
+func (c *DiskUsageCollector) WithRecorder(recorder record.EventRecorder) *DiskUsageCollector {
+	cp := *c
+	cp.recorder = recorder
+	return &cp
+}
+
+// WithPodLister returns a copy of the collector listing the pods of an inspector with listPods instead of the pods
+// annotated for it.
+func (c *DiskUsageCollector) WithPodLister(listPods PodLister) *DiskUsageCollector {
+	cp := *c
+	cp.listPods = listPods
+	return &cp
 }
 
 // CollectDiskUsage retrieves the disk usage information for all pods has
 // "pvc-autoscaler-operator.kubernetes.io/enabled" annotation set to "true",
 // "pvc-autoscaler-operator.kubernetes.io/operator-name" annotation set to the name of the operator and
-// "pvc-autoscaler-operator.kubernetes.io/operator-namespace" annotation set to the namespace of the operator.=
+// "pvc-autoscaler-operator.kubernetes.io/operator-namespace" annotation set to the namespace of the operator,
+// or the pods returned by the PodLister, see WithPodLister.
+// PVCs mounted by several pods are only queried through up to MaxSamplesPerPVC pods, falling back to the other
+// pods mounting them if those fail.
+// PVCs excluded by the inspector's or the pod's volume filter are skipped, see inject.PodVolumeFilter.
 // It returns a slice of PVCDiskUsage objects representing the disk usage information for each PVC or an error
 // if fetching disk usage via all pods was unsuccessful.
 func (c DiskUsageCollector) CollectDiskUsage(ctx context.Context, crd *v1alpha1.PodDiskInspector) ([]PVCDiskUsage, error) {
+	fieldValue := client.ObjectKey{Name: crd.Name, Namespace: crd.Namespace}
+	pods, err := c.pods(ctx, crd)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(pods) == 0 {
+		return nil, ErrNoPodsFound
+	}
+
+	selected, rest := selectPods(pods)
+
+	var filter inject.VolumeFilter
+	if crd.Spec.Volumes != nil {
+		filter = inject.VolumeFilter{Include: crd.Spec.Volumes.Include, Exclude: crd.Spec.Volumes.Exclude}
+	}
+
+	inspector := fieldValue.String()
+	start := time.Now()
+	defer func() { collectionCycleDuration.WithLabelValues(inspector).Observe(time.Since(start).Seconds()) }()
+
+	if c.opts.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.opts.Deadline)
+		defer cancel()
+	}
+
+	found, errs := c.queryPods(ctx, inspector, filter, selected)
+	// PVCs whose selected pods all failed are sampled through the remaining pods mounting them.
+	if fallback := fallbackPods(rest, selected, found, errs); len(fallback) > 0 {
+		moreFound, moreErrs := c.queryPods(ctx, inspector, filter, fallback)
+		found, errs = append(found, moreFound...), append(errs, moreErrs...)
+	}
+
+	failed := lo.Filter(errs, func(item error, _ int) bool {
+		return item != nil
+	})
+	if len(failed) == len(errs) {
+		return nil, errors.Join(failed...)
+	}
+
+	usage, err := c.aggregate(ctx, crd, lo.Flatten(found))
+	if len(usage) == 0 && err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+// pods returns the pods of crd using the PodLister, if any, or the pods annotated for crd.
+func (c DiskUsageCollector) pods(ctx context.Context, crd *v1alpha1.PodDiskInspector) ([]corev1.Pod, error) {
+	if c.listPods != nil {
+		pods, err := c.listPods(ctx, crd)
+		if err != nil {
+			return nil, fmt.Errorf("list pods: %w", err)
+		}
+		return pods, nil
+	}
 	var pods corev1.PodList
 	fieldValue := client.ObjectKey{Name: crd.Name, Namespace: crd.Namespace}
 	if err := c.client.List(ctx, &pods,
@@ -63,95 +203,306 @@ func (c DiskUsageCollector) CollectDiskUsage(ctx context.Context, crd *v1alpha1.
 	); err != nil {
 		return nil, fmt.Errorf("list pods: %w", err)
 	}
+	return pods.Items, nil
+}
 
-	if len(pods.Items) == 0 {
-		return nil, ErrNoPodsFound
-	}
-
+// queryPods queries the sidecars of pods concurrently. It returns the samples and the error of each pod.
+func (c DiskUsageCollector) queryPods(ctx context.Context, inspector string, filter inject.VolumeFilter, pods []*corev1.Pod) ([][]podSample, []error) {
 	var (
-		found = make([][]PVCDiskUsage, len(pods.Items))
-		errs  = make([]error, len(pods.Items))
+		found = make([][]podSample, len(pods))
+		errs  = make([]error, len(pods))
 		eg    errgroup.Group
 	)
+	if c.opts.Workers > 0 {
+		eg.SetLimit(c.opts.Workers)
+	}
 
-	for i := range pods.Items {
+	for i := range pods {
 		i := i
 		eg.Go(func() error {
-			pod := pods.Items[i]
-			cctx, cancel := context.WithTimeout(ctx, 10*time.Second)
-			defer cancel()
-			resp, err := c.diskClient.DiskUsage(cctx, "http://"+pod.Status.PodIP)
-			if err != nil {
+			pod := pods[i]
+			if err := ctx.Err(); err != nil {
+				requestFailures.WithLabelValues(inspector).Inc()
+				errs[i] = fmt.Errorf("pod %s: collection deadline: %w", pod.Name, err)
+				return nil
+			}
+			resp, err := c.diskUsage(healthcheck.WithNamespace(ctx, pod.Namespace), inspector, "http://"+pod.Status.PodIP)
+			if isPartial(resp, err) {
+				// The usage of the volumes the sidecar could check is still valid.
+				log.FromContext(ctx).Info("Sidecar failed to check some volumes", "pod", pod.Name, "namespace", pod.Namespace, "error", err.Error())
+			} else if err != nil {
 				errs[i] = fmt.Errorf("pod %s: %w", pod.Name, err)
 				return nil
 			}
-
-			nestedErr := make([]error, len(resp))
+			podFilter := inject.PodVolumeFilter(filter, pod.Annotations)
 			for _, diskUsageResponse := range resp {
-				name := diskUsageResponse.PvcName
-				namespace := pod.Namespace
-				defaultSpec := crd.Spec.PVCScaling.DeepCopy()
-
-				// Find matching PVC to capture its actual capacity
-				key := client.ObjectKey{Namespace: namespace, Name: name}
-				var pvc corev1.PersistentVolumeClaim
-				if err = c.client.Get(ctx, key, &pvc); err != nil {
-					nestedErr = append(nestedErr, fmt.Errorf("get pvc %s: %w", key, err))
+				// Sidecars injected before a volume was excluded still report it.
+				if !podFilter.MonitorsClaim(pod, diskUsageResponse.PvcName) {
 					continue
 				}
+				found[i] = append(found[i], podSample{pod: pod, resp: diskUsageResponse})
+			}
+			return nil
+		})
+	}
 
-				// override default spec with pod annoations if present
-				OverideSpec(defaultSpec, pod.GetAnnotations())
+	_ = eg.Wait()
+	return found, errs
+}
 
-				// override default spec with pvc annoations if present
-				OverideSpec(defaultSpec, pvc.GetAnnotations())
+// diskUsage queries a single sidecar, retrying transient errors with exponential backoff.
+func (c DiskUsageCollector) diskUsage(ctx context.Context, inspector, host string) ([]healthcheck.DiskUsageResponse, error) {
+	start := time.Now()
+	defer func() { requestDuration.WithLabelValues(inspector).Observe(time.Since(start).Seconds()) }()
 
-				item := PVCDiskUsage{
-					Name:           name,
-					Namespace:      namespace,
-					PercentUsed:    int(math.Round((float64(diskUsageResponse.AllBytes-diskUsageResponse.FreeBytes) / float64(diskUsageResponse.AllBytes)) * 100)),
-					Capacity:       pvc.Status.Capacity[corev1.ResourceStorage],
-					PVCScalingSpec: defaultSpec,
-					pvc:            &pvc,
-				}
-				found[i] = append(found[i], item)
+	backoff := c.opts.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		resp, err := c.diskUsageOnce(ctx, host)
+		if err == nil || isPartial(resp, err) {
+			return resp, err
+		}
+		if attempt >= c.opts.Retries || !isTransient(ctx, err) {
+			requestFailures.WithLabelValues(inspector).Inc()
+			return resp, err
+		}
+
+		requestRetries.WithLabelValues(inspector).Inc()
+		select {
+		case <-ctx.Done():
+			requestFailures.WithLabelValues(inspector).Inc()
+			return resp, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (c DiskUsageCollector) diskUsageOnce(ctx context.Context, host string) ([]healthcheck.DiskUsageResponse, error) {
+	if c.opts.RequestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.opts.RequestTimeout)
+		defer cancel()
+	}
+	return c.diskClient.DiskUsage(ctx, host)
+}
+
+// isPartial returns true if the sidecar reported usage of some volumes but failed to check others.
+func isPartial(resp []healthcheck.DiskUsageResponse, err error) bool {
+	var verr *healthcheck.VolumeError
+	return len(resp) > 0 && errors.As(err, &verr)
+}
+
+// isTransient returns true if err is worth retrying while ctx is still valid.
+func isTransient(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	// The request timed out but the collection cycle has not.
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var rerr kube.ReconcileError
+	return errors.As(err, &rerr) && rerr.IsTransient()
+}
+
+// aggregate groups samples by PVC and reconciles them into a single PVCDiskUsage per PVC.
+func (c DiskUsageCollector) aggregate(ctx context.Context, crd *v1alpha1.PodDiskInspector, samples []podSample) ([]PVCDiskUsage, error) {
+	var (
+		keys    []client.ObjectKey
+		grouped = make(map[client.ObjectKey][]podSample)
+		merr    error
+	)
+	for _, sample := range samples {
+		key := client.ObjectKey{Namespace: sample.pod.Namespace, Name: sample.resp.PvcName}
+		if _, ok := grouped[key]; !ok {
+			keys = append(keys, key)
+		}
+		grouped[key] = append(grouped[key], sample)
+	}
+
+	usage := make([]PVCDiskUsage, 0, len(keys))
+	for _, key := range keys {
+		group := grouped[key]
+
+		// Find matching PVC to capture its actual capacity
+		var pvc corev1.PersistentVolumeClaim
+		if err := c.client.Get(ctx, key, &pvc); err != nil {
+			merr = errors.Join(merr, fmt.Errorf("get pvc %s: %w", key, err))
+			continue
+		}
+
+		defaultSpec := crd.Spec.PVCScaling.DeepCopy()
+		// override default spec with pod annoations if present
+		c.overrideSpec(ctx, defaultSpec, group[0].pod)
+		// override default spec with pvc annoations if present
+		c.overrideSpec(ctx, defaultSpec, &pvc)
+
+		item := PVCDiskUsage{
+			Name:           key.Name,
+			Namespace:      key.Namespace,
+			Capacity:       pvc.Status.Capacity[corev1.ResourceStorage],
+			PVCScalingSpec: defaultSpec,
+			pvc:            &pvc,
+		}
+		for _, sample := range group {
+			if sample.resp.LogicalError != "" {
+				log.FromContext(ctx).Info("Usage probe failed, using statfs usage", "pvc", key.Name, "namespace", key.Namespace, "pod", sample.pod.Name, "error", sample.resp.LogicalError)
 			}
-			if len(nestedErr) > 0 {
-				errs[i] = errors.Join(nestedErr...)
+			item.Samples = append(item.Samples, DiskUsageSample{
+				Pod:         sample.pod.Name,
+				Node:        sample.pod.Spec.NodeName,
+				PercentUsed: percentUsed(sample.resp),
+				Logical:     sample.resp.LogicalUsedBytes != nil,
+			})
+		}
+		minSample := lo.MinBy(item.Samples, func(a, b DiskUsageSample) bool { return a.PercentUsed < b.PercentUsed })
+		maxSample := lo.MaxBy(item.Samples, func(a, b DiskUsageSample) bool { return a.PercentUsed > b.PercentUsed })
+		// Take the highest reading to err on the side of scaling.
+		item.PercentUsed = maxSample.PercentUsed
+		item.Divergent = maxSample.PercentUsed-minSample.PercentUsed > DivergenceThreshold
+
+		fastest := lo.MaxBy(group, func(a, b podSample) bool { return a.resp.GrowthBytesPerSecond > b.resp.GrowthBytesPerSecond })
+		item.GrowthBytesPerSecond = fastest.resp.GrowthBytesPerSecond
+		item.TimeToFull = time.Duration(fastest.resp.TimeToFullSeconds) * time.Second
+
+		for _, sample := range group {
+			size := requestedSize(item.Capacity, sample.resp)
+			if size.Cmp(item.Capacity) > 0 && size.Cmp(item.RequestedSize) > 0 {
+				item.RequestedSize = size
+				item.RequestReason = sample.resp.ResizeRequest.Reason
 			}
+		}
 
-			return nil
-		})
+		usage = append(usage, item)
 	}
+	return usage, merr
+}
 
-	_ = eg.Wait()
+// overrideSpec overrides spec with the annotations of obj. Malformed annotations keep the value of spec and are
+// reported as warning events on obj.
+func (c DiskUsageCollector) overrideSpec(ctx context.Context, spec *v1alpha1.PVCScalingSpec, obj client.Object) {
+	_, err := OverideSpec(spec, obj.GetAnnotations())
+	if err == nil {
+		return
+	}
+	log.FromContext(ctx).Info("Ignoring invalid annotations", "object", client.ObjectKeyFromObject(obj), "error", err)
+	if c.recorder != nil {
+		c.recorder.Event(obj, kube.EventWarning, "InvalidAnnotation", err.Error())
+	}
+}
 
-	errs = lo.Filter(errs, func(item error, _ int) bool {
-		return item != nil
-	})
-	if len(errs) == len(pods.Items) {
-		return nil, errors.Join(errs...)
+// requestedSize returns the capacity needed to satisfy the resize request of resp, zero if there is none.
+// Missing free space is added to the current capacity.
+func requestedSize(capacity resource.Quantity, resp healthcheck.DiskUsageResponse) resource.Quantity {
+	request := resp.ResizeRequest
+	if request == nil {
+		return resource.Quantity{}
+	}
+	size := resource.NewQuantity(int64(request.MinSizeBytes), resource.BinarySI)
+	if request.MinFreeBytes > resp.AvailableBytes {
+		needed := capacity.DeepCopy()
+		needed.Add(*resource.NewQuantity(int64(request.MinFreeBytes-resp.AvailableBytes), resource.BinarySI))
+		if needed.Cmp(*size) > 0 {
+			size = &needed
+		}
 	}
+	return *size
+}
 
-	return lo.Flatten(found), nil
+// selectPods returns the pods to query so that each PVC is sampled by at most MaxSamplesPerPVC pods, and the
+// remaining pods, which are queried if all selected pods mounting a PVC fail, see fallbackPods.
+// Ready pods are preferred, then pods are considered in name order so the selection is stable between cycles.
+// Pods which do not declare any PVC are always selected.
+func selectPods(pods []corev1.Pod) (selected, rest []*corev1.Pod) {
+	sorted := lo.Map(pods, func(_ corev1.Pod, i int) *corev1.Pod { return &pods[i] })
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if a, b := podReady(sorted[i]), podReady(sorted[j]); a != b {
+			return a
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+	return samplePods(sorted, nil)
 }
 
-func OverideSpec(defaultSpec *v1alpha1.PVCScalingSpec, annotations map[string]string) *v1alpha1.PVCScalingSpec {
-	if annotations[UsedSpacePercentage] != "" {
-		if num, err := strconv.ParseInt(annotations[UsedSpacePercentage], 10, 32); err == nil {
-			defaultSpec.UsedSpacePercentage = int32(num)
+// fallbackPods returns the pods of rest to query for the PVCs of the selected pods which failed and were not
+// sampled by any other pod, up to MaxSamplesPerPVC pods per PVC.
+func fallbackPods(rest, selected []*corev1.Pod, found [][]podSample, errs []error) []*corev1.Pod {
+	sampled := make(map[client.ObjectKey]bool)
+	for _, sample := range lo.Flatten(found) {
+		sampled[client.ObjectKey{Namespace: sample.pod.Namespace, Name: sample.resp.PvcName}] = true
+	}
+	unsampled := make(map[client.ObjectKey]bool)
+	for i, pod := range selected {
+		if errs[i] == nil {
+			continue
+		}
+		for _, claim := range podClaims(pod) {
+			if !sampled[claim] {
+				unsampled[claim] = true
+			}
 		}
 	}
-	if annotations[IncreaseQuantity] != "" {
-		defaultSpec.IncreaseQuantity = annotations[IncreaseQuantity]
+	if len(unsampled) == 0 {
+		return nil
 	}
-	if annotations[Cooldown] != "" {
-		if pd, err := time.ParseDuration(annotations[Cooldown]); err == nil {
-			defaultSpec.Cooldown = v1.Duration{Duration: pd}
+	fallback, _ := samplePods(rest, unsampled)
+	return fallback
+}
+
+// samplePods returns the pods mounting a PVC which is not yet sampled by MaxSamplesPerPVC pods, and the others.
+// If claims is not nil, only its PVCs are considered and pods without PVCs are skipped.
+func samplePods(pods []*corev1.Pod, claims map[client.ObjectKey]bool) (selected, rest []*corev1.Pod) {
+	counts := make(map[client.ObjectKey]int)
+	for _, pod := range pods {
+		mounted := podClaims(pod)
+		if claims != nil {
+			mounted = lo.Filter(mounted, func(claim client.ObjectKey, _ int) bool { return claims[claim] })
+		}
+		needed := (claims == nil && len(mounted) == 0) || lo.SomeBy(mounted, func(claim client.ObjectKey) bool {
+			return counts[claim] < MaxSamplesPerPVC
+		})
+		if !needed {
+			rest = append(rest, pod)
+			continue
+		}
+		for _, claim := range mounted {
+			counts[claim]++
+		}
+		selected = append(selected, pod)
+	}
+	return selected, rest
+}
+
+// podReady returns true if the pod's Ready condition is true.
+func podReady(pod *corev1.Pod) bool {
+	return lo.ContainsBy(pod.Status.Conditions, func(cond corev1.PodCondition) bool {
+		return cond.Type == corev1.PodReady && cond.Status == corev1.ConditionTrue
+	})
+}
+
+// podClaims returns the keys of the PVCs mounted by the pod, including those of generic ephemeral volumes.
+// PVCs are keyed by namespace too, as an inspector may cover pods in several namespaces.
+func podClaims(pod *corev1.Pod) []client.ObjectKey {
+	var claims []client.ObjectKey
+	for _, vol := range pod.Spec.Volumes {
+		if claim := inject.ClaimName(pod, vol); claim != "" {
+			claims = append(claims, client.ObjectKey{Namespace: pod.Namespace, Name: claim})
 		}
 	}
-	if annotations[MaxSize] != "" {
-		defaultSpec.MaxSize = resource.MustParse(annotations[MaxSize])
+	return lo.Uniq(claims)
+}
+
+// percentUsed returns the used space like df, i.e. relative to the space available to unprivileged users,
+// because the application is out of space once it has used up AvailableBytes even if blocks reserved for root are free.
+// The logical usage reported by the application's usage probe is preferred over the statfs usage.
+func percentUsed(resp healthcheck.DiskUsageResponse) int {
+	used := resp.AllBytes - resp.FreeBytes
+	size := used + resp.AvailableBytes
+	if size == 0 {
+		return 100
+	}
+	if resp.LogicalUsedBytes != nil {
+		used = *resp.LogicalUsedBytes
 	}
-	return defaultSpec
+	return int(math.Round((float64(used) / float64(size)) * 100))
 }
@@ -5,11 +5,14 @@ import (
 	"errors"
 	"fmt"
 	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/allthatjazzleo/pvc-autoscaler-operator/api/v1alpha1"
 	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/healthcheck"
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/kube"
 	"github.com/samber/lo"
 	"github.com/stretchr/testify/require"
 	corev1 "k8s.io/api/core/v1"
@@ -80,14 +83,15 @@ func TestCollectDiskUsage(t *testing.T) {
 			}
 			return []healthcheck.DiskUsageResponse{
 				{
-					PvcName:   pvc,
-					AllBytes:  1000,
-					FreeBytes: free,
+					PvcName:        pvc,
+					AllBytes:       1000,
+					FreeBytes:      free,
+					AvailableBytes: free,
 				},
 			}, nil
 		})
 
-		coll := NewDiskUsageCollector(diskClient, &reader)
+		coll := NewDiskUsageCollector(diskClient, &reader, DefaultCollectorOptions())
 		got, err := coll.CollectDiskUsage(ctx, &crd)
 
 		require.NoError(t, err)
@@ -111,6 +115,8 @@ func TestCollectDiskUsage(t *testing.T) {
 		result := got[0]
 		require.Equal(t, "pvc-poddiskinspector-sample-0", result.Name)
 		require.Equal(t, 10, result.PercentUsed)
+		require.Equal(t, []DiskUsageSample{{Pod: "poddiskinspector-sample-0", PercentUsed: 10}}, result.Samples)
+		require.False(t, result.Divergent)
 		require.Equal(t, resource.MustParse("500Gi"), result.Capacity)
 
 		result = got[1]
@@ -124,13 +130,534 @@ func TestCollectDiskUsage(t *testing.T) {
 		require.Equal(t, resource.MustParse("500Gi"), result.Capacity)
 	})
 
+	t.Run("shared pvc", func(t *testing.T) {
+		const shared = "pvc-shared"
+		pods := lo.Map(lo.Range(5), func(_ int, index int) corev1.Pod {
+			pod := validPods[0].DeepCopy()
+			pod.Name = fmt.Sprintf("shared-%d", index)
+			pod.Spec.NodeName = fmt.Sprintf("node-%d", index)
+			pod.Spec.Volumes[0].PersistentVolumeClaim.ClaimName = shared
+			pod.Status.PodIP = fmt.Sprintf("10.0.1.%d", index)
+			return *pod
+		})
+
+		var reader mockReader
+		reader.ObjectList = corev1.PodList{Items: pods}
+		reader.Object = corev1.PersistentVolumeClaim{
+			Status: corev1.PersistentVolumeClaimStatus{
+				Capacity: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("100Gi")},
+			},
+		}
+
+		var (
+			mu    sync.Mutex
+			hosts []string
+		)
+		diskClient := mockDiskUsager(func(ctx context.Context, host string) ([]healthcheck.DiskUsageResponse, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			hosts = append(hosts, host)
+			free := uint64(500)
+			if host == "http://10.0.1.1" {
+				free = 300
+			}
+			return []healthcheck.DiskUsageResponse{
+				{PvcName: shared, AllBytes: 1000, FreeBytes: free, AvailableBytes: free},
+			}, nil
+		})
+
+		coll := NewDiskUsageCollector(diskClient, &reader, DefaultCollectorOptions())
+		got, err := coll.CollectDiskUsage(ctx, &crd)
+
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{"http://10.0.1.0", "http://10.0.1.1", "http://10.0.1.2"}, hosts)
+
+		require.Len(t, got, 1)
+		result := got[0]
+		require.Equal(t, shared, result.Name)
+		require.Equal(t, 70, result.PercentUsed)
+		require.True(t, result.Divergent)
+		require.Equal(t, []DiskUsageSample{
+			{Pod: "shared-0", Node: "node-0", PercentUsed: 50},
+			{Pod: "shared-1", Node: "node-1", PercentUsed: 70},
+			{Pod: "shared-2", Node: "node-2", PercentUsed: 50},
+		}, result.Samples)
+	})
+
+	t.Run("shared pvc fallback", func(t *testing.T) {
+		const shared = "pvc-shared"
+		pods := lo.Map(lo.Range(5), func(_ int, index int) corev1.Pod {
+			pod := validPods[0].DeepCopy()
+			pod.Name = fmt.Sprintf("shared-%d", index)
+			pod.Spec.Volumes[0].PersistentVolumeClaim.ClaimName = shared
+			pod.Status.PodIP = fmt.Sprintf("10.0.1.%d", index)
+			return *pod
+		})
+		// Ready pods are preferred.
+		pods[4].Status.Conditions = []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}}
+
+		var reader mockReader
+		reader.ObjectList = corev1.PodList{Items: pods}
+		reader.Object = corev1.PersistentVolumeClaim{
+			Status: corev1.PersistentVolumeClaimStatus{
+				Capacity: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("100Gi")},
+			},
+		}
+
+		var (
+			mu    sync.Mutex
+			hosts []string
+		)
+		diskClient := mockDiskUsager(func(ctx context.Context, host string) ([]healthcheck.DiskUsageResponse, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			hosts = append(hosts, host)
+			if host != "http://10.0.1.3" {
+				return nil, errors.New("boom")
+			}
+			return []healthcheck.DiskUsageResponse{
+				{PvcName: shared, AllBytes: 1000, FreeBytes: 500, AvailableBytes: 500},
+			}, nil
+		})
+
+		coll := NewDiskUsageCollector(diskClient, &reader, DefaultCollectorOptions())
+		got, err := coll.CollectDiskUsage(ctx, &crd)
+
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{"http://10.0.1.4", "http://10.0.1.0", "http://10.0.1.1", "http://10.0.1.2", "http://10.0.1.3"}, hosts)
+		require.Len(t, got, 1)
+		require.Equal(t, []DiskUsageSample{{Pod: "shared-3", PercentUsed: 50}}, got[0].Samples)
+	})
+
+	t.Run("same pvc name in several namespaces", func(t *testing.T) {
+		const claim = "data"
+		var pods []corev1.Pod
+		for j, ns := range []string{"a", "b"} {
+			for i := 0; i < 4; i++ {
+				pod := validPods[0].DeepCopy()
+				pod.Namespace = ns
+				pod.Name = fmt.Sprintf("%s-%d", ns, i)
+				pod.Spec.Volumes[0].PersistentVolumeClaim.ClaimName = claim
+				pod.Status.PodIP = fmt.Sprintf("10.0.%d.%d", 2+j, i)
+				pods = append(pods, *pod)
+			}
+		}
+		const hostA, hostB = "http://10.0.2.", "http://10.0.3."
+
+		var reader mockReader
+		reader.ObjectList = corev1.PodList{Items: pods}
+		reader.Object = corev1.PersistentVolumeClaim{}
+
+		var (
+			mu    sync.Mutex
+			hosts []string
+			fail  func(host string) bool
+		)
+		diskClient := mockDiskUsager(func(ctx context.Context, host string) ([]healthcheck.DiskUsageResponse, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			hosts = append(hosts, host)
+			if fail(host) {
+				return nil, errors.New("boom")
+			}
+			free := lo.Ternary(strings.HasPrefix(host, hostA), uint64(500), uint64(100))
+			return []healthcheck.DiskUsageResponse{
+				{PvcName: claim, AllBytes: 1000, FreeBytes: free, AvailableBytes: free},
+			}, nil
+		})
+		coll := NewDiskUsageCollector(diskClient, &reader, DefaultCollectorOptions())
+
+		fail = func(string) bool { return false }
+		got, err := coll.CollectDiskUsage(ctx, &crd)
+
+		require.NoError(t, err)
+		require.ElementsMatch(t, []string{hostA + "0", hostA + "1", hostA + "2", hostB + "0", hostB + "1", hostB + "2"}, hosts)
+		require.Len(t, got, 2)
+		byNamespace := lo.SliceToMap(got, func(item PVCDiskUsage) (string, PVCDiskUsage) { return item.Namespace, item })
+		require.Equal(t, 50, byNamespace["a"].PercentUsed)
+		require.Equal(t, 90, byNamespace["b"].PercentUsed)
+		require.False(t, byNamespace["a"].Divergent)
+		require.False(t, byNamespace["b"].Divergent)
+
+		// The PVC of namespace a is sampled through its remaining pod, not through the PVC of namespace b.
+		hosts = nil
+		fail = func(host string) bool { return strings.HasPrefix(host, hostA) && host != hostA+"3" }
+		got, err = coll.CollectDiskUsage(ctx, &crd)
+
+		require.NoError(t, err)
+		require.Contains(t, hosts, hostA+"3")
+		require.Len(t, got, 2)
+	})
+
+	t.Run("growth trend", func(t *testing.T) {
+		var reader mockReader
+		reader.ObjectList = corev1.PodList{Items: validPods[:1]}
+		reader.Object = corev1.PersistentVolumeClaim{
+			Status: corev1.PersistentVolumeClaimStatus{
+				Capacity: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("100Gi")},
+			},
+		}
+
+		diskClient := mockDiskUsager(func(ctx context.Context, host string) ([]healthcheck.DiskUsageResponse, error) {
+			return []healthcheck.DiskUsageResponse{
+				{PvcName: pvcName(&crd, 0), AllBytes: 1000, FreeBytes: 500, AvailableBytes: 500, GrowthBytesPerSecond: 0.5, TimeToFullSeconds: 1000},
+			}, nil
+		})
+
+		coll := NewDiskUsageCollector(diskClient, &reader, DefaultCollectorOptions())
+		got, err := coll.CollectDiskUsage(ctx, &crd)
+
+		require.NoError(t, err)
+		require.Len(t, got, 1)
+		require.Equal(t, 0.5, got[0].GrowthBytesPerSecond)
+		require.Equal(t, 1000*time.Second, got[0].TimeToFull)
+	})
+
+	t.Run("resize request", func(t *testing.T) {
+		var reader mockReader
+		reader.ObjectList = corev1.PodList{Items: validPods[:1]}
+		reader.Object = corev1.PersistentVolumeClaim{
+			Status: corev1.PersistentVolumeClaimStatus{
+				Capacity: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("100Gi")},
+			},
+		}
+
+		var request *healthcheck.ResizeRequest
+		diskClient := mockDiskUsager(func(ctx context.Context, host string) ([]healthcheck.DiskUsageResponse, error) {
+			return []healthcheck.DiskUsageResponse{
+				{PvcName: pvcName(&crd, 0), AllBytes: 100 << 30, FreeBytes: 40 << 30, AvailableBytes: 40 << 30, ResizeRequest: request},
+			}, nil
+		})
+		coll := NewDiskUsageCollector(diskClient, &reader, DefaultCollectorOptions())
+
+		for _, tt := range []struct {
+			Name    string
+			Request healthcheck.ResizeRequest
+			Want    string
+		}{
+			{"min free", healthcheck.ResizeRequest{MinFreeBytes: 100 << 30, Reason: "compaction"}, "160Gi"},
+			{"min size", healthcheck.ResizeRequest{MinSizeBytes: 200 << 30}, "200Gi"},
+			{"larger of both", healthcheck.ResizeRequest{MinFreeBytes: 100 << 30, MinSizeBytes: 150 << 30}, "160Gi"},
+			{"satisfied", healthcheck.ResizeRequest{MinFreeBytes: 10 << 30, MinSizeBytes: 50 << 30}, "0"},
+		} {
+			request = &tt.Request
+
+			got, err := coll.CollectDiskUsage(ctx, &crd)
+
+			require.NoError(t, err, tt.Name)
+			require.Len(t, got, 1, tt.Name)
+			require.Equal(t, tt.Want, got[0].RequestedSize.String(), tt.Name)
+			require.Equal(t, tt.Request.Reason, got[0].RequestReason, tt.Name)
+		}
+	})
+
+	t.Run("logical usage", func(t *testing.T) {
+		var reader mockReader
+		reader.ObjectList = corev1.PodList{Items: validPods[:1]}
+		reader.Object = corev1.PersistentVolumeClaim{}
+
+		diskClient := mockDiskUsager(func(ctx context.Context, host string) ([]healthcheck.DiskUsageResponse, error) {
+			return []healthcheck.DiskUsageResponse{
+				// Preallocated files fill the volume, but the application only uses a fifth of it.
+				{PvcName: "pvc-poddiskinspector-sample-0", AllBytes: 1000, FreeBytes: 10, AvailableBytes: 10, LogicalUsedBytes: lo.ToPtr(uint64(200))},
+			}, nil
+		})
+
+		coll := NewDiskUsageCollector(diskClient, &reader, DefaultCollectorOptions())
+		got, err := coll.CollectDiskUsage(ctx, &crd)
+
+		require.NoError(t, err)
+		require.Len(t, got, 1)
+		require.Equal(t, 20, got[0].PercentUsed)
+		require.True(t, got[0].Samples[0].Logical)
+	})
+
+	t.Run("pod lister", func(t *testing.T) {
+		var reader mockReader
+		reader.ObjectList = corev1.PodList{}
+		reader.Object = corev1.PersistentVolumeClaim{}
+
+		diskClient := mockDiskUsager(func(ctx context.Context, host string) ([]healthcheck.DiskUsageResponse, error) {
+			return []healthcheck.DiskUsageResponse{
+				{PvcName: "pvc-poddiskinspector-sample-0", AllBytes: 100, FreeBytes: 60, AvailableBytes: 60},
+			}, nil
+		})
+
+		coll := NewDiskUsageCollector(diskClient, &reader, DefaultCollectorOptions()).
+			WithPodLister(func(ctx context.Context, got *v1alpha1.PodDiskInspector) ([]corev1.Pod, error) {
+				require.Equal(t, &crd, got)
+				return validPods[:1], nil
+			})
+		got, err := coll.CollectDiskUsage(ctx, &crd)
+
+		require.NoError(t, err)
+		require.Len(t, got, 1)
+		require.Equal(t, 40, got[0].PercentUsed)
+
+		coll = coll.WithPodLister(func(ctx context.Context, _ *v1alpha1.PodDiskInspector) ([]corev1.Pod, error) {
+			return nil, errors.New("boom")
+		})
+		_, err = coll.CollectDiskUsage(ctx, &crd)
+
+		require.EqualError(t, err, "list pods: boom")
+	})
+
+	t.Run("zero logical usage", func(t *testing.T) {
+		var reader mockReader
+		reader.ObjectList = corev1.PodList{Items: validPods[:1]}
+		reader.Object = corev1.PersistentVolumeClaim{}
+
+		diskClient := mockDiskUsager(func(ctx context.Context, host string) ([]healthcheck.DiskUsageResponse, error) {
+			return []healthcheck.DiskUsageResponse{
+				{PvcName: "pvc-poddiskinspector-sample-0", AllBytes: 1000, FreeBytes: 10, AvailableBytes: 10, LogicalUsedBytes: lo.ToPtr(uint64(0))},
+			}, nil
+		})
+
+		coll := NewDiskUsageCollector(diskClient, &reader, DefaultCollectorOptions())
+		got, err := coll.CollectDiskUsage(ctx, &crd)
+
+		require.NoError(t, err)
+		require.Len(t, got, 1)
+		require.Zero(t, got[0].PercentUsed)
+		require.True(t, got[0].Samples[0].Logical)
+	})
+
+	t.Run("excluded volumes", func(t *testing.T) {
+		pod := validPods[0].DeepCopy()
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+			Name: "scratch",
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "scratch-claim"},
+			},
+		})
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+			Name: "config",
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "config-claim"},
+			},
+		})
+		pod.Annotations[kube.ExcludeVolumes] = "config"
+
+		var reader mockReader
+		reader.ObjectList = corev1.PodList{Items: []corev1.Pod{*pod}}
+		reader.Object = corev1.PersistentVolumeClaim{}
+
+		diskClient := mockDiskUsager(func(ctx context.Context, host string) ([]healthcheck.DiskUsageResponse, error) {
+			// The sidecar was injected before the volumes were excluded.
+			return []healthcheck.DiskUsageResponse{
+				{PvcName: "pvc-poddiskinspector-sample-0", AllBytes: 1000, FreeBytes: 500, AvailableBytes: 500},
+				{PvcName: "scratch-claim", AllBytes: 1000, FreeBytes: 10, AvailableBytes: 10},
+				{PvcName: "config-claim", AllBytes: 1000, FreeBytes: 10, AvailableBytes: 10},
+			}, nil
+		})
+
+		withFilter := crd.DeepCopy()
+		withFilter.Spec.Volumes = &v1alpha1.VolumeFilter{Exclude: []string{"scratch-*"}}
+
+		coll := NewDiskUsageCollector(diskClient, &reader, DefaultCollectorOptions())
+		got, err := coll.CollectDiskUsage(ctx, withFilter)
+
+		require.NoError(t, err)
+		require.Len(t, got, 1)
+		require.Equal(t, "pvc-poddiskinspector-sample-0", got[0].Name)
+
+		// The pod's include patterns replace the inspector's.
+		pod.Annotations[kube.IncludeVolumes] = "scratch, config"
+		withFilter.Spec.Volumes = &v1alpha1.VolumeFilter{Include: []string{"vol-*"}}
+		reader.ObjectList = corev1.PodList{Items: []corev1.Pod{*pod}}
+
+		got, err = coll.CollectDiskUsage(ctx, withFilter)
+
+		require.NoError(t, err)
+		require.Len(t, got, 1)
+		require.Equal(t, "scratch-claim", got[0].Name)
+	})
+
+	t.Run("ephemeral volumes", func(t *testing.T) {
+		pod := validPods[0].DeepCopy()
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+			Name:         "scratch",
+			VolumeSource: corev1.VolumeSource{Ephemeral: &corev1.EphemeralVolumeSource{}},
+		})
+		// The ephemeral volume is matched by its name in the pod spec.
+		pod.Annotations[kube.IncludeVolumes] = "scratch"
+
+		var reader mockReader
+		reader.ObjectList = corev1.PodList{Items: []corev1.Pod{*pod}}
+		reader.Object = corev1.PersistentVolumeClaim{}
+
+		diskClient := mockDiskUsager(func(ctx context.Context, host string) ([]healthcheck.DiskUsageResponse, error) {
+			return []healthcheck.DiskUsageResponse{
+				{PvcName: "pvc-poddiskinspector-sample-0", AllBytes: 1000, FreeBytes: 500, AvailableBytes: 500},
+				{PvcName: "poddiskinspector-sample-0-scratch", AllBytes: 1000, FreeBytes: 100, AvailableBytes: 100},
+			}, nil
+		})
+
+		coll := NewDiskUsageCollector(diskClient, &reader, DefaultCollectorOptions())
+		got, err := coll.CollectDiskUsage(ctx, &crd)
+
+		require.NoError(t, err)
+		require.Len(t, got, 1)
+		require.Equal(t, "poddiskinspector-sample-0-scratch", got[0].Name)
+		require.Equal(t, 90, got[0].PercentUsed)
+		require.Equal(t, []client.ObjectKey{
+			{Namespace: namespace, Name: "pvc-poddiskinspector-sample-0"},
+			{Namespace: namespace, Name: "poddiskinspector-sample-0-scratch"},
+		}, podClaims(pod))
+	})
+
+	t.Run("reserved blocks", func(t *testing.T) {
+		var reader mockReader
+		reader.ObjectList = corev1.PodList{Items: validPods[:1]}
+		reader.Object = corev1.PersistentVolumeClaim{}
+
+		diskClient := mockDiskUsager(func(ctx context.Context, host string) ([]healthcheck.DiskUsageResponse, error) {
+			return []healthcheck.DiskUsageResponse{
+				// 5% of the blocks are reserved for root, so the volume is full for the application.
+				{PvcName: "pvc-poddiskinspector-sample-0", AllBytes: 1000, FreeBytes: 50, AvailableBytes: 0},
+			}, nil
+		})
+
+		coll := NewDiskUsageCollector(diskClient, &reader, DefaultCollectorOptions())
+		got, err := coll.CollectDiskUsage(ctx, &crd)
+
+		require.NoError(t, err)
+		require.Len(t, got, 1)
+		require.Equal(t, 100, got[0].PercentUsed)
+	})
+
+	t.Run("partial volume errors", func(t *testing.T) {
+		var reader mockReader
+		reader.ObjectList = corev1.PodList{Items: validPods[:1]}
+		reader.Object = corev1.PersistentVolumeClaim{}
+
+		var calls int
+		diskClient := mockDiskUsager(func(ctx context.Context, host string) ([]healthcheck.DiskUsageResponse, error) {
+			calls++
+			return []healthcheck.DiskUsageResponse{
+				{PvcName: "pvc-poddiskinspector-sample-0", AllBytes: 100, FreeBytes: 50, AvailableBytes: 50},
+			}, &healthcheck.VolumeError{Volumes: []healthcheck.DiskUsageResponse{{PvcName: "other", Error: "boom"}}}
+		})
+
+		coll := NewDiskUsageCollector(diskClient, &reader, DefaultCollectorOptions())
+		got, err := coll.CollectDiskUsage(ctx, &crd)
+
+		require.NoError(t, err)
+		require.Len(t, got, 1)
+		require.Equal(t, 50, got[0].PercentUsed)
+		require.Equal(t, 1, calls, "partial errors are not retried")
+	})
+
+	t.Run("retries transient errors", func(t *testing.T) {
+		var reader mockReader
+		reader.ObjectList = corev1.PodList{Items: validPods[:1]}
+		reader.Object = corev1.PersistentVolumeClaim{}
+
+		var calls int
+		diskClient := mockDiskUsager(func(ctx context.Context, host string) ([]healthcheck.DiskUsageResponse, error) {
+			calls++
+			if calls < 3 {
+				return nil, kube.TransientError(errors.New("connection refused"))
+			}
+			return []healthcheck.DiskUsageResponse{
+				{PvcName: "pvc-poddiskinspector-sample-0", AllBytes: 100, FreeBytes: 50, AvailableBytes: 50},
+			}, nil
+		})
+
+		opts := DefaultCollectorOptions()
+		opts.RetryBackoff = time.Millisecond
+		coll := NewDiskUsageCollector(diskClient, &reader, opts)
+		got, err := coll.CollectDiskUsage(ctx, &crd)
+
+		require.NoError(t, err)
+		require.Len(t, got, 1)
+		require.Equal(t, 3, calls)
+
+		// Gives up after all retries.
+		calls = -100
+		_, err = coll.CollectDiskUsage(ctx, &crd)
+
+		require.Error(t, err)
+		require.EqualError(t, err, "pod poddiskinspector-sample-0: connection refused")
+		require.Equal(t, -100+1+opts.Retries, calls)
+	})
+
+	t.Run("does not retry permanent errors", func(t *testing.T) {
+		var reader mockReader
+		reader.ObjectList = corev1.PodList{Items: validPods[:1]}
+
+		var calls int
+		diskClient := mockDiskUsager(func(ctx context.Context, host string) ([]healthcheck.DiskUsageResponse, error) {
+			calls++
+			return nil, errors.New("malformed json")
+		})
+
+		coll := NewDiskUsageCollector(diskClient, &reader, DefaultCollectorOptions())
+		_, err := coll.CollectDiskUsage(ctx, &crd)
+
+		require.Error(t, err)
+		require.Equal(t, 1, calls)
+	})
+
+	t.Run("bounded concurrency", func(t *testing.T) {
+		var reader mockReader
+		reader.ObjectList = corev1.PodList{Items: validPods}
+		reader.Object = corev1.PersistentVolumeClaim{}
+
+		var (
+			mu               sync.Mutex
+			running, maxSeen int
+		)
+		diskClient := mockDiskUsager(func(ctx context.Context, host string) ([]healthcheck.DiskUsageResponse, error) {
+			mu.Lock()
+			running++
+			maxSeen = lo.Max([]int{maxSeen, running})
+			mu.Unlock()
+
+			time.Sleep(10 * time.Millisecond)
+
+			mu.Lock()
+			running--
+			mu.Unlock()
+			return []healthcheck.DiskUsageResponse{{PvcName: "pvc", AllBytes: 100, FreeBytes: 50, AvailableBytes: 50}}, nil
+		})
+
+		opts := DefaultCollectorOptions()
+		opts.Workers = 1
+		coll := NewDiskUsageCollector(diskClient, &reader, opts)
+		_, err := coll.CollectDiskUsage(ctx, &crd)
+
+		require.NoError(t, err)
+		require.Equal(t, 1, maxSeen)
+	})
+
+	t.Run("cycle deadline", func(t *testing.T) {
+		var reader mockReader
+		reader.ObjectList = corev1.PodList{Items: validPods}
+
+		diskClient := mockDiskUsager(func(ctx context.Context, host string) ([]healthcheck.DiskUsageResponse, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		})
+
+		opts := DefaultCollectorOptions()
+		opts.Workers = 1
+		opts.Deadline = 10 * time.Millisecond
+		coll := NewDiskUsageCollector(diskClient, &reader, opts)
+		_, err := coll.CollectDiskUsage(ctx, &crd)
+
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "pod poddiskinspector-sample-0: context deadline exceeded")
+		require.Contains(t, err.Error(), "pod poddiskinspector-sample-2: collection deadline: context deadline exceeded")
+	})
+
 	t.Run("no pods found", func(t *testing.T) {
 		var reader mockReader
 		diskClient := mockDiskUsager(func(ctx context.Context, host string) ([]healthcheck.DiskUsageResponse, error) {
 			panic("should not be called")
 		})
 
-		coll := NewDiskUsageCollector(diskClient, &reader)
+		coll := NewDiskUsageCollector(diskClient, &reader, DefaultCollectorOptions())
 		_, err := coll.CollectDiskUsage(ctx, &crd)
 
 		require.Error(t, err)
@@ -147,7 +674,7 @@ func TestCollectDiskUsage(t *testing.T) {
 			panic("should not be called")
 		})
 
-		coll := NewDiskUsageCollector(diskClient, &reader)
+		coll := NewDiskUsageCollector(diskClient, &reader, DefaultCollectorOptions())
 		_, err := coll.CollectDiskUsage(ctx, &crd)
 
 		require.Error(t, err)
@@ -172,14 +699,15 @@ func TestCollectDiskUsage(t *testing.T) {
 			}
 			return []healthcheck.DiskUsageResponse{
 				{
-					PvcName:   pvc,
-					AllBytes:  100,
-					FreeBytes: 100,
+					PvcName:        pvc,
+					AllBytes:       100,
+					FreeBytes:      100,
+					AvailableBytes: 100,
 				},
 			}, nil
 		})
 
-		coll := NewDiskUsageCollector(diskClient, &reader)
+		coll := NewDiskUsageCollector(diskClient, &reader, DefaultCollectorOptions())
 		got, err := coll.CollectDiskUsage(ctx, &crd)
 
 		require.NoError(t, err)
@@ -211,7 +739,7 @@ func TestCollectDiskUsage(t *testing.T) {
 
 		var crd v1alpha1.PodDiskInspector
 
-		coll := NewDiskUsageCollector(diskClient, &reader)
+		coll := NewDiskUsageCollector(diskClient, &reader, DefaultCollectorOptions())
 		_, err := coll.CollectDiskUsage(ctx, &crd)
 
 		require.Error(t, err)
@@ -0,0 +1,92 @@
+package pvc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/healthcheck"
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/kube"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// TopDirsAnnotation is set on the event recorded for a PVC when it is resized.
+// Its value is the JSON encoded healthcheck.TopResponse of the PVC just before the resize.
+const TopDirsAnnotation = "pvc-autoscaler-operator.kubernetes.io/top-dirs"
+
+const (
+	snapshotTimeout = time.Minute
+	// snapshotSummary is the number of directories listed in the event message.
+	snapshotSummary = 3
+)
+
+// TopDirser fetches the largest directories of a PVC.
+type TopDirser interface {
+	TopDirs(ctx context.Context, host string, pvc string, depth, n int) (healthcheck.TopResponse, error)
+}
+
+// ResizeSnapshotter records the largest directories of a PVC when it is resized,
+// to help answer what filled the volume.
+type ResizeSnapshotter struct {
+	reader   client.Reader
+	dirs     TopDirser
+	recorder record.EventRecorder
+}
+
+func NewResizeSnapshotter(reader client.Reader, dirs TopDirser, recorder record.EventRecorder) *ResizeSnapshotter {
+	return &ResizeSnapshotter{
+		reader:   reader,
+		dirs:     dirs,
+		recorder: recorder,
+	}
+}
+
+// Snapshot captures the largest directories of the resized PVC in the background, because walking
+// a large volume can take a while, and records them as an annotated event on the PVC.
+// It implements ResizeHook.
+func (s *ResizeSnapshotter) Snapshot(ctx context.Context, usage PVCDiskUsage, newSize resource.Quantity) {
+	logger := log.FromContext(ctx)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), snapshotTimeout)
+		defer cancel()
+		if err := s.capture(ctx, usage, newSize); err != nil {
+			logger.Info("Failed to capture largest directories of resized pvc", "pvc", usage.Name, "namespace", usage.Namespace, "error", err.Error())
+		}
+	}()
+}
+
+func (s *ResizeSnapshotter) capture(ctx context.Context, usage PVCDiskUsage, newSize resource.Quantity) error {
+	if usage.pvc == nil || len(usage.Samples) == 0 {
+		return nil
+	}
+
+	var pod corev1.Pod
+	if err := s.reader.Get(ctx, client.ObjectKey{Namespace: usage.Namespace, Name: usage.Samples[0].Pod}, &pod); err != nil {
+		return fmt.Errorf("get pod: %w", err)
+	}
+	top, err := s.dirs.TopDirs(healthcheck.WithNamespace(ctx, pod.Namespace), "http://"+pod.Status.PodIP, usage.Name, healthcheck.DefaultTopDepth, healthcheck.DefaultTopEntries)
+	if err != nil {
+		return fmt.Errorf("top dirs: %w", err)
+	}
+	b, err := json.Marshal(top)
+	if err != nil {
+		return fmt.Errorf("marshal top dirs: %w", err)
+	}
+
+	summary := make([]string, 0, snapshotSummary)
+	for i, dir := range top.Dirs {
+		if i == snapshotSummary {
+			break
+		}
+		summary = append(summary, fmt.Sprintf("%s (%s)", dir.Path, resource.NewQuantity(int64(dir.Bytes), resource.BinarySI)))
+	}
+	s.recorder.AnnotatedEventf(usage.pvc, map[string]string{TopDirsAnnotation: string(b)}, kube.EventNormal, "PVCAutoScaleTopDirs",
+		"Resized to %s at %d%% used, largest directories: %s", newSize.String(), usage.PercentUsed, strings.Join(summary, ", "))
+	return nil
+}
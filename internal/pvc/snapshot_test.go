@@ -0,0 +1,83 @@
+package pvc
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/allthatjazzleo/pvc-autoscaler-operator/internal/healthcheck"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+type mockTopDirser func(ctx context.Context, host string, pvc string, depth, n int) (healthcheck.TopResponse, error)
+
+func (fn mockTopDirser) TopDirs(ctx context.Context, host string, pvc string, depth, n int) (healthcheck.TopResponse, error) {
+	return fn(ctx, host, pvc, depth, n)
+}
+
+func TestResizeSnapshotter_Capture(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+
+	type mockReader = mockClient[*corev1.Pod]
+
+	var pod corev1.Pod
+	pod.Name = "pod-0"
+	pod.Namespace = "default"
+	pod.Status.PodIP = "10.0.0.1"
+
+	usage := PVCDiskUsage{
+		Name:        "pvc-0",
+		Namespace:   "default",
+		PercentUsed: 91,
+		Samples:     []DiskUsageSample{{Pod: "pod-0", PercentUsed: 91}},
+		pvc:         &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: "pvc-0", Namespace: "default"}},
+	}
+
+	t.Run("happy path", func(t *testing.T) {
+		var reader mockReader
+		reader.Object = pod
+		recorder := record.NewFakeRecorder(1)
+
+		dirs := mockTopDirser(func(ctx context.Context, host string, pvc string, depth, n int) (healthcheck.TopResponse, error) {
+			require.Equal(t, "http://10.0.0.1", host)
+			require.Equal(t, "pvc-0", pvc)
+			return healthcheck.TopResponse{
+				PvcName: "pvc-0",
+				Dirs: []healthcheck.DirUsage{
+					{Path: "wal", Bytes: 2 << 30},
+					{Path: "data", Bytes: 1 << 30},
+				},
+			}, nil
+		})
+
+		err := NewResizeSnapshotter(&reader, dirs, recorder).capture(ctx, usage, resource.MustParse("120Gi"))
+
+		require.NoError(t, err)
+		require.Equal(t, "default/pod-0", reader.GetObjectKey.String())
+		event := <-recorder.Events
+		require.Contains(t, event, "Normal PVCAutoScaleTopDirs Resized to 120Gi at 91% used, largest directories: wal (2Gi), data (1Gi)")
+		require.Contains(t, event, TopDirsAnnotation)
+		require.Contains(t, event, `"path":"wal"`)
+	})
+
+	t.Run("top dirs error", func(t *testing.T) {
+		var reader mockReader
+		reader.Object = pod
+		recorder := record.NewFakeRecorder(1)
+
+		dirs := mockTopDirser(func(context.Context, string, string, int, int) (healthcheck.TopResponse, error) {
+			return healthcheck.TopResponse{}, errors.New("rate limited")
+		})
+
+		err := NewResizeSnapshotter(&reader, dirs, recorder).capture(ctx, usage, resource.MustParse("120Gi"))
+
+		require.EqualError(t, err, "top dirs: rate limited")
+		require.Empty(t, recorder.Events)
+	})
+}